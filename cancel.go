@@ -0,0 +1,220 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// chooseUpcomingAppointment is a helper function that lists the user's upcoming appointments and asks them to pick one.
+// false is returned if the user has no upcoming appointments or chooses to go back.
+func chooseUpcomingAppointment(scanner *bufio.Scanner, db *sql.DB, userID int) (appointment, bool) {
+	appts, err := queryAppointments(db, upcomingWhere+"ORDER BY a.appointment_time, a.id", userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return appointment{}, false
+	}
+
+	if len(appts) == 0 {
+		fmt.Println("You have no upcoming appointments.")
+		return appointment{}, false
+	}
+
+	for {
+		fmt.Println("Please choose an appointment:")
+		printAppointments(appts, 0, compactFormat)
+		fmt.Printf("%d. Back\n", len(appts)+1)
+		fmt.Print("> ")
+
+		scanner.Scan()
+		choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil || choice < 1 || choice > len(appts)+1 {
+			fmt.Println("Error: please select one of the appointments displayed")
+			continue
+		}
+
+		if choice == len(appts)+1 {
+			return appointment{}, false
+		}
+		return appts[choice-1], true
+	}
+}
+
+// cancelAppointment is called when the user selects "Cancel an appointment" in the appointment menu.
+// Once the user confirms, the appointment is marked as cancelled and its slot is offered to the waitlist.
+func cancelAppointment(scanner *bufio.Scanner, db *sql.DB, channels []channel, userID int) {
+	a, ok := chooseUpcomingAppointment(scanner, db, userID)
+	if !ok {
+		return
+	}
+
+	var wholeSeries bool
+	if a.series != nil {
+		for {
+			w, err := getSeriesScope(scanner, "cancel")
+			if err == nil {
+				wholeSeries = w
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	question := "Are you sure you want to cancel " + a.pet.name + "'s appointment?"
+	if wholeSeries {
+		question = "Are you sure you want to cancel " + a.pet.name + "'s upcoming appointments in this series?"
+	}
+
+	if warning := lateCancelWarning(a.dateTime, time.Now()); warning != "" {
+		fmt.Println(warning)
+	}
+
+	var confirmed bool
+	for {
+		c, err := getYesNo(scanner, question)
+		if err == nil {
+			confirmed = c
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	if !confirmed {
+		fmt.Println("Appointment not cancelled.")
+		return
+	}
+
+	if wholeSeries {
+		cancelSeries(db, channels, userID, a.series.id)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	late := clinicPrices.CancellationPolicy.isLate(a.dateTime, now)
+
+	res, err := tx.Exec(
+		`UPDATE appointments
+		 SET status = 'Cancelled', cancelled_at = $3, late_cancellation = $4
+		 WHERE id = $1 AND user_id = $2 AND status = 'Booked'`,
+		a.id,
+		userID,
+		now,
+		late,
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		fmt.Println("Error: this appointment can no longer be cancelled")
+		return
+	}
+
+	refund, fee, err := settleCancellation(tx, userID, a.id, a.dateTime, now, late)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Appointment cancelled.")
+	if refund > 0 {
+		fmt.Println("You will be refunded", formatMoney(refund, clinicPrices.Currency)+".")
+	}
+	if fee > 0 {
+		fmt.Println("A late cancellation fee of", formatMoney(fee, clinicPrices.Currency), "has been added to your account.")
+	}
+
+	releaseSlot(db, channels, a.vet, a.dateTime)
+}
+
+// rescheduleAppointment is called when the user selects "Reschedule an appointment" in the appointment menu.
+// The appointment keeps its vet and moves to a new free time, a new confirmation is sent, and the old slot is offered to the waitlist.
+// When the appointment is part of a series, the user can choose to move the rest of the series along with it.
+func rescheduleAppointment(scanner *bufio.Scanner, db *sql.DB, channels []channel, userID int) {
+	a, ok := chooseUpcomingAppointment(scanner, db, userID)
+	if !ok {
+		return
+	}
+
+	var wholeSeries bool
+	if a.series != nil {
+		for {
+			w, err := getSeriesScope(scanner, "reschedule")
+			if err == nil {
+				wholeSeries = w
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	old := a.dateTime
+
+	dt, holdID, _ := getAvailableDateTime(scanner, db, userID, 0, a, nil, false)
+	a.dateTime = dt
+	a.holdID = holdID
+
+	if wholeSeries {
+		rescheduleSeries(scanner, db, channels, userID, a, old)
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := releaseHolds(tx, []appointment{a}); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	res, err := tx.Exec(
+		`UPDATE appointments
+		 SET appointment_time = $1
+		 WHERE id = $2 AND user_id = $3 AND status = 'Booked'`,
+		a.dateTime,
+		a.id,
+		userID,
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		fmt.Println("Error: this appointment can no longer be rescheduled")
+		return
+	}
+
+	text, err := queueConfirmation(tx, channels, a.owner, a)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	showConfirmations(db, channels, []string{text})
+
+	releaseSlot(db, channels, a.vet, old)
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+	"time"
+)
+
+// cancellationPolicy is a struct that holds the clinic's rules for late cancellations and no-shows, loaded from the price list.
+//   - Cancelling at least freeNoticeHours before an appointment is free; later than that the late cancellation fee is charged.
+//   - An appointment still booked noShowAfterMinutes after it started, because the pet was never checked in, is marked as a no-show and the no-show fee is charged.
+//   - Owners with strikeThreshold or more late cancellations and no-shows in the last strikePeriodDays must pay a deposit of strikeDepositPercent on every booking.
+//     A threshold of 0 turns this off.
+//
+// Fees are charged as they are listed, without tax, and are reduced by anything already kept from the owner's deposit.
+type cancellationPolicy struct {
+	FreeNoticeHours      int    `json:"freeNoticeHours"`
+	LateCancelFee        string `json:"lateCancelFee"`
+	NoShowFee            string `json:"noShowFee"`
+	NoShowAfterMinutes   int    `json:"noShowAfterMinutes"`
+	StrikeThreshold      int    `json:"strikeThreshold"`
+	StrikePeriodDays     int    `json:"strikePeriodDays"`
+	StrikeDepositPercent int    `json:"strikeDepositPercent"`
+
+	// The fees in minor units, decoded when the price list is loaded.
+	lateCancelFee int64
+	noShowFee     int64
+}
+
+// validate checks the policy's settings and decodes its fees.
+func (c *cancellationPolicy) validate() error {
+	if c.FreeNoticeHours < 0 {
+		return fmt.Errorf("freeNoticeHours cannot be negative")
+	}
+	if c.NoShowAfterMinutes < 0 {
+		return fmt.Errorf("noShowAfterMinutes cannot be negative")
+	}
+	if c.StrikeThreshold < 0 || c.StrikePeriodDays < 0 {
+		return fmt.Errorf("strikeThreshold and strikePeriodDays cannot be negative")
+	}
+	if c.StrikeThreshold > 0 && c.StrikePeriodDays == 0 {
+		return fmt.Errorf("strikePeriodDays must be set when strikeThreshold is")
+	}
+	if c.StrikeDepositPercent < 0 || c.StrikeDepositPercent > 100 {
+		return fmt.Errorf("strikeDepositPercent must be between 0 and 100")
+	}
+
+	for _, fee := range []struct {
+		name   string
+		value  string
+		amount *int64
+	}{
+		{"lateCancelFee", c.LateCancelFee, &c.lateCancelFee},
+		{"noShowFee", c.NoShowFee, &c.noShowFee},
+	} {
+		if fee.value == "" {
+			continue
+		}
+		amount, err := parseMoney(fee.value)
+		if err != nil || amount < 0 {
+			return fmt.Errorf("invalid %s %q", fee.name, fee.value)
+		}
+		*fee.amount = amount
+	}
+	return nil
+}
+
+// isLate reports whether cancelling an appointment at t now counts as a late cancellation.
+func (c *cancellationPolicy) isLate(t, now time.Time) bool {
+	return t.Sub(now) < time.Duration(c.FreeNoticeHours)*time.Hour
+}
+
+// lateCancelWarning describes the fee for cancelling an appointment at t now, for showing before the owner confirms.
+// An empty string is returned when cancelling is free.
+func lateCancelWarning(t, now time.Time) string {
+	policy := clinicPrices.CancellationPolicy
+	if !policy.isLate(t, now) || policy.lateCancelFee == 0 {
+		return ""
+	}
+	return fmt.Sprintf("This is less than %d hours before the appointment, so a late cancellation fee of %s applies.",
+		policy.FreeNoticeHours, formatMoney(policy.lateCancelFee, clinicPrices.Currency))
+}
+
+// issueFeeInvoice charges a fee for an appointment, such as a late cancellation or no-show fee, as a numbered invoice inside the caller's transaction.
+func issueFeeInvoice(tx *sql.Tx, userID int, appointmentID int, description string, fee int64) (invoice, error) {
+	inv := invoice{
+		appointmentID: appointmentID,
+		userID:        userID,
+		currency:      clinicPrices.Currency,
+		lines:         []invoiceLine{{description: description, quantity: 1, unitPrice: fee}},
+	}
+	inv.calculate()
+
+	return inv, insertInvoice(tx, &inv)
+}
+
+// settleCancellation refunds what was paid for a cancelled appointment and, if it was cancelled late, charges the late cancellation fee,
+// all inside the caller's transaction.
+// The amount refunded and the fee charged are returned.
+func settleCancellation(tx *sql.Tx, userID int, appointmentID int, t time.Time, now time.Time, late bool) (int64, int64, error) {
+	paid, err := paidForAppointment(tx, appointmentID)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	refund, err := refundCancellation(tx, userID, appointmentID, t, now)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	if !late {
+		return refund, 0, nil
+	}
+
+	// Whatever the clinic already kept from the deposit counts towards the fee.
+	fee := clinicPrices.CancellationPolicy.lateCancelFee - (max(paid, 0) - refund)
+	if fee <= 0 {
+		return refund, 0, nil
+	}
+
+	if _, err := issueFeeInvoice(tx, userID, appointmentID, "Late cancellation fee", fee); err != nil {
+		return 0, 0, err
+	}
+	return refund, fee, nil
+}
+
+// markNoShows marks every appointment that is still booked noShowAfterMinutes after it started as a no-show, and charges the no-show fee.
+// An appointment moves on from "Booked" once the pet is checked in, so one that is still booked was never checked in.
+// Only checked-in appointments can be completed, so nothing marked here could still have been completed by staff.
+// Any deposit paid for a no-show is kept and counts towards the fee.
+// The number of appointments marked is returned.
+func markNoShows(db *sql.DB) (int, error) {
+	policy := clinicPrices.CancellationPolicy
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(
+		`UPDATE appointments
+		 SET status = 'No-show'
+		 WHERE status = 'Booked' AND appointment_time < now() - $1 * interval '1 minute'
+		 RETURNING id, user_id`,
+		policy.NoShowAfterMinutes,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type noShow struct{ id, userID int }
+	var marked []noShow
+	for rows.Next() {
+		var n noShow
+		if err := rows.Scan(&n.id, &n.userID); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		marked = append(marked, n)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, n := range marked {
+		paid, err := paidForAppointment(tx, n.id)
+		if err != nil {
+			return 0, err
+		}
+		if paid > 0 {
+			err := insertPayment(tx, payment{
+				userID:        n.userID,
+				appointmentID: sql.NullInt64{Int64: int64(n.id), Valid: true},
+				kind:          "Retained",
+				amount:        paid,
+				reference:     "Kept for a missed appointment",
+			})
+			if err != nil {
+				return 0, err
+			}
+		}
+
+		if fee := policy.noShowFee - max(paid, 0); fee > 0 {
+			if _, err := issueFeeInvoice(tx, n.userID, n.id, "No-show fee", fee); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	return len(marked), tx.Commit()
+}
+
+// strikeCounts is a struct that holds how many late cancellations and no-shows an owner has had within the strike period.
+type strikeCounts struct {
+	lateCancellations int
+	noShows           int
+}
+
+// total is the number of strikes counted towards the booking restriction.
+func (s strikeCounts) total() int {
+	return s.lateCancellations + s.noShows
+}
+
+// getStrikeCounts counts an owner's late cancellations and no-shows for appointments within the last strikePeriodDays.
+// When the policy has no period, every appointment is counted.
+func getStrikeCounts(db rowQueryer, userID int) (strikeCounts, error) {
+	days := clinicPrices.CancellationPolicy.StrikePeriodDays
+	if days == 0 {
+		days = 100 * 365
+	}
+
+	var s strikeCounts
+	err := db.QueryRow(
+		`SELECT
+			COUNT(*) FILTER (WHERE status = 'Cancelled' AND late_cancellation),
+			COUNT(*) FILTER (WHERE status = 'No-show')
+		 FROM appointments
+		 WHERE user_id = $1 AND appointment_time > now() - $2 * interval '1 day'`,
+		userID,
+		days,
+	).Scan(&s.lateCancellations, &s.noShows)
+	return s, err
+}
+
+// isRestricted reports whether an owner has reached the strike threshold, so every booking they make needs a deposit.
+func isRestricted(db rowQueryer, userID int) (bool, error) {
+	policy := clinicPrices.CancellationPolicy
+	if policy.StrikeThreshold == 0 || policy.StrikeDepositPercent == 0 {
+		return false, nil
+	}
+
+	s, err := getStrikeCounts(db, userID)
+	if err != nil {
+		return false, err
+	}
+	return s.total() >= policy.StrikeThreshold, nil
+}
+
+// strikesString describes an owner's late cancellations and no-shows, and whether their bookings are restricted.
+func strikesString(s strikeCounts) string {
+	policy := clinicPrices.CancellationPolicy
+
+	period := "in total"
+	if policy.StrikePeriodDays > 0 {
+		period = fmt.Sprintf("in the last %d days", policy.StrikePeriodDays)
+	}
+
+	str := fmt.Sprintf("Late cancellations: %d, no-shows: %d %s.\n", s.lateCancellations, s.noShows, period)
+	if policy.StrikeThreshold > 0 && policy.StrikeDepositPercent > 0 && s.total() >= policy.StrikeThreshold {
+		str += fmt.Sprintf("A deposit of %d%% is needed for every booking.\n", policy.StrikeDepositPercent)
+	}
+	return str
+}
+
+// ownerStrikes is a struct that holds one owner's line in the strikes report.
+type ownerStrikes struct {
+	userID int
+	owner  user
+	counts strikeCounts
+}
+
+// getOwnerStrikes fetches every owner with at least one late cancellation or no-show within the strike period, most strikes first.
+func getOwnerStrikes(db queryer) ([]ownerStrikes, error) {
+	days := clinicPrices.CancellationPolicy.StrikePeriodDays
+	if days == 0 {
+		days = 100 * 365
+	}
+
+	rows, err := db.Query(
+		`SELECT u.id, u.first_name, u.last_name, u.phone, u.email, s.late, s.no_shows
+		 FROM (
+			SELECT user_id,
+				COUNT(*) FILTER (WHERE status = 'Cancelled' AND late_cancellation) AS late,
+				COUNT(*) FILTER (WHERE status = 'No-show') AS no_shows
+			FROM appointments
+			WHERE appointment_time > now() - $1 * interval '1 day'
+			GROUP BY user_id
+		 ) s
+		 JOIN users u ON u.id = s.user_id
+		 WHERE s.late + s.no_shows > 0
+		 ORDER BY s.late + s.no_shows DESC, u.id`,
+		days,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var strikes []ownerStrikes
+	for rows.Next() {
+		var o ownerStrikes
+		err := rows.Scan(&o.userID, &o.owner.firstName, &o.owner.lastName, &o.owner.phone, &o.owner.email,
+			&o.counts.lateCancellations, &o.counts.noShows)
+		if err != nil {
+			return nil, err
+		}
+		strikes = append(strikes, o)
+	}
+	return strikes, rows.Err()
+}
+
+// ownerStrikesString prints the strikes report, flagging owners whose bookings are restricted.
+func ownerStrikesString(strikes []ownerStrikes) string {
+	policy := clinicPrices.CancellationPolicy
+
+	var s string
+	s = "-------------------------------------\n"
+	s += "Late cancellations and no-shows:\n"
+
+	if len(strikes) == 0 {
+		s += "None.\n"
+	}
+	for _, o := range strikes {
+		flag := ""
+		if policy.StrikeThreshold > 0 && policy.StrikeDepositPercent > 0 && o.counts.total() >= policy.StrikeThreshold {
+			flag = "deposit required"
+		}
+		s += fmt.Sprintf("%6d  %-30s late: %-3d no-shows: %-3d %s\n",
+			o.userID, o.owner.firstName+" "+o.owner.lastName, o.counts.lateCancellations, o.counts.noShows, flag)
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// runNoShowsCommand handles the "noshows" command.
+//   - noshows run: mark appointments that were never checked in as no-shows and charge the fee
+//   - noshows report: print the owners with late cancellations or no-shows
+//   - noshows owner <login ID>: print one owner's counts
+func runNoShowsCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: noshows run|report|owner <login ID>")
+	}
+
+	switch args[0] {
+	case "run":
+		n, err := markNoShows(db)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Appointments marked as no-shows:", n)
+		return nil
+
+	case "report":
+		strikes, err := getOwnerStrikes(db)
+		if err != nil {
+			return err
+		}
+		fmt.Print(ownerStrikesString(strikes))
+		return nil
+
+	case "owner":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: noshows owner <login ID>")
+		}
+		userID, err := strconv.Atoi(args[1])
+		if err != nil || userID <= 0 {
+			return fmt.Errorf("login ID must be a positive number")
+		}
+		s, err := getStrikeCounts(db, userID)
+		if err != nil {
+			return err
+		}
+		fmt.Print(strikesString(s))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown noshows command %q, expected run, report or owner", args[0])
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	// The embedded timezone database lets the clinic timezone load on machines without one installed, such as Windows.
+	_ "time/tzdata"
+)
+
+// clinicLocation is the timezone the clinic operates in.
+// Appointment times entered by users are read in this timezone and are always displayed in it.
+var clinicLocation = time.Local
+
+// loadClinicLocation reads the clinic's IANA timezone name (for example "Europe/London") from the CLINIC_TIMEZONE environment variable.
+// If the variable is not set, the machine's local timezone is used.
+func loadClinicLocation() (*time.Location, error) {
+	name := os.Getenv("CLINIC_TIMEZONE")
+	if name == "" {
+		return time.Local, nil
+	}
+
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CLINIC_TIMEZONE %q: %w", name, err)
+	}
+	return loc, nil
+}
+
+// clinicToday returns midnight at the start of the current day in the clinic's timezone.
+func clinicToday() time.Time {
+	now := time.Now().In(clinicLocation)
+	return time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, clinicLocation)
+}
+
+// clinicDate turns a DATE column, which the driver returns as midnight UTC, into midnight on the same day in the clinic's timezone.
+// This lets stored dates be compared with clinicToday.
+func clinicDate(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, clinicLocation)
+}
+
+// clinicTimes returns every instant at which the clinic's clocks show the given wall-clock time.
+// Usually there is exactly one.
+// There are none when the time falls in the gap skipped as clocks go forward, and two when it falls in the hour repeated as clocks go back.
+func clinicTimes(year int, month time.Month, day, hour, min int) []time.Time {
+	wall := time.Date(year, month, day, hour, min, 0, 0, time.UTC)
+
+	// Every UTC offset in use around the requested time is tried, and the ones that give back the same wall-clock time are kept.
+	offsets := map[int]bool{}
+	for _, probe := range []time.Duration{-24 * time.Hour, 0, 24 * time.Hour} {
+		_, offset := wall.Add(probe).In(clinicLocation).Zone()
+		offsets[offset] = true
+	}
+
+	var times []time.Time
+	for offset := range offsets {
+		t := wall.Add(-time.Duration(offset) * time.Second).In(clinicLocation)
+		if t.Year() == year && t.Month() == month && t.Day() == day && t.Hour() == hour && t.Minute() == min {
+			times = append(times, t)
+		}
+	}
+
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+// zoneString describes the timezone in effect at t, for example "BST (UTC+01:00)".
+func zoneString(t time.Time) string {
+	name, offset := t.Zone()
+
+	sign := "+"
+	if offset < 0 {
+		sign = "-"
+		offset = -offset
+	}
+	return fmt.Sprintf("%s (UTC%s%02d:%02d)", name, sign, offset/3600, offset%3600/60)
+}
+
+// getAmbiguousTimeChoice is a helper function that asks the user which of two instants they meant when a time happens twice as clocks go back.
+func getAmbiguousTimeChoice(scanner *bufio.Scanner, times []time.Time) (time.Time, error) {
+	fmt.Println("That time happens twice on this day because the clocks go back. Which did you mean?")
+
+	for i, t := range times {
+		fmt.Printf("%d. %s %s\n", i+1, t.Format("15:04"), zoneString(t))
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > len(times) {
+		return time.Time{}, fmt.Errorf("please select one of the times displayed")
+	}
+
+	return times[choice-1], nil
+}
+
+// resolveClinicTime turns a wall-clock time entered by the user into a single instant in the clinic's timezone.
+// wall only carries the date and clock reading, so its location is ignored.
+// A time skipped by a daylight saving change is rejected, and the user is asked to pick when a time is repeated.
+func resolveClinicTime(scanner *bufio.Scanner, wall time.Time) (time.Time, error) {
+	times := clinicTimes(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute())
+
+	switch len(times) {
+	case 0:
+		return time.Time{}, fmt.Errorf("%s does not exist in %s because the clocks go forward, please choose another time",
+			wall.Format("2006-01-02 15:04"), clinicLocation)
+	case 1:
+		return times[0], nil
+	}
+
+	for {
+		t, err := getAmbiguousTimeChoice(scanner, times)
+		if err == nil {
+			return t, nil
+		}
+		fmt.Println("Error:", err)
+	}
+}
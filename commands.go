@@ -0,0 +1,53 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// runCommand runs a command given on the command line, such as "reminders run", instead of the interactive menus.
+func runCommand(db *sql.DB, args []string) error {
+	switch args[0] {
+	case "reminders":
+		return runRemindersCommand(db, args[1:])
+
+	case "invoices":
+		return runInvoicesCommand(db, args[1:])
+
+	case "payments":
+		return runPaymentsCommand(db, args[1:])
+
+	case "rules":
+		return runRulesCommand(args[1:])
+
+	case "vaccinations":
+		return runVaccinationsCommand(db, args[1:])
+
+	case "inventory":
+		return runInventoryCommand(db, args[1:])
+
+	case "documents":
+		return runDocumentsCommand(db, args[1:])
+
+	case "consent":
+		return runConsentCommand(db, args[1:])
+
+	case "history":
+		return runHistoryCommand(db, args[1:])
+
+	case "prescriptions":
+		return runPrescriptionsCommand(db, args[1:])
+
+	case "register":
+		return runRegisterCommand(db, args[1:])
+
+	case "reception":
+		return runReceptionCommand(db, args[1:])
+
+	case "noshows":
+		return runNoShowsCommand(db, args[1:])
+
+	default:
+		return fmt.Errorf("unknown command %q", args[0])
+	}
+}
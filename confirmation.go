@@ -0,0 +1,190 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	htmltemplate "html/template"
+	"io/fs"
+	"os"
+	"path/filepath"
+	texttemplate "text/template"
+	"time"
+)
+
+// defaultTemplates holds the templates shipped with the program.
+// Any of them can be replaced by putting a file with the same path under the directory named in TEMPLATE_DIR.
+//
+//go:embed templates
+var defaultTemplates embed.FS
+
+// readTemplateFile reads a template, preferring the copy in TEMPLATE_DIR over the built-in one.
+// name is a path relative to the templates directory, such as "confirmation/base.txt.tmpl".
+func readTemplateFile(name string) ([]byte, error) {
+	if dir := os.Getenv("TEMPLATE_DIR"); dir != "" {
+		b, err := os.ReadFile(filepath.Join(dir, filepath.FromSlash(name)))
+		if err == nil {
+			return b, nil
+		}
+		if !errors.Is(err, fs.ErrNotExist) {
+			return nil, err
+		}
+	}
+
+	return defaultTemplates.ReadFile("templates/" + name)
+}
+
+// confirmationOwner, confirmationPet and confirmationData are the values available to confirmation templates.
+// Template fields must be exported, so the unexported user, pet and appointment structs are copied into them.
+type confirmationOwner struct {
+	FirstName string `json:"firstName"`
+	LastName  string `json:"lastName"`
+	Phone     string `json:"phone"`
+	Email     string `json:"email"`
+}
+
+type confirmationPet struct {
+	Name       string
+	Species    string
+	Age        int
+	WeightKg   float64
+	Vaccinated bool
+}
+
+type confirmationData struct {
+	AppointmentID   int
+	Owner           confirmationOwner
+	Pet             confirmationPet
+	AppointmentType string
+	Vet             string
+	When            string
+	Time            time.Time
+}
+
+// newConfirmationData copies an owner and their appointment into the values used by the confirmation templates.
+func newConfirmationData(u user, a appointment) confirmationData {
+	t := a.dateTime.In(clinicLocation)
+
+	return confirmationData{
+		AppointmentID: a.id,
+		Owner: confirmationOwner{
+			FirstName: u.firstName,
+			LastName:  u.lastName,
+			Phone:     u.phone,
+			Email:     u.email,
+		},
+		Pet: confirmationPet{
+			Name:       a.pet.name,
+			Species:    a.pet.species,
+			Age:        a.pet.age,
+			WeightKg:   a.pet.weightKg,
+			Vaccinated: a.pet.vaccinated,
+		},
+		AppointmentType: a.appointmentType,
+		Vet:             a.vet,
+		When:            t.Format("Monday, 02 Jan 2006 at 15:04 MST"),
+		Time:            t,
+	}
+}
+
+// readTypeTemplate reads the template for one appointment type, if there is one.
+// An empty string is returned when the type has no template of its own.
+func readTypeTemplate(name string) (string, error) {
+	b, err := readTemplateFile(name)
+	if errors.Is(err, fs.ErrNotExist) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return string(b), nil
+}
+
+// renderConfirmation renders the plain text and HTML confirmations for a booked appointment.
+// Each is made from the base template of its kind, with the "instructions" block replaced by the appointment type's own template when one exists.
+func renderConfirmation(u user, a appointment) (string, string, error) {
+	data := newConfirmationData(u, a)
+
+	textBase, err := readTemplateFile("confirmation/base.txt.tmpl")
+	if err != nil {
+		return "", "", err
+	}
+	textType, err := readTypeTemplate("confirmation/" + a.appointmentType + ".txt.tmpl")
+	if err != nil {
+		return "", "", err
+	}
+
+	textTmpl, err := texttemplate.New("confirmation").Parse(string(textBase))
+	if err != nil {
+		return "", "", fmt.Errorf("confirmation text template: %w", err)
+	}
+	if textType != "" {
+		if _, err := textTmpl.Parse(textType); err != nil {
+			return "", "", fmt.Errorf("%s confirmation text template: %w", a.appointmentType, err)
+		}
+	}
+
+	var text bytes.Buffer
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return "", "", err
+	}
+
+	htmlBase, err := readTemplateFile("confirmation/base.html.tmpl")
+	if err != nil {
+		return "", "", err
+	}
+	htmlType, err := readTypeTemplate("confirmation/" + a.appointmentType + ".html.tmpl")
+	if err != nil {
+		return "", "", err
+	}
+
+	htmlTmpl, err := htmltemplate.New("confirmation").Parse(string(htmlBase))
+	if err != nil {
+		return "", "", fmt.Errorf("confirmation HTML template: %w", err)
+	}
+	if htmlType != "" {
+		if _, err := htmlTmpl.Parse(htmlType); err != nil {
+			return "", "", fmt.Errorf("%s confirmation HTML template: %w", a.appointmentType, err)
+		}
+	}
+
+	var html bytes.Buffer
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return "", "", err
+	}
+
+	return text.String(), html.String(), nil
+}
+
+// confirmationChannel picks the channel booking confirmations are sent on.
+// Confirmations go to the owner's email when the email channel is configured, and to the log otherwise.
+// nil is returned when neither is configured.
+func confirmationChannel(channels []channel) channel {
+	var fallback channel
+	for _, c := range channels {
+		switch c.name() {
+		case "email":
+			return c
+		case "log":
+			fallback = c
+		}
+	}
+	return fallback
+}
+
+// confirmationMessage builds the outbox message that sends a booking confirmation to the owner.
+func confirmationMessage(userID int, u user, a appointment, c channel, text, html string) outboxMessage {
+	return outboxMessage{
+		userID:        userID,
+		appointmentID: sql.NullInt64{Int64: int64(a.id), Valid: true},
+		kind:          "confirmation",
+		channel:       c.name(),
+		recipient:     c.recipient(u),
+		subject:       fmt.Sprintf("Appointment confirmed: %s on %s", a.pet.name, a.dateTime.In(clinicLocation).Format("Mon 02 Jan at 15:04")),
+		body:          text,
+		htmlBody:      html,
+		dedupeKey:     fmt.Sprintf("confirmation:%d:%d", a.id, a.dateTime.Unix()),
+	}
+}
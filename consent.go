@@ -0,0 +1,321 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// consent is a struct that holds an owner's consent to the procedure booked at an appointment.
+// The consent form is kept exactly as the owner saw it, along with the name they typed to acknowledge it.
+type consent struct {
+	id            int
+	appointmentID int
+	formText      string
+	signedName    string
+	capturedBy    string
+	consentedAt   time.Time
+}
+
+// consentTemplate is the template an appointment type's consent form is made from, such as "consent/Surgical.txt.tmpl".
+func consentTemplate(appointmentType string) string {
+	return "consent/" + appointmentType + ".txt.tmpl"
+}
+
+// consentRequired reports whether an appointment type needs the owner's consent before the pet is admitted.
+// A type needs consent when it has a consent form template.
+func consentRequired(appointmentType string) (bool, error) {
+	t, err := readTypeTemplate(consentTemplate(appointmentType))
+	if err != nil {
+		return false, err
+	}
+	return t != "", nil
+}
+
+// consentTypes lists the appointment types that need the owner's consent.
+func consentTypes() ([]string, error) {
+	var types []string
+	for _, t := range allowedAppointmentTypes {
+		required, err := consentRequired(t)
+		if err != nil {
+			return nil, err
+		}
+		if required {
+			types = append(types, t)
+		}
+	}
+	return types, nil
+}
+
+// renderConsentForm renders the consent form for an appointment from its type's template.
+func renderConsentForm(u user, a appointment) (string, error) {
+	b, err := readTemplateFile(consentTemplate(a.appointmentType))
+	if err != nil {
+		return "", err
+	}
+
+	tmpl, err := texttemplate.New("consent").Parse(string(b))
+	if err != nil {
+		return "", fmt.Errorf("%s consent template: %w", a.appointmentType, err)
+	}
+
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, newConfirmationData(u, a)); err != nil {
+		return "", err
+	}
+	return text.String(), nil
+}
+
+// getConsent fetches the consent on record for an appointment.
+// false is returned if the owner has not given consent yet.
+func getConsent(db rowQueryer, appointmentID int) (consent, bool, error) {
+	var c consent
+	err := db.QueryRow(
+		`SELECT id, appointment_id, form_text, signed_name, captured_by, consented_at FROM consents WHERE appointment_id = $1`,
+		appointmentID,
+	).Scan(&c.id, &c.appointmentID, &c.formText, &c.signedName, &c.capturedBy, &c.consentedAt)
+	if err == sql.ErrNoRows {
+		return consent{}, false, nil
+	}
+	if err != nil {
+		return consent{}, false, err
+	}
+	return c, true, nil
+}
+
+// checkConsent returns an error if an appointment's type needs the owner's consent and none is on record.
+// It is checked before a pet is checked in or called through, so nothing is done without consent.
+func checkConsent(db rowQueryer, a appointment) error {
+	required, err := consentRequired(a.appointmentType)
+	if err != nil || !required {
+		return err
+	}
+
+	_, ok, err := getConsent(db, a.id)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s appointments need the owner's consent on record first", a.appointmentType)
+	}
+	return nil
+}
+
+// getSignedName is a helper function that asks the owner to type their full name to acknowledge a consent form.
+// The name must match the one on their account. An empty string is returned if they leave it blank to go back.
+func getSignedName(scanner *bufio.Scanner, u user) (string, error) {
+	fmt.Println("To give your consent, type your full name as it appears on your account, or leave blank to go back:")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.Join(strings.Fields(scanner.Text()), " ")
+
+	if input == "" {
+		return "", nil
+	}
+	if !strings.EqualFold(input, u.firstName+" "+u.lastName) {
+		return "", fmt.Errorf("the name typed does not match the name on the account, %s %s", u.firstName, u.lastName)
+	}
+	return input, nil
+}
+
+// captureConsent shows the owner the consent form for an appointment and records their consent once they type their name.
+// capturedBy is "Owner" when owners give consent themselves, or "Reception" when it is given at the front desk.
+// true is returned if consent is on record afterwards.
+func captureConsent(scanner *bufio.Scanner, db *sql.DB, u user, a appointment, capturedBy string) bool {
+	form, err := renderConsentForm(u, a)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+
+	fmt.Println("-------------------------------------")
+	fmt.Print(form)
+	fmt.Println("-------------------------------------")
+
+	var name string
+	for {
+		n, err := getSignedName(scanner, u)
+		if err == nil {
+			name = n
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if name == "" {
+		fmt.Println("Consent has not been given.")
+		return false
+	}
+
+	var consentedAt time.Time
+	err = db.QueryRow(
+		`INSERT INTO consents (appointment_id, form_text, signed_name, captured_by)
+		 VALUES ($1, $2, $3, $4)
+		 ON CONFLICT (appointment_id) DO NOTHING
+		 RETURNING consented_at`,
+		a.id,
+		form,
+		name,
+		capturedBy,
+	).Scan(&consentedAt)
+	if err == sql.ErrNoRows {
+		fmt.Println("Consent for this appointment was already on record.")
+		return true
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return false
+	}
+
+	fmt.Printf("Thank you. Consent for %s's %s appointment was recorded on %s.\n",
+		a.pet.name, a.appointmentType, consentedAt.In(clinicLocation).Format("02 Jan 2006 at 15:04"))
+	return true
+}
+
+// giveConsent is called when the user selects "Consent forms" in the appointment menu.
+// The user's upcoming appointments that still need consent are listed, and they can read and sign the form for one of them.
+func giveConsent(scanner *bufio.Scanner, db *sql.DB, u user, userID int) {
+	types, err := consentTypes()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	appts, err := queryAppointments(db,
+		`WHERE a.user_id = $1 AND a.status = 'Booked' AND a.appointment_time >= now() AND a.appointment_type = ANY($2)
+		   AND NOT EXISTS (SELECT 1 FROM consents c WHERE c.appointment_id = a.id)
+		 ORDER BY a.appointment_time, a.id`,
+		userID,
+		pq.Array(types),
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(appts) == 0 {
+		fmt.Println("None of your appointments need consent at the moment.")
+		return
+	}
+
+	a, ok := chooseAppointment(scanner, appts)
+	if !ok {
+		return
+	}
+	captureConsent(scanner, db, u, a, "Owner")
+}
+
+// confirmConsentAtDesk is called by reception before admitting a pet whose appointment needs consent that is not yet on record.
+// The owner can read and sign the form at the desk. true is returned if consent is on record afterwards.
+func confirmConsentAtDesk(scanner *bufio.Scanner, db *sql.DB, a appointment) bool {
+	err := checkConsent(db, a)
+	if err == nil {
+		return true
+	}
+	fmt.Println("Error:", err)
+
+	var now bool
+	for {
+		n, err := getYesNo(scanner, "Is the owner here to give consent now?")
+		if err == nil {
+			now = n
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if !now {
+		return false
+	}
+	return captureConsent(scanner, db, a.owner, a, "Reception")
+}
+
+// consentString prints the consent on record for an appointment.
+func consentString(c consent) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += c.formText
+	s += "-------------------------------------\n"
+	s += fmt.Sprintf("Signed: %s\n", c.signedName)
+	s += fmt.Sprintf("Given on %s (%s)\n", c.consentedAt.In(clinicLocation).Format("02 Jan 2006 at 15:04:05 MST"), strings.ToLower(c.capturedBy))
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// getMissingConsents fetches the booked appointments before the given time that need consent and do not have it yet, soonest first.
+func getMissingConsents(db queryer, until time.Time) ([]appointment, error) {
+	types, err := consentTypes()
+	if err != nil {
+		return nil, err
+	}
+
+	return queryAppointments(db,
+		`WHERE a.status = 'Booked' AND a.appointment_time >= now() AND a.appointment_time < $1 AND a.appointment_type = ANY($2)
+		   AND NOT EXISTS (SELECT 1 FROM consents c WHERE c.appointment_id = a.id)
+		 ORDER BY a.appointment_time, a.id`,
+		until,
+		pq.Array(types),
+	)
+}
+
+// runConsentCommand handles the "consent" command.
+//   - consent show <appointment ID>: print the consent form an owner signed and when
+//   - consent missing [days]: list the appointments in the next given days (default 7) that still need the owner's consent
+func runConsentCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: consent show <appointment ID> | consent missing [days]")
+	}
+
+	switch args[0] {
+	case "show":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: consent show <appointment ID>")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id <= 0 {
+			return fmt.Errorf("appointment ID must be a positive number")
+		}
+
+		c, ok, err := getConsent(db, id)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("no consent is on record for appointment %d", id)
+		}
+		fmt.Print(consentString(c))
+		return nil
+
+	case "missing":
+		days := 7
+		if len(args) > 1 {
+			d, err := strconv.Atoi(args[1])
+			if err != nil || d < 0 {
+				return fmt.Errorf("days must be a number that is 0 or more")
+			}
+			days = d
+		}
+
+		appts, err := getMissingConsents(db, clinicToday().AddDate(0, 0, days+1))
+		if err != nil {
+			return err
+		}
+		if len(appts) == 0 {
+			fmt.Println("Every appointment in the next", days, "days that needs consent has it.")
+			return nil
+		}
+		for _, a := range appts {
+			fmt.Printf("%s | %d | %s | %s\n", a.dateTime.In(clinicLocation).Format("Mon 02 Jan 15:04"), a.id, a.scheduleLine(), a.owner.phone)
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown consent command %q, expected show or missing", args[0])
+	}
+}
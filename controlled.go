@@ -0,0 +1,580 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// registerEntry is a struct that holds one line of the controlled drug register. Its kind is one of:
+//   - Received: stock delivered to the clinic
+//   - Administered: stock given to a pet at an appointment
+//   - Destroyed: stock that has expired or been spoiled and was destroyed in front of a witness
+//   - Stock check: a count of the stock actually held, which the running balance is reconciled against
+//
+// The register is append-only: entries are never changed or deleted, so mistakes are corrected by adding further entries.
+// balance is the running balance after the entry, worked out when the register is read.
+type registerEntry struct {
+	id            int
+	drug          string
+	unit          string
+	kind          string
+	quantity      float64
+	appointmentID sql.NullInt64
+	petName       string
+	reference     string
+	recordedBy    string
+	witness       string
+	recordedAt    time.Time
+	balance       float64
+}
+
+// registerIssue is a struct that holds one discrepancy found when reconciling the register.
+type registerIssue struct {
+	at      time.Time
+	drug    string
+	problem string
+}
+
+// controlledDrugs lists the drugs in the formulary that must be logged in the register.
+func controlledDrugs() []drug {
+	var drugs []drug
+	for _, d := range clinicFormulary {
+		if d.Controlled {
+			drugs = append(drugs, d)
+		}
+	}
+	return drugs
+}
+
+// getRegister fetches the register entries for one drug, or for every drug when drugName is empty, oldest first.
+// Each entry's running balance is filled in: received stock adds to it, administered and destroyed stock take from it,
+// and a stock check sets it to the amount counted.
+func getRegister(db queryer, drugName string) ([]registerEntry, error) {
+	rows, err := db.Query(
+		`SELECT r.id, r.drug, r.unit, r.kind, r.quantity, r.appointment_id, COALESCE(a.pet_name, ''), r.reference, r.recorded_by,
+		        r.witness, r.recorded_at
+		 FROM controlled_drug_register r
+		 LEFT JOIN appointments a ON a.id = r.appointment_id
+		 WHERE $1 = '' OR lower(r.drug) = lower($1)
+		 ORDER BY r.drug, r.recorded_at, r.id`,
+		drugName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []registerEntry
+	balances := make(map[string]float64)
+	for rows.Next() {
+		var e registerEntry
+		err := rows.Scan(&e.id, &e.drug, &e.unit, &e.kind, &e.quantity, &e.appointmentID, &e.petName, &e.reference, &e.recordedBy,
+			&e.witness, &e.recordedAt)
+		if err != nil {
+			return nil, err
+		}
+
+		switch e.kind {
+		case "Received":
+			balances[e.drug] += e.quantity
+		case "Administered", "Destroyed":
+			balances[e.drug] -= e.quantity
+		case "Stock check":
+			balances[e.drug] = e.quantity
+		}
+		e.balance = balances[e.drug]
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// sameAmount reports whether two amounts of a drug are equal once rounded to the two decimal places they are recorded to.
+func sameAmount(a, b float64) bool {
+	return math.Abs(a-b) < 0.005
+}
+
+// differenceString prints how far a stock count is from the register, such as "0.5 ml short" or "2 tablets over".
+func differenceString(counted, expected float64, unit string) string {
+	if counted < expected {
+		return unitsString(expected-counted, unit) + " short"
+	}
+	return unitsString(counted-expected, unit) + " over"
+}
+
+// reconcileRegister checks a register, as returned by getRegister, for discrepancies:
+// a stock check that does not match the running balance before it, and a balance that goes below zero.
+func reconcileRegister(entries []registerEntry) []registerIssue {
+	var issues []registerIssue
+	var previous registerEntry
+
+	for i, e := range entries {
+		expected := 0.0
+		if i > 0 && previous.drug == e.drug {
+			expected = previous.balance
+		}
+
+		switch {
+		case e.kind == "Stock check" && !sameAmount(e.quantity, expected):
+			issues = append(issues, registerIssue{
+				at:   e.recordedAt,
+				drug: e.drug,
+				problem: fmt.Sprintf("stock check by %s counted %s but the register showed %s (%s)",
+					e.recordedBy, unitsString(e.quantity, e.unit), unitsString(expected, e.unit), differenceString(e.quantity, expected, e.unit)),
+			})
+
+		case e.balance < 0 && !sameAmount(e.balance, 0):
+			issues = append(issues, registerIssue{
+				at:   e.recordedAt,
+				drug: e.drug,
+				problem: fmt.Sprintf("%s %s left a balance of %s, more than was held",
+					strings.ToLower(e.kind), unitsString(e.quantity, e.unit), formatAmount(e.balance)+" "+e.unit),
+			})
+		}
+		previous = e
+	}
+	return issues
+}
+
+// getUnloggedSurgeries fetches the surgical appointments completed since the given time that have nothing administered in the register.
+func getUnloggedSurgeries(db queryer, since time.Time) ([]appointment, error) {
+	return queryAppointments(db,
+		`WHERE a.appointment_type = 'Surgical' AND a.status = 'Completed' AND a.appointment_time >= $1
+		   AND NOT EXISTS (SELECT 1 FROM controlled_drug_register r WHERE r.appointment_id = a.id AND r.kind = 'Administered')
+		 ORDER BY a.appointment_time`,
+		since,
+	)
+}
+
+// registerString prints the register for one drug, with the running balance after each entry.
+func registerString(drugName string, entries []registerEntry) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += fmt.Sprintf("Controlled drug register: %s\n", drugName)
+	s += "-------------------------------------\n"
+
+	if len(entries) == 0 {
+		s += "Nothing has been recorded yet.\n"
+	}
+	for _, e := range entries {
+		s += fmt.Sprintf("%s  %-12s  %10s  balance %s\n",
+			e.recordedAt.In(clinicLocation).Format("02 Jan 2006 15:04"),
+			e.kind,
+			formatAmount(e.quantity),
+			unitsString(e.balance, e.unit),
+		)
+
+		detail := "by " + e.recordedBy
+		if e.witness != "" {
+			detail += ", witnessed by " + e.witness
+		}
+		if e.appointmentID.Valid {
+			detail += fmt.Sprintf(", appointment %d (%s)", e.appointmentID.Int64, e.petName)
+		}
+		if e.reference != "" {
+			detail += ", " + e.reference
+		}
+		s += "    " + detail + "\n"
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// balancesString prints the current balance of every drug in the register.
+func balancesString(entries []registerEntry) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += "Controlled drug balances\n"
+	s += "-------------------------------------\n"
+
+	if len(entries) == 0 {
+		s += "Nothing has been recorded yet.\n"
+	}
+	for i, e := range entries {
+		if i+1 < len(entries) && entries[i+1].drug == e.drug {
+			continue
+		}
+		s += fmt.Sprintf("%-20s %s\n", e.drug, unitsString(e.balance, e.unit))
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// reconciliationString prints the reconciliation report: each drug's balance, the discrepancies found in the register,
+// and the surgical appointments that have no controlled drugs logged against them.
+func reconciliationString(entries []registerEntry, issues []registerIssue, unlogged []appointment, since time.Time) string {
+	s := balancesString(entries)
+
+	if len(issues) == 0 {
+		s += "No discrepancies found in the register.\n"
+	} else if len(issues) == 1 {
+		s += "1 discrepancy found:\n"
+	} else {
+		s += fmt.Sprintf("%d discrepancies found:\n", len(issues))
+	}
+	for _, i := range issues {
+		s += fmt.Sprintf("%s  %s: %s\n", i.at.In(clinicLocation).Format("02 Jan 2006 15:04"), i.drug, i.problem)
+	}
+	s += "-------------------------------------\n"
+
+	if len(unlogged) == 0 {
+		s += fmt.Sprintf("Every surgical appointment since %s has controlled drugs logged.\n", since.Format("02 Jan 2006"))
+	} else {
+		s += fmt.Sprintf("Surgical appointments since %s with nothing administered in the register:\n", since.Format("02 Jan 2006"))
+		for _, a := range unlogged {
+			s += fmt.Sprintf("%s  appointment %d, %s (%s) with %s\n",
+				a.dateTime.In(clinicLocation).Format("02 Jan 2006 15:04"), a.id, a.pet.name, a.pet.species, a.vet)
+		}
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// insertRegisterEntry adds an entry to the register.
+func insertRegisterEntry(db *sql.DB, e *registerEntry) error {
+	return db.QueryRow(
+		`INSERT INTO controlled_drug_register (drug, unit, kind, quantity, appointment_id, reference, recorded_by, witness)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+		 RETURNING id, recorded_at`,
+		e.drug,
+		e.unit,
+		e.kind,
+		e.quantity,
+		e.appointmentID,
+		e.reference,
+		e.recordedBy,
+		e.witness,
+	).Scan(&e.id, &e.recordedAt)
+}
+
+// getControlledDrug is a helper function that prompts staff to choose one of the controlled drugs in the formulary.
+func getControlledDrug(scanner *bufio.Scanner, drugs []drug) (drug, error) {
+	fmt.Println("Which drug?")
+	for i, d := range drugs {
+		fmt.Printf("%d. %s %s %s\n", i+1, d.Name, strengthString(d.StrengthMg, d.Unit), d.Form)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(drugs) {
+		return drug{}, fmt.Errorf("please select one of the drugs displayed")
+	}
+	return drugs[choice-1], nil
+}
+
+// getRegisterQuantity is a helper function that prompts for an amount of a drug, in its unit, to at most two decimal places.
+// A stock check can count zero; every other entry must be more than zero.
+func getRegisterQuantity(scanner *bufio.Scanner, prompt, unit string, allowZero bool) (float64, error) {
+	fmt.Printf("%s (%ss):\n", prompt, unit)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	q, err := strconv.ParseFloat(strings.TrimSpace(scanner.Text()), 64)
+	if err != nil || math.IsNaN(q) || math.IsInf(q, 0) {
+		return 0, fmt.Errorf("amount must be a number, such as 2.5")
+	}
+	if q < 0 || (q == 0 && !allowZero) {
+		return 0, fmt.Errorf("amount must be more than 0")
+	}
+	if !sameAmount(q, math.Round(q*100)/100) {
+		return 0, fmt.Errorf("amount can have at most two decimal places")
+	}
+	return q, nil
+}
+
+// recordRegisterEntry is called when staff choose to record received, administered or destroyed stock, or a stock check.
+// Administered stock is logged against an appointment that has been seen, and destroyed stock needs a witness.
+// Staff are warned when taking more out than the register shows is held; the entry can still be made, and is then flagged on reconciliation.
+func recordRegisterEntry(scanner *bufio.Scanner, db *sql.DB, kind string) {
+	drugs := controlledDrugs()
+	if len(drugs) == 0 {
+		fmt.Println("There are no controlled drugs in the formulary.")
+		return
+	}
+
+	var d drug
+	for {
+		dr, err := getControlledDrug(scanner, drugs)
+		if err == nil {
+			d = dr
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	e := registerEntry{drug: d.Name, unit: d.Unit, kind: kind}
+
+	if kind == "Administered" {
+		a, ok := getNotesAppointment(scanner, db)
+		if !ok {
+			return
+		}
+		e.appointmentID = sql.NullInt64{Int64: int64(a.id), Valid: true}
+		e.petName = a.pet.name
+
+		if dose, ok := d.doseFor(a.pet.species); ok {
+			fmt.Print(doseRangeString(d, dose, a.pet.weightKg))
+		} else {
+			fmt.Printf("%s has no dose range for %ss in the formulary.\n", d.Name, strings.ToLower(a.pet.species))
+		}
+	}
+
+	entries, err := getRegister(db, d.Name)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	var balance float64
+	if len(entries) > 0 {
+		balance = entries[len(entries)-1].balance
+	}
+	fmt.Printf("The register shows %s held.\n", unitsString(balance, d.Unit))
+
+	prompt := map[string]string{
+		"Received":     "Amount received",
+		"Administered": "Amount administered",
+		"Destroyed":    "Amount destroyed",
+		"Stock check":  "Amount counted",
+	}[kind]
+	for {
+		q, err := getRegisterQuantity(scanner, prompt, d.Unit, kind == "Stock check")
+		if err == nil {
+			e.quantity = q
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	if (kind == "Administered" || kind == "Destroyed") && e.quantity > balance && !sameAmount(e.quantity, balance) {
+		var carryOn bool
+		for {
+			c, err := getYesNo(scanner, "This is more than the register shows is held, and will be flagged as a discrepancy. Record it anyway?")
+			if err == nil {
+				carryOn = c
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		if !carryOn {
+			return
+		}
+	}
+
+	switch kind {
+	case "Received":
+		for {
+			r, err := getRequiredText(scanner, "Supplier and delivery or invoice reference:", 100)
+			if err == nil {
+				e.reference = r
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	case "Destroyed":
+		for {
+			r, err := getRequiredText(scanner, "Reason for destroying it (e.g. expired, contaminated):", 100)
+			if err == nil {
+				e.reference = r
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	for {
+		r, err := getRequiredText(scanner, "Your name:", 50)
+		if err == nil {
+			e.recordedBy = r
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	if kind == "Destroyed" {
+		for {
+			w, err := getRequiredText(scanner, "Name of the witness:", 50)
+			if err == nil && strings.EqualFold(w, e.recordedBy) {
+				err = fmt.Errorf("the witness must be someone else")
+			}
+			if err == nil {
+				e.witness = w
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	if err := insertRegisterEntry(db, &e); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	switch {
+	case kind == "Stock check" && !sameAmount(e.quantity, balance):
+		fmt.Printf("Stock check recorded. The count is %s compared to the register; this will be flagged on reconciliation.\n",
+			differenceString(e.quantity, balance, d.Unit))
+	case kind == "Stock check":
+		fmt.Println("Stock check recorded. The count matches the register.")
+	default:
+		fmt.Println("Entry recorded.")
+	}
+}
+
+// viewRegister is called when staff select "View a drug's register" in the controlled drugs menu.
+func viewRegister(scanner *bufio.Scanner, db *sql.DB) {
+	drugs := controlledDrugs()
+	if len(drugs) == 0 {
+		fmt.Println("There are no controlled drugs in the formulary.")
+		return
+	}
+
+	var d drug
+	for {
+		dr, err := getControlledDrug(scanner, drugs)
+		if err == nil {
+			d = dr
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	entries, err := getRegister(db, d.Name)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Print(registerString(d.Name, entries))
+}
+
+// getReconciliation gathers everything for the reconciliation report.
+// Surgical appointments are checked from days ago until now.
+func getReconciliation(db *sql.DB, days int) (string, error) {
+	entries, err := getRegister(db, "")
+	if err != nil {
+		return "", err
+	}
+
+	since := clinicToday().AddDate(0, 0, -days)
+	unlogged, err := getUnloggedSurgeries(db, since)
+	if err != nil {
+		return "", err
+	}
+
+	return reconciliationString(entries, reconcileRegister(entries), unlogged, since), nil
+}
+
+// controlledDrugsMenu is a function that displays the controlled drugs menu and returns the option selected.
+func controlledDrugsMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Record stock received")
+	fmt.Println("2. Record a drug administered")
+	fmt.Println("3. Record stock destroyed")
+	fmt.Println("4. Record a stock check")
+	fmt.Println("5. View a drug's register")
+	fmt.Println("6. Reconciliation report")
+	fmt.Println("7. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// manageControlledDrugs is called when staff select "Controlled drugs" in the staff menu.
+func manageControlledDrugs(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch controlledDrugsMenu(scanner) {
+		case "1":
+			recordRegisterEntry(scanner, db, "Received")
+
+		case "2":
+			recordRegisterEntry(scanner, db, "Administered")
+
+		case "3":
+			recordRegisterEntry(scanner, db, "Destroyed")
+
+		case "4":
+			recordRegisterEntry(scanner, db, "Stock check")
+
+		case "5":
+			viewRegister(scanner, db)
+
+		case "6":
+			report, err := getReconciliation(db, 30)
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Print(report)
+
+		case "7":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// runRegisterCommand handles the "register" command, for the controlled drug register.
+//   - register balances: print the current balance of every controlled drug
+//   - register show <drug>: print a drug's register with running balances
+//   - register reconcile [days]: print the reconciliation report, checking surgical appointments from the last given days (default 30)
+func runRegisterCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: register balances | register show <drug> | register reconcile [days]")
+	}
+
+	switch args[0] {
+	case "balances":
+		entries, err := getRegister(db, "")
+		if err != nil {
+			return err
+		}
+		fmt.Print(balancesString(entries))
+		return nil
+
+	case "show":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: register show <drug>")
+		}
+		name := args[1]
+		if i := slices.IndexFunc(clinicFormulary, func(d drug) bool { return strings.EqualFold(d.Name, name) }); i >= 0 {
+			name = clinicFormulary[i].Name
+		}
+
+		entries, err := getRegister(db, name)
+		if err != nil {
+			return err
+		}
+		fmt.Print(registerString(name, entries))
+		return nil
+
+	case "reconcile":
+		days := 30
+		if len(args) > 1 {
+			d, err := strconv.Atoi(args[1])
+			if err != nil || d < 0 {
+				return fmt.Errorf("days must be a number that is 0 or more")
+			}
+			days = d
+		}
+
+		report, err := getReconciliation(db, days)
+		if err != nil {
+			return err
+		}
+		fmt.Print(report)
+		return nil
+
+	default:
+		return fmt.Errorf("unknown register command %q, expected balances, show or reconcile", args[0])
+	}
+}
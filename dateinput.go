@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// weekdays maps the names and short names of the days of the week that users may type.
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "sunday": time.Sunday,
+	"mon": time.Monday, "monday": time.Monday,
+	"tue": time.Tuesday, "tues": time.Tuesday, "tuesday": time.Tuesday,
+	"wed": time.Wednesday, "wednesday": time.Wednesday,
+	"thu": time.Thursday, "thur": time.Thursday, "thurs": time.Thursday, "thursday": time.Thursday,
+	"fri": time.Friday, "friday": time.Friday,
+	"sat": time.Saturday, "saturday": time.Saturday,
+}
+
+// parseFlexibleDateTime reads a date and time typed in one of several everyday forms and returns the wall-clock time it refers to.
+// The result is a plain wall-clock reading stored in UTC; resolveClinicTime turns it into an instant in the clinic's timezone.
+// now is used to work out relative dates such as "tomorrow".
+// The accepted forms are a date followed by a time, where the date is one of:
+//   - 2026-03-15
+//   - 15/03 or 15/03/2026 (day first)
+//   - today, tomorrow
+//   - a weekday such as tue or friday (the next one, counting today), or next tue (the next one after today)
+//   - +2d or +1w (days or weeks from today)
+//
+// and the time is 24-hour (14:30) or 12-hour (9am, 2:30pm), or noon.
+func parseFlexibleDateTime(input string, now time.Time) (time.Time, error) {
+	fields := strings.Fields(strings.ToLower(input))
+
+	// "9 am" is treated the same as "9am".
+	if n := len(fields); n >= 2 && (fields[n-1] == "am" || fields[n-1] == "pm") {
+		fields = append(fields[:n-2], fields[n-2]+fields[n-1])
+	}
+
+	if len(fields) < 2 {
+		return time.Time{}, fmt.Errorf("please enter both a date and a time")
+	}
+
+	hour, min, err := parseTimeOfDay(fields[len(fields)-1])
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	date, err := parseDate(fields[:len(fields)-1], now)
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	return time.Date(date.Year(), date.Month(), date.Day(), hour, min, 0, 0, time.UTC), nil
+}
+
+// parseDate reads the date part of a flexible date/time input.
+func parseDate(fields []string, now time.Time) (time.Time, error) {
+	now = now.In(clinicLocation)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	if len(fields) == 2 && fields[0] == "next" {
+		wd, ok := weekdays[fields[1]]
+		if !ok {
+			return time.Time{}, fmt.Errorf("%q is not a day of the week", fields[1])
+		}
+		days := (int(wd) - int(today.Weekday()) + 7) % 7
+		if days == 0 {
+			days = 7
+		}
+		return today.AddDate(0, 0, days), nil
+	}
+
+	if len(fields) != 1 {
+		return time.Time{}, fmt.Errorf("could not understand the date %q", strings.Join(fields, " "))
+	}
+	field := fields[0]
+
+	switch field {
+	case "today":
+		return today, nil
+	case "tomorrow":
+		return today.AddDate(0, 0, 1), nil
+	}
+
+	if wd, ok := weekdays[field]; ok {
+		days := (int(wd) - int(today.Weekday()) + 7) % 7
+		return today.AddDate(0, 0, days), nil
+	}
+
+	if strings.HasPrefix(field, "+") && len(field) > 2 {
+		n, err := strconv.Atoi(field[1 : len(field)-1])
+		if err != nil || n < 0 {
+			return time.Time{}, fmt.Errorf("could not understand %q, try something like +2d or +1w", field)
+		}
+		switch field[len(field)-1] {
+		case 'd':
+			return today.AddDate(0, 0, n), nil
+		case 'w':
+			return today.AddDate(0, 0, 7*n), nil
+		}
+		return time.Time{}, fmt.Errorf("could not understand %q, try something like +2d or +1w", field)
+	}
+
+	if d, err := time.ParseInLocation("2006-01-02", field, time.UTC); err == nil {
+		return d, nil
+	}
+
+	if d, err := time.ParseInLocation("2/1/2006", field, time.UTC); err == nil {
+		return d, nil
+	}
+
+	if d, err := time.ParseInLocation("2/1", field, time.UTC); err == nil {
+		// Without a year, the next time that day comes round is used.
+		// 29/02 waits for the next leap year rather than rolling over to 1 March.
+		for year := today.Year(); ; year++ {
+			next := time.Date(year, d.Month(), d.Day(), 0, 0, 0, 0, time.UTC)
+			if next.Day() == d.Day() && !next.Before(today) {
+				return next, nil
+			}
+		}
+	}
+
+	return time.Time{}, fmt.Errorf("could not understand the date %q", field)
+}
+
+// parseTimeOfDay reads the time part of a flexible date/time input and returns the hour and minute.
+func parseTimeOfDay(field string) (int, int, error) {
+	if field == "noon" {
+		return 12, 0, nil
+	}
+
+	suffix := ""
+	if strings.HasSuffix(field, "am") || strings.HasSuffix(field, "pm") {
+		suffix = field[len(field)-2:]
+		field = field[:len(field)-2]
+	}
+
+	field = strings.ReplaceAll(field, ".", ":")
+
+	hourPart, minPart, hasMin := strings.Cut(field, ":")
+
+	hour, err := strconv.Atoi(hourPart)
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not understand the time %q", field+suffix)
+	}
+
+	min := 0
+	if hasMin {
+		min, err = strconv.Atoi(minPart)
+		if err != nil || len(minPart) != 2 || min > 59 {
+			return 0, 0, fmt.Errorf("could not understand the time %q", field+suffix)
+		}
+	} else if suffix == "" {
+		return 0, 0, fmt.Errorf("please include minutes or am/pm in the time, for example 14:30 or 2pm")
+	}
+
+	switch suffix {
+	case "am", "pm":
+		if hour < 1 || hour > 12 {
+			return 0, 0, fmt.Errorf("hour must be between 1 and 12 when using am/pm")
+		}
+		if hour == 12 {
+			hour = 0
+		}
+		if suffix == "pm" {
+			hour += 12
+		}
+	default:
+		if hour < 0 || hour > 23 {
+			return 0, 0, fmt.Errorf("hour must be between 0 and 23")
+		}
+	}
+
+	return hour, min, nil
+}
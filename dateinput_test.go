@@ -0,0 +1,89 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// testNow is Tuesday 10 March 2026, 09:00 in the clinic.
+var testNow = time.Date(2026, 3, 10, 9, 0, 0, 0, time.UTC)
+
+func TestParseFlexibleDateTime(t *testing.T) {
+	defer func(loc *time.Location) { clinicLocation = loc }(clinicLocation)
+	clinicLocation = time.UTC
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"2026-03-15 14:30", "2026-03-15 14:30", false},
+		{"15/03 9am", "2026-03-15 09:00", false},
+		{"15/03/2027 2:30pm", "2027-03-15 14:30", false},
+		{"today noon", "2026-03-10 12:00", false},
+		{"Tomorrow 9 am", "2026-03-11 09:00", false},
+		{"tue 10:00", "2026-03-10 10:00", false},
+		{"next tue 10:00", "2026-03-17 10:00", false},
+		{"friday 16.45", "2026-03-13 16:45", false},
+		{"+2d 10:00", "2026-03-12 10:00", false},
+		{"+1w 12am", "2026-03-17 00:00", false},
+		{"01/03 10:00", "2027-03-01 10:00", false},
+		{"10/03 10:00", "2026-03-10 10:00", false},
+		{"29/02 10:00", "2028-02-29 10:00", false},
+		{"29/02/2027 10:00", "", true},
+		{"31/04 10:00", "", true},
+		{"tomorrow", "", true},
+		{"next 10:00", "", true},
+		{"next month 10:00", "", true},
+		{"+2x 10:00", "", true},
+		{"+-1d 10:00", "", true},
+		{"someday 10:00", "", true},
+		{"tomorrow 9", "", true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseFlexibleDateTime(tt.input, testNow)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseFlexibleDateTime(%q) error = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got.Format("2006-01-02 15:04") != tt.want {
+			t.Errorf("parseFlexibleDateTime(%q) = %s, want %s", tt.input, got.Format("2006-01-02 15:04"), tt.want)
+		}
+	}
+}
+
+func TestParseTimeOfDay(t *testing.T) {
+	tests := []struct {
+		input   string
+		hour    int
+		min     int
+		wantErr bool
+	}{
+		{"noon", 12, 0, false},
+		{"14:30", 14, 30, false},
+		{"0:05", 0, 5, false},
+		{"9am", 9, 0, false},
+		{"12am", 0, 0, false},
+		{"12pm", 12, 0, false},
+		{"2.30pm", 14, 30, false},
+		{"9", 0, 0, true},
+		{"24:00", 0, 0, true},
+		{"9:60", 0, 0, true},
+		{"9:5", 0, 0, true},
+		{"0am", 0, 0, true},
+		{"13pm", 0, 0, true},
+		{"half past", 0, 0, true},
+	}
+
+	for _, tt := range tests {
+		hour, min, err := parseTimeOfDay(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseTimeOfDay(%q) error = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err == nil && (hour != tt.hour || min != tt.min) {
+			t.Errorf("parseTimeOfDay(%q) = %d:%02d, want %d:%02d", tt.input, hour, min, tt.hour, tt.min)
+		}
+	}
+}
@@ -0,0 +1,588 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	htmltemplate "html/template"
+	"slices"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+// codeAlphabet holds the characters verification codes are made from.
+// Letters and digits that are easily mixed up when read aloud or typed, such as O and 0 or I and 1, are left out.
+const codeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// codeLength is the number of characters in a verification code, not counting the dashes.
+const codeLength = 12
+
+// issuedDocument is a struct that holds a certificate or confirmation card the clinic has issued.
+// The document is kept exactly as it was issued, so staff can compare a printed copy against it.
+// kind is "Vaccination certificate" or "Appointment confirmation"; appointmentID is 0 for certificates.
+type issuedDocument struct {
+	id            int
+	code          string
+	kind          string
+	userID        int
+	petName       string
+	appointmentID int
+	body          string
+	issuedAt      time.Time
+}
+
+// certificateVaccination and certificateData are the values available to the vaccination certificate templates.
+type certificateVaccination struct {
+	Vaccine string
+	GivenOn string
+	Batch   string
+	NextDue string
+	Overdue bool
+}
+
+type certificateData struct {
+	Code         string
+	Issued       string
+	Owner        confirmationOwner
+	Pet          confirmationPet
+	Vaccinations []certificateVaccination
+	CoreStatus   string
+}
+
+// cardData is the values available to the appointment confirmation card templates.
+// It has all of the confirmation template values, along with the card's verification code.
+type cardData struct {
+	confirmationData
+	Code   string
+	Issued string
+}
+
+// newDocumentCode makes a random verification code such as "ABCD-EFGH-JKLM".
+func newDocumentCode() (string, error) {
+	b := make([]byte, codeLength)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	var code strings.Builder
+	for i, c := range b {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(codeAlphabet[int(c)%len(codeAlphabet)])
+	}
+	return code.String(), nil
+}
+
+// normaliseCode tidies a verification code typed by staff so it can be looked up.
+// Case, spaces and dashes do not matter, so "abcd efgh jklm" finds "ABCD-EFGH-JKLM".
+func normaliseCode(input string) string {
+	var chars []byte
+	for _, c := range []byte(strings.ToUpper(input)) {
+		if c != '-' && c != ' ' {
+			chars = append(chars, c)
+		}
+	}
+
+	var code strings.Builder
+	for i, c := range chars {
+		if i > 0 && i%4 == 0 {
+			code.WriteByte('-')
+		}
+		code.WriteByte(c)
+	}
+	return code.String()
+}
+
+// newCertificateData copies an owner, their pet and its vaccination history into the values used by the certificate templates.
+// Vaccinations are listed oldest first, as they would be on a paper record.
+func newCertificateData(u user, p pet, records []vaccination, today time.Time, code string, issued time.Time) certificateData {
+	data := certificateData{
+		Code:   code,
+		Issued: issued.In(clinicLocation).Format("02 Jan 2006 at 15:04 MST"),
+		Owner: confirmationOwner{
+			FirstName: u.firstName,
+			LastName:  u.lastName,
+			Phone:     u.phone,
+			Email:     u.email,
+		},
+		Pet: confirmationPet{
+			Name:    p.name,
+			Species: p.species,
+		},
+	}
+
+	for _, v := range slices.Backward(records) {
+		data.Vaccinations = append(data.Vaccinations, certificateVaccination{
+			Vaccine: v.vaccine,
+			GivenOn: v.givenOn.Format("2006-01-02"),
+			Batch:   v.batch,
+			NextDue: v.nextDue.Format("2006-01-02"),
+			Overdue: v.nextDue.Before(today),
+		})
+	}
+
+	if len(coreVaccines[p.species]) > 0 {
+		if missing := missingVaccines(p.species, records, today); len(missing) > 0 {
+			data.CoreStatus = fmt.Sprintf("Core vaccines due on %s: %s", today.Format("2006-01-02"), strings.Join(missing, ", "))
+		} else {
+			data.CoreStatus = fmt.Sprintf("Core vaccines were up to date on %s.", today.Format("2006-01-02"))
+		}
+	}
+	return data
+}
+
+// renderDocument renders the plain text and HTML versions of a document from its templates, such as "documents/certificate.txt.tmpl".
+func renderDocument(name string, data any) (string, string, error) {
+	textSource, err := readTemplateFile("documents/" + name + ".txt.tmpl")
+	if err != nil {
+		return "", "", err
+	}
+	htmlSource, err := readTemplateFile("documents/" + name + ".html.tmpl")
+	if err != nil {
+		return "", "", err
+	}
+
+	textTmpl, err := texttemplate.New(name).Parse(string(textSource))
+	if err != nil {
+		return "", "", fmt.Errorf("%s text template: %w", name, err)
+	}
+	htmlTmpl, err := htmltemplate.New(name).Parse(string(htmlSource))
+	if err != nil {
+		return "", "", fmt.Errorf("%s HTML template: %w", name, err)
+	}
+
+	var text, html bytes.Buffer
+	if err := textTmpl.Execute(&text, data); err != nil {
+		return "", "", err
+	}
+	if err := htmlTmpl.Execute(&html, data); err != nil {
+		return "", "", err
+	}
+	return text.String(), html.String(), nil
+}
+
+// insertDocument stores a document as it was issued.
+// The ID is filled in once it is saved.
+func insertDocument(db rowQueryer, d *issuedDocument) error {
+	return db.QueryRow(
+		`INSERT INTO documents (code, kind, user_id, pet_name, appointment_id, body, issued_at)
+		 VALUES ($1, $2, $3, $4, NULLIF($5, 0), $6, $7)
+		 RETURNING id`,
+		d.code,
+		d.kind,
+		d.userID,
+		d.petName,
+		d.appointmentID,
+		d.body,
+		d.issuedAt,
+	).Scan(&d.id)
+}
+
+// issueCertificate makes a vaccination certificate for a pet from its stored vaccination records and keeps a copy with a new verification code.
+// The certificate is returned along with its HTML version.
+func issueCertificate(db *sql.DB, u user, userID int, p pet) (issuedDocument, string, error) {
+	records, err := getVaccinations(db, userID, p.name)
+	if err != nil {
+		return issuedDocument{}, "", err
+	}
+	if len(records) == 0 {
+		return issuedDocument{}, "", fmt.Errorf("%s has no vaccinations on record", p.name)
+	}
+
+	code, err := newDocumentCode()
+	if err != nil {
+		return issuedDocument{}, "", err
+	}
+	issued := time.Now()
+
+	text, html, err := renderDocument("certificate", newCertificateData(u, p, records, clinicToday(), code, issued))
+	if err != nil {
+		return issuedDocument{}, "", err
+	}
+
+	d := issuedDocument{
+		code:     code,
+		kind:     "Vaccination certificate",
+		userID:   userID,
+		petName:  p.name,
+		body:     text,
+		issuedAt: issued,
+	}
+	if err := insertDocument(db, &d); err != nil {
+		return issuedDocument{}, "", err
+	}
+	return d, html, nil
+}
+
+// issueCard makes a confirmation card for a booked appointment and keeps a copy with a new verification code.
+// The card is returned along with its HTML version.
+func issueCard(db *sql.DB, a appointment) (issuedDocument, string, error) {
+	if a.status != "Booked" && a.status != "Checked-in" {
+		return issuedDocument{}, "", fmt.Errorf("appointment %d is %s, so there is nothing to confirm", a.id, strings.ToLower(a.status))
+	}
+
+	code, err := newDocumentCode()
+	if err != nil {
+		return issuedDocument{}, "", err
+	}
+	issued := time.Now()
+
+	data := cardData{
+		confirmationData: newConfirmationData(a.owner, a),
+		Code:             code,
+		Issued:           issued.In(clinicLocation).Format("02 Jan 2006 at 15:04 MST"),
+	}
+	text, html, err := renderDocument("card", data)
+	if err != nil {
+		return issuedDocument{}, "", err
+	}
+
+	d := issuedDocument{
+		code:          code,
+		kind:          "Appointment confirmation",
+		userID:        a.userID,
+		petName:       a.pet.name,
+		appointmentID: a.id,
+		body:          text,
+		issuedAt:      issued,
+	}
+	if err := insertDocument(db, &d); err != nil {
+		return issuedDocument{}, "", err
+	}
+	return d, html, nil
+}
+
+// exportDocument renders an issued document as a PDF, or returns its HTML version.
+func exportDocument(d issuedDocument, html string, format string) ([]byte, error) {
+	switch format {
+	case "pdf":
+		return textPDF(d.kind+" "+d.code, strings.Split(strings.Trim(d.body, "\n"), "\n")), nil
+
+	case "html":
+		return []byte(html), nil
+
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected pdf or html", format)
+	}
+}
+
+// documentFileName is the file a document is saved to when no other name is given, such as "certificate-Rex-ABCD-EFGH-JKLM.pdf".
+func documentFileName(d issuedDocument, format string) string {
+	prefix := "certificate-" + strings.ReplaceAll(d.petName, " ", "-")
+	if d.appointmentID != 0 {
+		prefix = fmt.Sprintf("appointment-%d", d.appointmentID)
+	}
+	return exportFileName(prefix+"-"+d.code, format)
+}
+
+// getDocument looks up an issued document by its verification code.
+// false is returned if no document was issued with that code.
+func getDocument(db rowQueryer, code string) (issuedDocument, bool, error) {
+	var d issuedDocument
+	var appointmentID sql.NullInt64
+	err := db.QueryRow(
+		`SELECT id, code, kind, user_id, pet_name, appointment_id, body, issued_at FROM documents WHERE code = $1`,
+		normaliseCode(code),
+	).Scan(&d.id, &d.code, &d.kind, &d.userID, &d.petName, &appointmentID, &d.body, &d.issuedAt)
+	if err == sql.ErrNoRows {
+		return issuedDocument{}, false, nil
+	}
+	if err != nil {
+		return issuedDocument{}, false, err
+	}
+	d.appointmentID = int(appointmentID.Int64)
+	return d, true, nil
+}
+
+// verificationString prints the outcome of looking up a verification code.
+// A document that was issued is shown in full, so staff can check a printed copy has not been changed.
+func verificationString(code string, d issuedDocument, found bool) string {
+	var s string
+	s = "-------------------------------------\n"
+	if !found {
+		s += fmt.Sprintf("No document was issued with the code %s. It may have been mistyped, or the document is not genuine.\n", normaliseCode(code))
+		s += "-------------------------------------\n"
+		return s
+	}
+
+	s += fmt.Sprintf("Genuine: %s issued on %s\n", strings.ToLower(d.kind), d.issuedAt.In(clinicLocation).Format("02 Jan 2006 at 15:04"))
+	s += fmt.Sprintf("Owner login ID: %d, pet: %s\n", d.userID, d.petName)
+	s += "The document as it was issued:\n"
+	s += "-------------------------------------\n"
+	s += d.body
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// saveDocument shows an issued document and saves it to a file in the format staff choose.
+func saveDocument(scanner *bufio.Scanner, d issuedDocument, html string) {
+	fmt.Println("-------------------------------------")
+	fmt.Print(d.body)
+	fmt.Println("-------------------------------------")
+
+	var format string
+	for {
+		f, err := getExportFormat(scanner, "Save the document as:", "", "pdf", "html")
+		if err == nil {
+			format = f
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	b, err := exportDocument(d, html, format)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := writeExport(b, documentFileName(d, format), "Document"); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// printCertificate is called when staff select "Vaccination certificate" in the documents menu.
+func printCertificate(scanner *bufio.Scanner, db *sql.DB) {
+	var owner user
+	var userID int
+	for {
+		u, id, err := getStaffOwner(scanner, db)
+		if err == nil {
+			owner, userID = u, id
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	pets, err := getUserPets(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(pets) == 0 {
+		fmt.Println("There are no pets on record for this owner.")
+		return
+	}
+
+	var p pet
+	for {
+		chosen, ok, err := getPetChoice(scanner, pets)
+		if err == nil {
+			if !ok {
+				return
+			}
+			p = chosen
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	d, html, err := issueCertificate(db, owner, userID, p)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	saveDocument(scanner, d, html)
+}
+
+// printCard is called when staff select "Appointment confirmation card" in the documents menu.
+// Cards can be printed for an owner's upcoming appointments that have not been cancelled.
+func printCard(scanner *bufio.Scanner, db *sql.DB) {
+	var userID int
+	for {
+		_, id, err := getStaffOwner(scanner, db)
+		if err == nil {
+			userID = id
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	appts, err := queryAppointments(db,
+		`WHERE a.user_id = $1 AND a.status IN ('Booked', 'Checked-in') AND a.appointment_time >= $2
+		 ORDER BY a.appointment_time, a.id`,
+		userID,
+		clinicToday(),
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(appts) == 0 {
+		fmt.Println("This owner has no upcoming appointments.")
+		return
+	}
+
+	a, ok := chooseAppointment(scanner, appts)
+	if !ok {
+		return
+	}
+
+	d, html, err := issueCard(db, a)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	saveDocument(scanner, d, html)
+}
+
+// verifyDocument is called when staff select "Verify a document" in the documents menu.
+func verifyDocument(scanner *bufio.Scanner, db *sql.DB) {
+	var code string
+	for {
+		c, err := getRequiredText(scanner, "Please enter the verification code printed on the document:", 20)
+		if err == nil {
+			code = c
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	d, found, err := getDocument(db, code)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Print(verificationString(code, d, found))
+}
+
+// documentsMenu is a function that displays the documents options available to staff.
+func documentsMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Vaccination certificate")
+	fmt.Println("2. Appointment confirmation card")
+	fmt.Println("3. Verify a document")
+	fmt.Println("4. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// manageDocuments is called when staff select "Documents" in the staff menu.
+func manageDocuments(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch documentsMenu(scanner) {
+		case "1":
+			printCertificate(scanner, db)
+
+		case "2":
+			printCard(scanner, db)
+
+		case "3":
+			verifyDocument(scanner, db)
+
+		case "4":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// writeDocument saves an issued document to a file, or prints it when the file is "-", for the documents command.
+func writeDocument(d issuedDocument, html string, format string, file string) error {
+	b, err := exportDocument(d, html, format)
+	if err != nil {
+		return err
+	}
+
+	name := documentFileName(d, format)
+	if file != "" {
+		name = file
+	}
+	return writeExport(b, name, "Document with verification code "+d.code)
+}
+
+// runDocumentsCommand handles the "documents" command.
+//   - documents certificate <login ID> <pet name> pdf|html [file]: issue a vaccination certificate for a pet
+//   - documents card <appointment ID> pdf|html [file]: issue a confirmation card for a booked appointment
+//   - documents verify <code>: check a verification code and show the document it was issued with
+func runDocumentsCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: documents certificate <login ID> <pet name> pdf|html [file] | documents card <appointment ID> pdf|html [file] | documents verify <code>")
+	}
+
+	switch args[0] {
+	case "certificate":
+		if len(args) < 4 {
+			return fmt.Errorf("usage: documents certificate <login ID> <pet name> pdf|html [file]")
+		}
+		userID, err := strconv.Atoi(args[1])
+		if err != nil || userID <= 0 {
+			return fmt.Errorf("login ID must be a positive number")
+		}
+
+		owner, p, err := getOwnerPet(db, userID, args[2])
+		if err != nil {
+			return err
+		}
+
+		if _, err := exportDocument(issuedDocument{}, "", args[3]); err != nil {
+			return err
+		}
+		d, html, err := issueCertificate(db, owner, userID, p)
+		if err != nil {
+			return err
+		}
+
+		var file string
+		if len(args) > 4 {
+			file = args[4]
+		}
+		return writeDocument(d, html, args[3], file)
+
+	case "card":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: documents card <appointment ID> pdf|html [file]")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id <= 0 {
+			return fmt.Errorf("appointment ID must be a positive number")
+		}
+
+		appts, err := queryAppointments(db, `WHERE a.id = $1`, id)
+		if err != nil {
+			return err
+		}
+		if len(appts) == 0 {
+			return fmt.Errorf("no appointment found with that ID")
+		}
+
+		if _, err := exportDocument(issuedDocument{}, "", args[2]); err != nil {
+			return err
+		}
+		d, html, err := issueCard(db, appts[0])
+		if err != nil {
+			return err
+		}
+
+		var file string
+		if len(args) > 3 {
+			file = args[3]
+		}
+		return writeDocument(d, html, args[2], file)
+
+	case "verify":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: documents verify <code>")
+		}
+		code := strings.Join(args[1:], " ")
+
+		d, found, err := getDocument(db, code)
+		if err != nil {
+			return err
+		}
+		fmt.Print(verificationString(code, d, found))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown documents command %q, expected certificate, card or verify", args[0])
+	}
+}
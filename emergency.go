@@ -0,0 +1,445 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emergencyLevels is the list of triage levels that can be booked through the emergency path.
+//   - Emergency: seen today, moving a routine appointment if no vet is free.
+//   - Urgent: the earliest free slot, today or on a later day.
+var emergencyLevels = triageLevels[:2]
+
+// clockTime is a time of day on the clinic's clocks, such as 12:00.
+type clockTime struct {
+	hour, min int
+}
+
+// loadEmergencySlots reads the slots every vet keeps free for emergencies each day from the EMERGENCY_SLOTS environment variable,
+// as a comma-separated list of clinic times such as "12:00,16:00". "none" reserves no slots.
+// The default is 12:00 and 16:00.
+func loadEmergencySlots() ([]clockTime, error) {
+	value := os.Getenv("EMERGENCY_SLOTS")
+	if value == "" {
+		value = "12:00,16:00"
+	}
+	if strings.EqualFold(value, "none") {
+		return nil, nil
+	}
+
+	var slots []clockTime
+	for _, part := range strings.Split(value, ",") {
+		t, err := time.Parse("15:04", strings.TrimSpace(part))
+		if err != nil {
+			return nil, fmt.Errorf("invalid EMERGENCY_SLOTS time %q", part)
+		}
+
+		// Any day will do to check the time is the start of a slot.
+		sample := time.Date(2000, time.January, 3, t.Hour(), t.Minute(), 0, 0, clinicLocation)
+		if err := checkClinicHours(sample); err != nil {
+			return nil, fmt.Errorf("invalid EMERGENCY_SLOTS time %q: %w", part, err)
+		}
+		slots = append(slots, clockTime{hour: t.Hour(), min: t.Minute()})
+	}
+	return slots, nil
+}
+
+// isEmergencySlot reports whether t is one of the slots reserved for emergencies.
+// Reserved slots are not offered for routine bookings or to the waitlist.
+func isEmergencySlot(t time.Time, reserved []clockTime) bool {
+	t = t.In(clinicLocation)
+	for _, c := range reserved {
+		if t.Hour() == c.hour && t.Minute() == c.min {
+			return true
+		}
+	}
+	return false
+}
+
+// earliestFreeSlot finds the first slot, from the one running at from until the end of the given number of days, that any vet is free for.
+// Reserved emergency slots count as free. false is returned if no vet is free in that time.
+func earliestFreeSlot(db queryer, from time.Time, days int) (string, time.Time, bool, error) {
+	from = from.In(clinicLocation)
+	day := time.Date(from.Year(), from.Month(), from.Day(), 0, 0, 0, 0, clinicLocation)
+	end := day.AddDate(0, 0, days)
+
+	busy := make(map[string][]time.Time)
+	for _, vet := range allowedVets {
+		times, err := busyTimes(db, vet, from.Add(-slotLength), end, 0)
+		if err != nil {
+			return "", time.Time{}, false, err
+		}
+		busy[vet] = times
+	}
+
+	for ; day.Before(end); day = day.AddDate(0, 0, 1) {
+		open := time.Date(day.Year(), day.Month(), day.Day(), openingHour, 0, 0, 0, clinicLocation)
+		closing := time.Date(day.Year(), day.Month(), day.Day(), closingHour, 0, 0, 0, clinicLocation)
+
+		for slot := open; slot.Before(closing); slot = slot.Add(slotLength) {
+			// The slot that is running now can still be used if its vet is free.
+			if !slot.Add(slotLength).After(from) {
+				continue
+			}
+
+			for _, vet := range allowedVets {
+				taken := false
+				for _, b := range busy[vet] {
+					if overlaps(b, slot) {
+						taken = true
+						break
+					}
+				}
+				if !taken {
+					return vet, slot, true, nil
+				}
+			}
+		}
+	}
+
+	return "", time.Time{}, false, nil
+}
+
+// bumpProposal is a struct that holds a routine appointment that could be moved to make room for an emergency, and where it would move to.
+type bumpProposal struct {
+	appt  appointment
+	moved time.Time
+}
+
+// getBumpProposals lists the routine appointments left today that could be moved to make room for an emergency, soonest first.
+// Each is paired with its vet's next free routine slot. Appointments already checked in and other emergency bookings are never moved.
+func getBumpProposals(db *sql.DB, now time.Time) ([]bumpProposal, error) {
+	appts, err := queryAppointments(db,
+		`WHERE a.status = 'Booked' AND a.triage_level IS NULL
+		   AND a.appointment_time > $1 AND a.appointment_time < $2
+		 ORDER BY a.appointment_time, a.id
+		 LIMIT 5`,
+		now.Add(-slotLength),
+		clinicToday().AddDate(0, 0, 1),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var proposals []bumpProposal
+	for _, a := range appts {
+		free, err := nextFreeSlots(db, a.vet, a.dateTime.Add(slotLength), 1, nil)
+		if err != nil {
+			return nil, err
+		}
+		if len(free) == 0 {
+			continue
+		}
+		proposals = append(proposals, bumpProposal{appt: a, moved: free[0]})
+	}
+	return proposals, nil
+}
+
+// getBumpChoice is a helper function that shows the routine appointments that could be moved and asks staff to pick one.
+// false is returned if they choose to go back.
+func getBumpChoice(scanner *bufio.Scanner, proposals []bumpProposal) (bumpProposal, bool, error) {
+	fmt.Println("No vet is free today. These routine appointments could be moved to make room:")
+	for i, p := range proposals {
+		fmt.Printf("%d. %s with %s: %s (%s), owner %s %s -> moves to %s\n",
+			i+1,
+			p.appt.dateTime.In(clinicLocation).Format("15:04"),
+			p.appt.vet,
+			p.appt.pet.name,
+			p.appt.appointmentType,
+			p.appt.owner.firstName,
+			p.appt.owner.lastName,
+			p.moved.In(clinicLocation).Format("Mon 02 Jan at 15:04"),
+		)
+	}
+	fmt.Printf("%d. Back\n", len(proposals)+1)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(proposals)+1 {
+		return bumpProposal{}, false, fmt.Errorf("please select one of the options displayed")
+	}
+	if choice == len(proposals)+1 {
+		return bumpProposal{}, false, nil
+	}
+	return proposals[choice-1], true, nil
+}
+
+// bumpedMessage builds the message telling an owner that their appointment has been moved to make room for an emergency.
+func bumpedMessage(a appointment, moved time.Time, c channel) outboxMessage {
+	body := fmt.Sprintf("Hi %s,\n\n", a.owner.firstName)
+	body += fmt.Sprintf("We are sorry, but we have had to move %s's %s appointment with %s on %s to make room for an emergency.\n\n",
+		a.pet.name,
+		a.appointmentType,
+		a.vet,
+		a.dateTime.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04 MST"),
+	)
+	body += fmt.Sprintf("The new time is %s.\n\n", moved.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04 MST"))
+	body += "If this time does not suit you, please reschedule or contact the clinic.\n"
+
+	return outboxMessage{
+		userID:        a.userID,
+		appointmentID: sql.NullInt64{Int64: int64(a.id), Valid: true},
+		kind:          "moved",
+		channel:       c.name(),
+		recipient:     c.recipient(a.owner),
+		subject:       fmt.Sprintf("Appointment moved: %s on %s", a.pet.name, moved.In(clinicLocation).Format("Mon 02 Jan at 15:04")),
+		body:          body,
+		dedupeKey:     fmt.Sprintf("moved:%d:%d:%s", a.id, moved.Unix(), c.name()),
+	}
+}
+
+// bumpAppointment moves a routine appointment to its proposed new time inside the caller's transaction, and queues a message to its owner on every channel.
+func bumpAppointment(tx *sql.Tx, channels []channel, p bumpProposal) error {
+	taken, err := slotTaken(tx, p.appt.vet, p.moved, p.appt.id, nil)
+	if err != nil {
+		return err
+	}
+	if taken {
+		return fmt.Errorf("%s is no longer free at %s", p.appt.vet, p.moved.In(clinicLocation).Format("15:04 on Mon 02 Jan"))
+	}
+
+	res, err := tx.Exec(
+		`UPDATE appointments SET appointment_time = $1 WHERE id = $2 AND status = 'Booked' AND appointment_time = $3`,
+		p.moved,
+		p.appt.id,
+		p.appt.dateTime,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		return fmt.Errorf("%s's appointment can no longer be moved", p.appt.pet.name)
+	}
+
+	for _, c := range channels {
+		if _, err := enqueueMessage(tx, bumpedMessage(p.appt, p.moved, c)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getEmergencyPet is a helper function that picks the pet for an emergency booking from the owner's pets on record.
+// If the owner has no pets on record yet, the pet's name and species are entered instead.
+// The pet's age and weight are always asked for, as they are needed for the booking and may have changed since the last visit.
+// false is returned if staff choose to go back.
+func getEmergencyPet(scanner *bufio.Scanner, db *sql.DB, userID int) (pet, bool) {
+	pets, err := getUserPets(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return pet{}, false
+	}
+
+	var p pet
+	if len(pets) > 0 {
+		for {
+			chosen, ok, err := getPetChoice(scanner, pets)
+			if err == nil {
+				if !ok {
+					return pet{}, false
+				}
+				p = chosen
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	} else {
+		for {
+			n, err := getName(scanner, 0)
+			if err == nil {
+				p.name = n
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		for {
+			s, err := getSpecies(scanner, 0)
+			if err == nil {
+				p.species = s
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	for {
+		a, err := getAge(scanner, 0)
+		if err == nil {
+			p.age = a
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	for {
+		w, err := getWeightKg(scanner, 0)
+		if err == nil {
+			p.weightKg = w
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	p.vaccinated = getPetVaccinationStatus(scanner, db, userID, p, 0)
+
+	return p, true
+}
+
+// bookEmergency is called when staff select "Emergency booking" in the staff menu.
+// The pet is booked into the earliest slot any vet is free for, including the slots reserved for emergencies.
+// When an emergency cannot be seen today, staff are shown which routine appointments could be moved and the owner of the one they pick is notified.
+// Emergency bookings do not take a deposit, and skip the booking questionnaire on purpose: the pet is seen straight away and the vet asks in person.
+func bookEmergency(scanner *bufio.Scanner, db *sql.DB, channels []channel) {
+	var u user
+	var userID int
+	for {
+		o, id, err := getStaffOwner(scanner, db)
+		if err == nil {
+			u, userID = o, id
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	p, ok := getEmergencyPet(scanner, db, userID)
+	if !ok {
+		return
+	}
+
+	a := appointment{pet: p, status: "Booked"}
+	for {
+		l, err := getTriageLevel(scanner, emergencyLevels)
+		if err == nil {
+			a.triageLevel = l
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	for {
+		t, err := getAppointmentType(scanner, 0)
+		if err == nil {
+			a.appointmentType = t
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	now := time.Now()
+	days := 1
+	if a.triageLevel != "Emergency" {
+		days = slotSearchDays
+	}
+
+	vet, t, found, err := earliestFreeSlot(db, now, days)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	var bump *bumpProposal
+	if found {
+		a.vet, a.dateTime = vet, t
+	} else {
+		if a.triageLevel != "Emergency" {
+			fmt.Println("No vet is free in the next", slotSearchDays, "days.")
+			return
+		}
+
+		proposals, err := getBumpProposals(db, now)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if len(proposals) == 0 {
+			fmt.Println("No vet is free today and there are no routine appointments left today that can be moved.")
+			return
+		}
+
+		var chosen bumpProposal
+		for {
+			c, ok, err := getBumpChoice(scanner, proposals)
+			if err == nil {
+				if !ok {
+					return
+				}
+				chosen = c
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		bump = &chosen
+		a.vet, a.dateTime = chosen.appt.vet, chosen.appt.dateTime
+	}
+
+	fmt.Print(a.summaryString(1))
+
+	// The booking rules are for routine work, so they never stop an emergency; anything they would block is shown as a warning for the vet.
+	for _, r := range evaluateRules(bookingRules, a) {
+		switch r.outcome {
+		case "block", "warn":
+			fmt.Println("Warning:", r.message)
+		case "info":
+			fmt.Println("Note:", r.message)
+		}
+	}
+
+	var confirmed bool
+	for {
+		c, err := getYesNo(scanner, "Book this emergency appointment?")
+		if err == nil {
+			confirmed = c
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if !confirmed {
+		fmt.Println("Emergency appointment not booked.")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if bump != nil {
+		if err := bumpAppointment(tx, channels, *bump); err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	} else if taken, err := slotTaken(tx, a.vet, a.dateTime, 0, nil); err != nil || taken {
+		if err == nil {
+			err = errSlotTaken
+		}
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := insertAppointment(tx, userID, u, &a); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	text, err := queueConfirmation(tx, channels, u, a)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if bump != nil {
+		fmt.Printf("%s's appointment has been moved to %s and their owner has been notified.\n",
+			bump.appt.pet.name, bump.moved.In(clinicLocation).Format("15:04 on Mon 02 Jan"))
+	}
+	showConfirmations(db, channels, []string{text})
+}
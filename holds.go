@@ -0,0 +1,111 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// errSlotTaken is returned when a slot cannot be held because it is no longer free.
+var errSlotTaken = errors.New("slot is no longer available")
+
+// loadHoldDuration reads how long a chosen slot is held while the owner finishes booking from the HOLD_DURATION environment variable.
+// The default is 10 minutes.
+func loadHoldDuration() (time.Duration, error) {
+	value := os.Getenv("HOLD_DURATION")
+	if value == "" {
+		return 10 * time.Minute, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid HOLD_DURATION %q", value)
+	}
+	return d, nil
+}
+
+// placeHold tentatively reserves a slot for a user while they finish booking, so nobody else can take it in the meantime.
+// excludeID and pending are passed on to slotTaken.
+// errSlotTaken is returned if the slot is already booked or held, or is reserved for emergencies.
+// The hold's ID and expiry time are returned.
+func placeHold(db *sql.DB, userID int, vet string, t time.Time, excludeID int, pending []appointment) (int, time.Time, error) {
+	hold, err := loadHoldDuration()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	reserved, err := loadEmergencySlots()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if isEmergencySlot(t, reserved) {
+		return 0, time.Time{}, errSlotTaken
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	defer tx.Rollback()
+
+	// Holds that have run out are cleared first so they do not block the unique slot constraint.
+	_, err = tx.Exec(`DELETE FROM slot_holds WHERE expires_at <= now()`)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	taken, err := slotTaken(tx, vet, t, excludeID, pending)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	if taken {
+		return 0, time.Time{}, errSlotTaken
+	}
+
+	var id int
+	var expires time.Time
+	err = tx.QueryRow(
+		`INSERT INTO slot_holds (user_id, vet_name, appointment_time, expires_at)
+		 VALUES ($1, $2, $3, now() + $4 * interval '1 second')
+		 ON CONFLICT (vet_name, appointment_time) DO NOTHING
+		 RETURNING id, expires_at`,
+		userID,
+		vet,
+		t,
+		int(hold.Seconds()),
+	).Scan(&id, &expires)
+	if err == sql.ErrNoRows {
+		return 0, time.Time{}, errSlotTaken
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	return id, expires, tx.Commit()
+}
+
+// heldIDs collects the hold IDs of appointments that are still being booked.
+func heldIDs(appts []appointment) []int64 {
+	var ids []int64
+	for _, a := range appts {
+		if a.holdID != 0 {
+			ids = append(ids, int64(a.holdID))
+		}
+	}
+	return ids
+}
+
+// releaseHolds gives up the holds on appointments that were not booked after all, so their slots are free straight away.
+func releaseHolds(db execer, appts []appointment) error {
+	ids := heldIDs(appts)
+	if len(ids) == 0 {
+		return nil
+	}
+
+	_, err := db.Exec(`DELETE FROM slot_holds WHERE id = ANY($1)`, pq.Array(ids))
+	return err
+}
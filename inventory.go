@@ -0,0 +1,892 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// inventoryItem is a struct that holds one thing the clinic keeps in stock.
+// A vaccine item supplies doses of one vaccine, such as "DHP", and one dose is used for each vaccine given at a Vaccination appointment.
+// A consumable is used perUse at a time at every appointment of the type it is used for, such as syringes at vaccinations.
+// Stock is held in batches, each with its own expiry date.
+type inventoryItem struct {
+	id           int
+	name         string
+	kind         string
+	vaccine      string
+	usedFor      string
+	perUse       int
+	reorderLevel int
+}
+
+// stockLevel is a struct that holds an item and how much of it is in stock and in date.
+type stockLevel struct {
+	item      inventoryItem
+	available int
+}
+
+// low reports whether an item has fallen to its reorder level.
+func (l *stockLevel) low() bool {
+	return l.available <= l.item.reorderLevel
+}
+
+// inventoryBatch is a struct that holds one delivery of an item.
+type inventoryBatch struct {
+	id               int
+	itemName         string
+	batchNumber      string
+	expiresOn        time.Time
+	quantityReceived int
+	quantityLeft     int
+}
+
+// stockUsage is a struct that holds stock taken from one batch for an appointment.
+type stockUsage struct {
+	itemName    string
+	batchNumber string
+	quantity    int
+}
+
+// loadExpiryNotice reads how many days ahead the expiring soon report looks, from the INVENTORY_EXPIRY_DAYS environment variable.
+// It defaults to 60 days.
+func loadExpiryNotice() (int, error) {
+	value := os.Getenv("INVENTORY_EXPIRY_DAYS")
+	if value == "" {
+		return 60, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid INVENTORY_EXPIRY_DAYS %q", value)
+	}
+	return days, nil
+}
+
+// getStockLevels fetches every inventory item with the amount in stock that is still in date on the given day, vaccines first.
+func getStockLevels(db queryer, day time.Time) ([]stockLevel, error) {
+	rows, err := db.Query(
+		`SELECT i.id, i.name, i.kind, COALESCE(i.vaccine, ''), COALESCE(i.used_for, ''), i.per_use, i.reorder_level,
+		        COALESCE(SUM(b.quantity_left) FILTER (WHERE b.expires_on >= $1), 0)
+		 FROM inventory_items i
+		 LEFT JOIN inventory_batches b ON b.item_id = i.id
+		 GROUP BY i.id
+		 ORDER BY i.kind DESC, i.name`,
+		day,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var levels []stockLevel
+	for rows.Next() {
+		var l stockLevel
+		err := rows.Scan(&l.item.id, &l.item.name, &l.item.kind, &l.item.vaccine, &l.item.usedFor, &l.item.perUse, &l.item.reorderLevel,
+			&l.available)
+		if err != nil {
+			return nil, err
+		}
+		levels = append(levels, l)
+	}
+	return levels, rows.Err()
+}
+
+// getExpiringBatches fetches the batches with stock left that expire on or before the given day, including any already expired, soonest first.
+func getExpiringBatches(db queryer, until time.Time) ([]inventoryBatch, error) {
+	rows, err := db.Query(
+		`SELECT b.id, i.name, b.batch_number, b.expires_on, b.quantity_received, b.quantity_left
+		 FROM inventory_batches b
+		 JOIN inventory_items i ON i.id = b.item_id
+		 WHERE b.quantity_left > 0 AND b.expires_on <= $1
+		 ORDER BY b.expires_on, i.name, b.id`,
+		until,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []inventoryBatch
+	for rows.Next() {
+		var b inventoryBatch
+		if err := rows.Scan(&b.id, &b.itemName, &b.batchNumber, &b.expiresOn, &b.quantityReceived, &b.quantityLeft); err != nil {
+			return nil, err
+		}
+		b.expiresOn = clinicDate(b.expiresOn)
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// vaccineStock works out how many doses of a vaccine are in stock and will still be in date on the given day.
+// false is returned if the clinic does not keep any item for the vaccine in its inventory, so its stock is not tracked.
+func vaccineStock(db rowQueryer, vaccine string, day time.Time) (int, bool, error) {
+	var items, available int
+	err := db.QueryRow(
+		`SELECT COUNT(DISTINCT i.id), COALESCE(SUM(b.quantity_left) FILTER (WHERE b.expires_on >= $2), 0)
+		 FROM inventory_items i
+		 LEFT JOIN inventory_batches b ON b.item_id = i.id
+		 WHERE i.kind = 'Vaccine' AND lower(i.vaccine) = lower($1)`,
+		vaccine,
+		day,
+	).Scan(&items, &available)
+	if err != nil {
+		return 0, false, err
+	}
+	return available, items > 0, nil
+}
+
+// vaccineBatches fetches the batches of a vaccine with stock left that are still in date on the given day, expiring first.
+func vaccineBatches(db queryer, vaccine string, day time.Time) ([]inventoryBatch, error) {
+	rows, err := db.Query(
+		`SELECT b.id, i.name, b.batch_number, b.expires_on, b.quantity_received, b.quantity_left
+		 FROM inventory_batches b
+		 JOIN inventory_items i ON i.id = b.item_id
+		 WHERE i.kind = 'Vaccine' AND lower(i.vaccine) = lower($1) AND b.quantity_left > 0 AND b.expires_on >= $2
+		 ORDER BY b.expires_on, b.id`,
+		vaccine,
+		day,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var batches []inventoryBatch
+	for rows.Next() {
+		var b inventoryBatch
+		if err := rows.Scan(&b.id, &b.itemName, &b.batchNumber, &b.expiresOn, &b.quantityReceived, &b.quantityLeft); err != nil {
+			return nil, err
+		}
+		b.expiresOn = clinicDate(b.expiresOn)
+		batches = append(batches, b)
+	}
+	return batches, rows.Err()
+}
+
+// vaccinesNeeded lists the core vaccines a pet needs at a Vaccination appointment: the ones that are not current on the appointment date.
+// Doses given on or after that date are left out, so the answer stays the same once staff record the vaccines given at the appointment.
+// A pet with no vaccination history needs all of them.
+func vaccinesNeeded(db queryer, userID int, a appointment) ([]string, error) {
+	records, err := getVaccinations(db, userID, a.pet.name)
+	if err != nil {
+		return nil, err
+	}
+
+	day := clinicDate(a.dateTime)
+	records = slices.DeleteFunc(records, func(v vaccination) bool { return !v.givenOn.Before(day) })
+	return missingVaccines(a.pet.species, records, day), nil
+}
+
+// vaccineShortages lists the vaccines a Vaccination appointment needs that will be out of stock on its date.
+// Vaccines the clinic does not track in its inventory are not listed.
+func vaccineShortages(db *sql.DB, userID int, a appointment) ([]string, error) {
+	if a.appointmentType != "Vaccination" {
+		return nil, nil
+	}
+
+	needed, err := vaccinesNeeded(db, userID, a)
+	if err != nil {
+		return nil, err
+	}
+
+	var short []string
+	for _, v := range needed {
+		available, tracked, err := vaccineStock(db, v, clinicDate(a.dateTime))
+		if err != nil {
+			return nil, err
+		}
+		if tracked && available == 0 {
+			short = append(short, v)
+		}
+	}
+	return short, nil
+}
+
+// confirmVaccineStock warns the user when a vaccine a Vaccination appointment needs will be out of stock on the chosen date,
+// and asks whether to keep the date anyway. true is returned if the booking can go ahead on this date.
+func confirmVaccineStock(scanner *bufio.Scanner, db *sql.DB, userID int, a appointment) bool {
+	short, err := vaccineShortages(db, userID, a)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return true
+	}
+	if len(short) == 0 {
+		return true
+	}
+
+	fmt.Printf("Warning: the clinic is out of stock of %s for %s. The appointment may need to be moved if no more arrives in time.\n",
+		strings.Join(short, ", "), a.dateTime.In(clinicLocation).Format("Monday 02 Jan 2006"))
+	for {
+		keep, err := getYesNo(scanner, "Do you still want to book this date?")
+		if err == nil {
+			return keep
+		}
+		fmt.Println("Error:", err)
+	}
+}
+
+// takeStock takes quantity units of stock from the given items for an appointment inside the caller's transaction.
+// Only the batch with the given batch number is used when one is given; otherwise the batch expiring first is used first.
+// Batches that have expired by the given day are skipped.
+// The amount that could not be taken, because too little is in stock, is returned with what was taken.
+func takeStock(tx *sql.Tx, appointmentID int, itemIDs []int, batchNumber string, quantity int, day time.Time) ([]stockUsage, int, error) {
+	rows, err := tx.Query(
+		`SELECT b.id, i.name, b.batch_number, b.quantity_left
+		 FROM inventory_batches b
+		 JOIN inventory_items i ON i.id = b.item_id
+		 WHERE b.item_id = ANY($1) AND b.quantity_left > 0 AND b.expires_on >= $2
+		   AND ($3 = '' OR lower(b.batch_number) = lower($3))
+		 ORDER BY b.expires_on, b.id
+		 FOR UPDATE OF b`,
+		pq.Array(itemIDs),
+		day,
+		batchNumber,
+	)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var batches []inventoryBatch
+	for rows.Next() {
+		var b inventoryBatch
+		if err := rows.Scan(&b.id, &b.itemName, &b.batchNumber, &b.quantityLeft); err != nil {
+			rows.Close()
+			return nil, 0, err
+		}
+		batches = append(batches, b)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, 0, err
+	}
+
+	var used []stockUsage
+	for _, b := range batches {
+		if quantity == 0 {
+			break
+		}
+		n := min(quantity, b.quantityLeft)
+
+		if _, err := tx.Exec(`UPDATE inventory_batches SET quantity_left = quantity_left - $2 WHERE id = $1`, b.id, n); err != nil {
+			return nil, 0, err
+		}
+		_, err := tx.Exec(
+			`INSERT INTO inventory_usage (batch_id, appointment_id, quantity, reason) VALUES ($1, $2, $3, 'Used')`,
+			b.id,
+			appointmentID,
+			n,
+		)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		used = append(used, stockUsage{itemName: b.itemName, batchNumber: b.batchNumber, quantity: n})
+		quantity -= n
+	}
+	return used, quantity, nil
+}
+
+// useAppointmentStock takes the stock a completed appointment used inside the caller's transaction:
+// a dose of each vaccine recorded as given to the pet on the day of a Vaccination appointment, and the consumables used for the appointment's type.
+// Each dose is taken from the batch number recorded with the vaccination, so the inventory agrees with the pet's records and certificates;
+// the batch expiring first is used when no batch number was recorded.
+// Stock that could not be taken, because too little was recorded or the batch is not in the inventory, is returned as shortfall messages;
+// the appointment is still completed.
+func useAppointmentStock(tx *sql.Tx, a appointment) ([]stockUsage, []string, error) {
+	levels, err := getStockLevels(tx, clinicToday())
+	if err != nil {
+		return nil, nil, err
+	}
+	day := clinicDate(a.dateTime)
+
+	var used []stockUsage
+	var shortfalls []string
+
+	if a.appointmentType == "Vaccination" {
+		records, err := getVaccinations(tx, a.userID, a.pet.name)
+		if err != nil {
+			return nil, nil, err
+		}
+		records = slices.DeleteFunc(records, func(v vaccination) bool { return !v.givenOn.Equal(day) })
+		if len(records) == 0 {
+			shortfalls = append(shortfalls, fmt.Sprintf("no vaccinations are recorded for %s on %s, so no vaccine stock was taken",
+				a.pet.name, day.Format("02 Jan 2006")))
+		}
+
+		for _, v := range records {
+			var ids []int
+			for _, l := range levels {
+				if l.item.kind == "Vaccine" && strings.EqualFold(l.item.vaccine, v.vaccine) {
+					ids = append(ids, l.item.id)
+				}
+			}
+			if len(ids) == 0 {
+				continue
+			}
+
+			u, short, err := takeStock(tx, a.id, ids, v.batch, 1, day)
+			if err != nil {
+				return nil, nil, err
+			}
+			used = append(used, u...)
+			switch {
+			case short > 0 && v.batch != "":
+				shortfalls = append(shortfalls, fmt.Sprintf("%s batch %s is not in stock and in date", v.vaccine, v.batch))
+			case short > 0:
+				shortfalls = append(shortfalls, fmt.Sprintf("no in-date %s vaccine was in stock", v.vaccine))
+			}
+		}
+	}
+
+	for _, l := range levels {
+		if l.item.kind != "Consumable" || l.item.usedFor != a.appointmentType {
+			continue
+		}
+
+		u, short, err := takeStock(tx, a.id, []int{l.item.id}, "", l.item.perUse, day)
+		if err != nil {
+			return nil, nil, err
+		}
+		used = append(used, u...)
+		if short > 0 {
+			shortfalls = append(shortfalls, fmt.Sprintf("%d short of %s", short, l.item.name))
+		}
+	}
+
+	return used, shortfalls, nil
+}
+
+// stockUsageString prints the stock an appointment used, with any shortfalls that staff should check.
+func stockUsageString(used []stockUsage, shortfalls []string) string {
+	var s string
+	if len(used) > 0 {
+		s += "Stock used:\n"
+		for _, u := range used {
+			s += fmt.Sprintf("  %d x %s (batch %s)\n", u.quantity, u.itemName, u.batchNumber)
+		}
+	}
+	if len(shortfalls) > 0 {
+		s += "Not enough stock was recorded, please check the inventory:\n"
+		for _, sh := range shortfalls {
+			s += "  " + sh + "\n"
+		}
+	}
+	return s
+}
+
+// stockLevelsString prints every item's stock, marking the ones at or below their reorder level.
+// When lowOnly is true only those items are listed.
+func stockLevelsString(levels []stockLevel, lowOnly bool) string {
+	var s string
+	s = "-------------------------------------\n"
+	if lowOnly {
+		s += "Low stock\n"
+	} else {
+		s += "Stock levels\n"
+	}
+	s += "-------------------------------------\n"
+
+	var listed int
+	for _, l := range levels {
+		if lowOnly && !l.low() {
+			continue
+		}
+		listed++
+
+		use := l.item.vaccine + " vaccine"
+		if l.item.kind == "Consumable" {
+			use = fmt.Sprintf("%d per %s appointment", l.item.perUse, l.item.usedFor)
+		}
+		s += fmt.Sprintf("%-25s %5d in stock (reorder at %d) - %s", l.item.name, l.available, l.item.reorderLevel, use)
+		if l.low() {
+			s += " - LOW"
+		}
+		s += "\n"
+	}
+
+	if listed == 0 && lowOnly {
+		s += "Nothing is low on stock.\n"
+	} else if listed == 0 {
+		s += "There are no items in the inventory yet.\n"
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// expiringString prints the batches expiring by the given day, marking the ones that have already expired.
+func expiringString(batches []inventoryBatch, until, today time.Time) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += fmt.Sprintf("Stock expiring by %s\n", until.Format("02 Jan 2006"))
+	s += "-------------------------------------\n"
+
+	if len(batches) == 0 {
+		s += "Nothing is expiring.\n"
+	}
+	for _, b := range batches {
+		s += fmt.Sprintf("%s  %-25s batch %-12s %d left", b.expiresOn.Format("02 Jan 2006"), b.itemName, b.batchNumber, b.quantityLeft)
+		if b.expiresOn.Before(today) {
+			s += " - EXPIRED"
+		}
+		s += "\n"
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// getItemKind is a helper function that asks whether a new inventory item is a vaccine or a consumable.
+func getItemKind(scanner *bufio.Scanner) (string, error) {
+	fmt.Println("What kind of item is it?")
+	fmt.Println("1. Vaccine")
+	fmt.Println("2. Consumable")
+	fmt.Print("> ")
+
+	scanner.Scan()
+
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		return "Vaccine", nil
+	case "2":
+		return "Consumable", nil
+	default:
+		return "", fmt.Errorf("please select 1 or 2")
+	}
+}
+
+// getItemVaccine is a helper function that asks which vaccine an item supplies, listing every core vaccine.
+// Any other vaccine can be typed in by name.
+func getItemVaccine(scanner *bufio.Scanner) (string, error) {
+	var vaccines []string
+	for _, species := range allowedSpecies {
+		for _, v := range coreVaccines[species] {
+			if !slices.Contains(vaccines, v) {
+				vaccines = append(vaccines, v)
+			}
+		}
+	}
+
+	fmt.Println("Which vaccine does it supply? Choose one or type its name:")
+	for i, v := range vaccines {
+		fmt.Printf("%d. %s\n", i+1, v)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if choice, err := strconv.Atoi(input); err == nil {
+		if choice < 1 || choice > len(vaccines) {
+			return "", fmt.Errorf("please select one of the vaccines displayed")
+		}
+		return vaccines[choice-1], nil
+	}
+
+	if input == "" {
+		return "", fmt.Errorf("vaccine name cannot be empty")
+	}
+	if len(input) > 50 {
+		return "", fmt.Errorf("character limit is 50 characters")
+	}
+	return input, nil
+}
+
+// getItemUse is a helper function that asks which appointment type a consumable is used for.
+func getItemUse(scanner *bufio.Scanner) (string, error) {
+	fmt.Println("Which appointment type is it used for?")
+	for i, t := range allowedAppointmentTypes {
+		fmt.Printf("%d. %s\n", i+1, t)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(allowedAppointmentTypes) {
+		return "", fmt.Errorf("please select one of the appointment types displayed")
+	}
+	return allowedAppointmentTypes[choice-1], nil
+}
+
+// getStockNumber is a helper function that prompts for a whole number of items, which must be at least minimum.
+func getStockNumber(scanner *bufio.Scanner, prompt string, minimum int) (int, error) {
+	fmt.Println(prompt)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	n, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || n < minimum || n > 100000 {
+		return 0, fmt.Errorf("please enter a whole number between %d and 100000", minimum)
+	}
+	return n, nil
+}
+
+// getExpiryDate is a helper function that prompts for a batch's expiry date, which cannot be in the past.
+func getExpiryDate(scanner *bufio.Scanner) (time.Time, error) {
+	fmt.Println("Expiry date (YYYY-MM-DD):")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	d, err := time.ParseInLocation("2006-01-02", strings.TrimSpace(scanner.Text()), clinicLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date format")
+	}
+	if d.Before(clinicToday()) {
+		return time.Time{}, fmt.Errorf("this batch has already expired")
+	}
+	return d, nil
+}
+
+// addInventoryItem is called when staff select "Add an item" in the inventory menu.
+func addInventoryItem(scanner *bufio.Scanner, db *sql.DB) {
+	item := inventoryItem{perUse: 1}
+
+	for {
+		n, err := getRequiredText(scanner, "Item name (e.g. Nobivac DHP, 1 ml syringe):", 50)
+		if err == nil {
+			item.name = n
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	for {
+		k, err := getItemKind(scanner)
+		if err == nil {
+			item.kind = k
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	if item.kind == "Vaccine" {
+		for {
+			v, err := getItemVaccine(scanner)
+			if err == nil {
+				item.vaccine = v
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	} else {
+		for {
+			t, err := getItemUse(scanner)
+			if err == nil {
+				item.usedFor = t
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		for {
+			n, err := getStockNumber(scanner, "How many are used at each appointment?", 1)
+			if err == nil {
+				item.perUse = n
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	for {
+		n, err := getStockNumber(scanner, "Reorder when stock falls to:", 0)
+		if err == nil {
+			item.reorderLevel = n
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	err := db.QueryRow(
+		`INSERT INTO inventory_items (name, kind, vaccine, used_for, per_use, reorder_level)
+		 VALUES ($1, $2, NULLIF($3, ''), NULLIF($4, ''), $5, $6)
+		 RETURNING id`,
+		item.name,
+		item.kind,
+		item.vaccine,
+		item.usedFor,
+		item.perUse,
+		item.reorderLevel,
+	).Scan(&item.id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println(item.name, "added to the inventory.")
+}
+
+// chooseInventoryItem is a helper function that lists the inventory and asks staff to pick an item.
+// false is returned if there are no items or staff choose to go back.
+func chooseInventoryItem(scanner *bufio.Scanner, db *sql.DB) (inventoryItem, bool) {
+	levels, err := getStockLevels(db, clinicToday())
+	if err != nil {
+		fmt.Println("Error:", err)
+		return inventoryItem{}, false
+	}
+	if len(levels) == 0 {
+		fmt.Println("There are no items in the inventory yet.")
+		return inventoryItem{}, false
+	}
+
+	for {
+		fmt.Println("Please choose an item:")
+		for i, l := range levels {
+			fmt.Printf("%d. %s (%d in stock)\n", i+1, l.item.name, l.available)
+		}
+		fmt.Printf("%d. Back\n", len(levels)+1)
+		fmt.Print("> ")
+
+		scanner.Scan()
+		choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil || choice < 1 || choice > len(levels)+1 {
+			fmt.Println("Error: please select one of the items displayed")
+			continue
+		}
+		if choice == len(levels)+1 {
+			return inventoryItem{}, false
+		}
+		return levels[choice-1].item, true
+	}
+}
+
+// receiveBatch is called when staff select "Receive a batch" in the inventory menu.
+func receiveBatch(scanner *bufio.Scanner, db *sql.DB) {
+	item, ok := chooseInventoryItem(scanner, db)
+	if !ok {
+		return
+	}
+
+	var b inventoryBatch
+	for {
+		n, err := getRequiredText(scanner, "Batch number:", 50)
+		if err == nil {
+			b.batchNumber = n
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	for {
+		d, err := getExpiryDate(scanner)
+		if err == nil {
+			b.expiresOn = d
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	for {
+		n, err := getStockNumber(scanner, "Quantity received:", 1)
+		if err == nil {
+			b.quantityReceived = n
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO inventory_batches (item_id, batch_number, expires_on, quantity_received, quantity_left)
+		 VALUES ($1, $2, $3, $4, $4)`,
+		item.id,
+		b.batchNumber,
+		b.expiresOn,
+		b.quantityReceived,
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("%d x %s received (batch %s, expires %s).\n", b.quantityReceived, item.name, b.batchNumber, b.expiresOn.Format("02 Jan 2006"))
+}
+
+// writeOffBatch is called when staff select "Write off a batch" in the inventory menu.
+// What is left of the batch is removed from stock, for example when it has expired or been damaged.
+func writeOffBatch(scanner *bufio.Scanner, db *sql.DB) {
+	days, err := loadExpiryNotice()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	batches, err := getExpiringBatches(db, clinicToday().AddDate(0, 0, days))
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(batches) == 0 {
+		fmt.Println("There are no batches expiring in the next", days, "days.")
+		return
+	}
+
+	var b inventoryBatch
+	for {
+		fmt.Println("Which batch should be written off?")
+		for i, b := range batches {
+			fmt.Printf("%d. %s batch %s, expires %s, %d left\n", i+1, b.itemName, b.batchNumber, b.expiresOn.Format("02 Jan 2006"), b.quantityLeft)
+		}
+		fmt.Printf("%d. Back\n", len(batches)+1)
+		fmt.Print("> ")
+
+		scanner.Scan()
+		choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil || choice < 1 || choice > len(batches)+1 {
+			fmt.Println("Error: please select one of the batches displayed")
+			continue
+		}
+		if choice == len(batches)+1 {
+			return
+		}
+		b = batches[choice-1]
+		break
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	var left int
+	err = tx.QueryRow(`SELECT quantity_left FROM inventory_batches WHERE id = $1 FOR UPDATE`, b.id).Scan(&left)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if left == 0 {
+		fmt.Println("This batch has already been used up.")
+		return
+	}
+
+	if _, err := tx.Exec(`UPDATE inventory_batches SET quantity_left = 0 WHERE id = $1`, b.id); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if _, err := tx.Exec(`INSERT INTO inventory_usage (batch_id, quantity, reason) VALUES ($1, $2, 'Written off')`, b.id, left); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("%d x %s written off.\n", left, b.itemName)
+}
+
+// showExpiring prints the expiring soon report, looking the given number of days ahead.
+func showExpiring(db *sql.DB, days int) error {
+	until := clinicToday().AddDate(0, 0, days)
+	batches, err := getExpiringBatches(db, until)
+	if err != nil {
+		return err
+	}
+	fmt.Print(expiringString(batches, until, clinicToday()))
+	return nil
+}
+
+// inventoryMenu is a function that displays the inventory menu and returns the option selected.
+func inventoryMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Stock levels")
+	fmt.Println("2. Low stock")
+	fmt.Println("3. Expiring soon")
+	fmt.Println("4. Add an item")
+	fmt.Println("5. Receive a batch")
+	fmt.Println("6. Write off a batch")
+	fmt.Println("7. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// manageInventory is called when staff select "Inventory" in the staff menu.
+func manageInventory(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch inventoryMenu(scanner) {
+		case "1":
+			levels, err := getStockLevels(db, clinicToday())
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Print(stockLevelsString(levels, false))
+
+		case "2":
+			levels, err := getStockLevels(db, clinicToday())
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Print(stockLevelsString(levels, true))
+
+		case "3":
+			days, err := loadExpiryNotice()
+			if err == nil {
+				err = showExpiring(db, days)
+			}
+			if err != nil {
+				fmt.Println("Error:", err)
+			}
+
+		case "4":
+			addInventoryItem(scanner, db)
+
+		case "5":
+			receiveBatch(scanner, db)
+
+		case "6":
+			writeOffBatch(scanner, db)
+
+		case "7":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// runInventoryCommand handles the "inventory" command.
+//   - inventory stock: print every item's stock level
+//   - inventory low: print the items at or below their reorder level
+//   - inventory expiring [days]: print the batches expiring within the given number of days (default INVENTORY_EXPIRY_DAYS)
+func runInventoryCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: inventory stock | inventory low | inventory expiring [days]")
+	}
+
+	switch args[0] {
+	case "stock", "low":
+		levels, err := getStockLevels(db, clinicToday())
+		if err != nil {
+			return err
+		}
+		fmt.Print(stockLevelsString(levels, args[0] == "low"))
+		return nil
+
+	case "expiring":
+		days, err := loadExpiryNotice()
+		if err != nil {
+			return err
+		}
+		if len(args) > 1 {
+			days, err = strconv.Atoi(args[1])
+			if err != nil || days < 0 {
+				return fmt.Errorf("days must be a number that is 0 or more")
+			}
+		}
+		return showExpiring(db, days)
+
+	default:
+		return fmt.Errorf("unknown inventory command %q, expected stock, low or expiring", args[0])
+	}
+}
@@ -0,0 +1,624 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// invoiceLine is a struct that holds one line item on an invoice.
+// Amounts are in minor units.
+type invoiceLine struct {
+	description string
+	quantity    int
+	unitPrice   int64
+	amount      int64
+}
+
+// invoice is a struct that holds an invoice issued when an appointment is completed.
+// A deposit invoice is issued when an appointment that needs a deposit is booked, and is paid in the same way as any other invoice.
+// Discounts are taken off before tax is added, and all amounts are in minor units.
+// This information is stored in the invoices and invoice_lines tables in the database.
+type invoice struct {
+	id             int
+	number         string
+	appointmentID  int
+	userID         int
+	currency       string
+	lines          []invoiceLine
+	subtotal       int64
+	discount       int64
+	discountReason string
+	taxPercent     float64
+	tax            int64
+	total          int64
+	issuedAt       time.Time
+	owner          user
+	petName        string
+	deposit        bool
+
+	// paid is what has been paid towards the appointment so far, including its deposit, less refunds.
+	// For a deposit invoice it is only what was paid against that invoice.
+	// It is worked out from the payments ledger rather than stored.
+	paid int64
+}
+
+// calculate works out the invoice's subtotal, tax and total from its line items and discount.
+func (inv *invoice) calculate() {
+	inv.subtotal = 0
+	for i := range inv.lines {
+		inv.lines[i].amount = int64(inv.lines[i].quantity) * inv.lines[i].unitPrice
+		inv.subtotal += inv.lines[i].amount
+	}
+
+	if inv.discount > inv.subtotal {
+		inv.discount = inv.subtotal
+	}
+	inv.tax = taxOn(inv.subtotal-inv.discount, inv.taxPercent)
+	inv.total = inv.subtotal - inv.discount + inv.tax
+}
+
+// invoiceString prints an invoice with its line items and totals.
+func (inv *invoice) invoiceString() string {
+	var s string
+	s = "-------------------------------------\n"
+	if inv.number != "" && inv.deposit {
+		s += fmt.Sprintf("Deposit invoice %s\n", inv.number)
+		s += fmt.Sprintf("Issued: %s\n", inv.issuedAt.In(clinicLocation).Format("02 Jan 2006"))
+	} else if inv.number != "" {
+		s += fmt.Sprintf("Invoice %s\n", inv.number)
+		s += fmt.Sprintf("Issued: %s\n", inv.issuedAt.In(clinicLocation).Format("02 Jan 2006"))
+	} else {
+		s += "Invoice (draft)\n"
+	}
+	s += fmt.Sprintf("Bill to: %s %s, %s\n", inv.owner.firstName, inv.owner.lastName, inv.owner.email)
+	s += fmt.Sprintf("Pet: %s\n", inv.petName)
+	s += "\n"
+
+	for _, l := range inv.lines {
+		s += fmt.Sprintf("%-36s %3d x %14s = %14s\n", l.description, l.quantity, formatMoney(l.unitPrice, inv.currency), formatMoney(l.amount, inv.currency))
+	}
+	s += "\n"
+
+	s += fmt.Sprintf("%-55s %14s\n", "Subtotal", formatMoney(inv.subtotal, inv.currency))
+	if inv.discount != 0 {
+		label := "Discount"
+		if inv.discountReason != "" {
+			label += " (" + inv.discountReason + ")"
+		}
+		s += fmt.Sprintf("%-55s %14s\n", label, formatMoney(-inv.discount, inv.currency))
+	}
+	s += fmt.Sprintf("%-55s %14s\n", fmt.Sprintf("Tax (%g%%)", inv.taxPercent), formatMoney(inv.tax, inv.currency))
+	s += fmt.Sprintf("%-55s %14s\n", "Total", formatMoney(inv.total, inv.currency))
+	if inv.paid != 0 {
+		s += fmt.Sprintf("%-55s %14s\n", "Paid", formatMoney(-inv.paid, inv.currency))
+		s += fmt.Sprintf("%-55s %14s\n", "Amount due", formatMoney(inv.total-inv.paid, inv.currency))
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// invoiceJSON and invoiceLineJSON are the shape of an invoice exported as JSON.
+// Amounts are given in minor units so they can be added up without rounding errors.
+type invoiceLineJSON struct {
+	Description string `json:"description"`
+	Quantity    int    `json:"quantity"`
+	UnitPrice   int64  `json:"unitPrice"`
+	Amount      int64  `json:"amount"`
+}
+
+type invoiceJSON struct {
+	Number         string            `json:"number"`
+	Deposit        bool              `json:"deposit,omitempty"`
+	IssuedAt       time.Time         `json:"issuedAt"`
+	AppointmentID  int               `json:"appointmentId"`
+	Owner          confirmationOwner `json:"owner"`
+	Pet            string            `json:"pet"`
+	Currency       string            `json:"currency"`
+	Lines          []invoiceLineJSON `json:"lines"`
+	Subtotal       int64             `json:"subtotal"`
+	Discount       int64             `json:"discount"`
+	DiscountReason string            `json:"discountReason,omitempty"`
+	TaxPercent     float64           `json:"taxPercent"`
+	Tax            int64             `json:"tax"`
+	Total          int64             `json:"total"`
+	Paid           int64             `json:"paid"`
+	AmountDue      int64             `json:"amountDue"`
+}
+
+// exportInvoice renders an invoice as "text", "pdf" or "json".
+func exportInvoice(inv invoice, format string) ([]byte, error) {
+	switch format {
+	case "text":
+		return []byte(inv.invoiceString()), nil
+
+	case "pdf":
+		text := strings.Trim(inv.invoiceString(), "\n")
+		return textPDF("Invoice "+inv.number, strings.Split(text, "\n")), nil
+
+	case "json":
+		out := invoiceJSON{
+			Number:        inv.number,
+			Deposit:       inv.deposit,
+			IssuedAt:      inv.issuedAt,
+			AppointmentID: inv.appointmentID,
+			Owner: confirmationOwner{
+				FirstName: inv.owner.firstName,
+				LastName:  inv.owner.lastName,
+				Phone:     inv.owner.phone,
+				Email:     inv.owner.email,
+			},
+			Pet:            inv.petName,
+			Currency:       inv.currency,
+			Lines:          []invoiceLineJSON{},
+			Subtotal:       inv.subtotal,
+			Discount:       inv.discount,
+			DiscountReason: inv.discountReason,
+			TaxPercent:     inv.taxPercent,
+			Tax:            inv.tax,
+			Total:          inv.total,
+			Paid:           inv.paid,
+			AmountDue:      inv.total - inv.paid,
+		}
+		for _, l := range inv.lines {
+			out.Lines = append(out.Lines, invoiceLineJSON{Description: l.description, Quantity: l.quantity, UnitPrice: l.unitPrice, Amount: l.amount})
+		}
+
+		b, err := json.MarshalIndent(out, "", "  ")
+		if err != nil {
+			return nil, err
+		}
+		return append(b, '\n'), nil
+
+	default:
+		return nil, fmt.Errorf("unknown invoice format %q, expected text, pdf or json", format)
+	}
+}
+
+// getInvoice fetches an invoice and its line items by invoice number, along with what has been paid towards it.
+func getInvoice(db lookupQueryer, number string) (invoice, error) {
+	rows, err := db.Query(
+		`SELECT i.id, i.invoice_number, i.appointment_id, i.user_id, i.currency, i.subtotal, i.discount, i.discount_reason,
+		        i.tax_percent, i.tax, i.total, i.issued_at, i.deposit,
+		        u.first_name, u.last_name, u.phone, u.email, a.pet_name
+		 FROM invoices i
+		 JOIN users u ON u.id = i.user_id
+		 JOIN appointments a ON a.id = i.appointment_id
+		 WHERE i.invoice_number = $1`,
+		strings.ToUpper(strings.TrimSpace(number)),
+	)
+	if err != nil {
+		return invoice{}, err
+	}
+
+	var inv invoice
+	found := false
+	for rows.Next() {
+		found = true
+		err := rows.Scan(
+			&inv.id, &inv.number, &inv.appointmentID, &inv.userID, &inv.currency, &inv.subtotal, &inv.discount, &inv.discountReason,
+			&inv.taxPercent, &inv.tax, &inv.total, &inv.issuedAt, &inv.deposit,
+			&inv.owner.firstName, &inv.owner.lastName, &inv.owner.phone, &inv.owner.email, &inv.petName,
+		)
+		if err != nil {
+			rows.Close()
+			return invoice{}, err
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return invoice{}, err
+	}
+	if !found {
+		return invoice{}, fmt.Errorf("no invoice found with number %s", number)
+	}
+
+	rows, err = db.Query(
+		`SELECT description, quantity, unit_price, amount
+		 FROM invoice_lines
+		 WHERE invoice_id = $1
+		 ORDER BY position`,
+		inv.id,
+	)
+	if err != nil {
+		return invoice{}, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var l invoiceLine
+		if err := rows.Scan(&l.description, &l.quantity, &l.unitPrice, &l.amount); err != nil {
+			return invoice{}, err
+		}
+		inv.lines = append(inv.lines, l)
+	}
+	if err := rows.Err(); err != nil {
+		return invoice{}, err
+	}
+
+	if inv.deposit {
+		err = db.QueryRow(`SELECT COALESCE(SUM(amount), 0) FROM payments WHERE invoice_id = $1`, inv.id).Scan(&inv.paid)
+		return inv, err
+	}
+
+	inv.paid, err = paidForAppointment(db, inv.appointmentID)
+	return inv, err
+}
+
+// insertInvoice gives an invoice the next invoice number and stores it with its line items inside the caller's transaction.
+func insertInvoice(tx *sql.Tx, inv *invoice) error {
+	var seq int64
+	if err := tx.QueryRow(`SELECT nextval('invoice_number_seq')`).Scan(&seq); err != nil {
+		return err
+	}
+	inv.number = fmt.Sprintf("INV-%06d", seq)
+
+	err := tx.QueryRow(
+		`INSERT INTO invoices (
+			invoice_number, appointment_id, user_id, currency, subtotal, discount, discount_reason, tax_percent, tax, total, deposit
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11)
+		RETURNING id, issued_at`,
+		inv.number,
+		inv.appointmentID,
+		inv.userID,
+		inv.currency,
+		inv.subtotal,
+		inv.discount,
+		inv.discountReason,
+		inv.taxPercent,
+		inv.tax,
+		inv.total,
+		inv.deposit,
+	).Scan(&inv.id, &inv.issuedAt)
+	if err != nil {
+		return err
+	}
+
+	for i, l := range inv.lines {
+		_, err := tx.Exec(
+			`INSERT INTO invoice_lines (invoice_id, position, description, quantity, unit_price, amount)
+			 VALUES ($1, $2, $3, $4, $5, $6)`,
+			inv.id,
+			i+1,
+			l.description,
+			l.quantity,
+			l.unitPrice,
+			l.amount,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// chooseAppointment is a helper function that lists appointments and asks the user to pick one.
+// false is returned if the user chooses to go back.
+func chooseAppointment(scanner *bufio.Scanner, appts []appointment) (appointment, bool) {
+	for {
+		fmt.Println("Please choose an appointment:")
+		printAppointments(appts, 0, compactFormat)
+		fmt.Printf("%d. Back\n", len(appts)+1)
+		fmt.Print("> ")
+
+		scanner.Scan()
+		choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err != nil || choice < 1 || choice > len(appts)+1 {
+			fmt.Println("Error: please select one of the appointments displayed")
+			continue
+		}
+
+		if choice == len(appts)+1 {
+			return appointment{}, false
+		}
+		return appts[choice-1], true
+	}
+}
+
+// getInvoiceLine is a helper function that prompts for an extra item to add to an invoice, such as medication.
+func getInvoiceLine(scanner *bufio.Scanner) (invoiceLine, error) {
+	fmt.Println("Item description:")
+	fmt.Print("> ")
+	scanner.Scan()
+	description := strings.TrimSpace(scanner.Text())
+	if description == "" {
+		return invoiceLine{}, fmt.Errorf("description cannot be empty")
+	}
+	if len(description) > 36 {
+		return invoiceLine{}, fmt.Errorf("character limit is 36 characters")
+	}
+
+	fmt.Println("Quantity:")
+	fmt.Print("> ")
+	scanner.Scan()
+	quantity, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || quantity < 1 || quantity > 1000 {
+		return invoiceLine{}, fmt.Errorf("quantity must be between 1 and 1000")
+	}
+
+	fmt.Printf("Unit price in %s (e.g. 12.50):\n", clinicPrices.Currency)
+	fmt.Print("> ")
+	scanner.Scan()
+	price, err := parseMoney(scanner.Text())
+	if err != nil {
+		return invoiceLine{}, err
+	}
+	if price < 0 {
+		return invoiceLine{}, fmt.Errorf("unit price cannot be negative, use a discount instead")
+	}
+
+	return invoiceLine{description: description, quantity: quantity, unitPrice: price}, nil
+}
+
+// getDiscount is a helper function that prompts for a discount, either a percentage ("10%") or an amount ("15.00").
+// A percentage is worked out from subtotal. An empty input means no discount.
+func getDiscount(scanner *bufio.Scanner, subtotal int64) (int64, string, error) {
+	fmt.Println("Discount (e.g. 10% or 15.00), or leave blank for none:")
+	fmt.Print("> ")
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if input == "" {
+		return 0, "", nil
+	}
+
+	var discount int64
+	if p, ok := strings.CutSuffix(input, "%"); ok {
+		percent, err := strconv.ParseFloat(strings.TrimSpace(p), 64)
+		if err != nil || percent <= 0 || percent > 100 {
+			return 0, "", fmt.Errorf("percentage must be between 0 and 100")
+		}
+		discount = taxOn(subtotal, percent)
+	} else {
+		d, err := parseMoney(input)
+		if err != nil {
+			return 0, "", err
+		}
+		if d <= 0 || d > subtotal {
+			return 0, "", fmt.Errorf("discount must be more than 0 and no more than the subtotal")
+		}
+		discount = d
+	}
+
+	fmt.Println("Reason for the discount:")
+	fmt.Print("> ")
+	scanner.Scan()
+	reason := strings.TrimSpace(scanner.Text())
+	if reason == "" {
+		return 0, "", fmt.Errorf("please give a reason for the discount")
+	}
+
+	return discount, reason, nil
+}
+
+// completeAppointment is called when staff select "Complete an appointment" in the staff menu.
+// Staff pick a checked-in or in-progress appointment from today or earlier, add any extra items and a discount, and the appointment is marked as completed
+// with a numbered invoice issued for it in the same transaction. The vaccines and consumables it used are taken out of stock at the same time.
+// Appointments that are still booked were never checked in, so they cannot be completed and become no-shows instead.
+// Appointments whose type needs the owner's consent cannot be completed without it.
+func completeAppointment(scanner *bufio.Scanner, db *sql.DB) {
+	appts, err := queryAppointments(db,
+		`WHERE a.status IN ('Checked-in', 'In progress') AND a.appointment_time < $1
+		 ORDER BY a.appointment_time, a.id`,
+		clinicToday().AddDate(0, 0, 1),
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(appts) == 0 {
+		fmt.Println("There are no checked-in appointments waiting to be completed.")
+		return
+	}
+
+	a, ok := chooseAppointment(scanner, appts)
+	if !ok {
+		return
+	}
+	if err := checkConsent(db, a); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	price := a.price
+	if price == 0 {
+		price, err = clinicPrices.priceFor(a)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+	}
+
+	inv := invoice{
+		appointmentID: a.id,
+		userID:        a.userID,
+		currency:      clinicPrices.Currency,
+		taxPercent:    clinicPrices.TaxPercent,
+		owner:         a.owner,
+		petName:       a.pet.name,
+		lines: []invoiceLine{
+			{description: a.appointmentType + " appointment", quantity: 1, unitPrice: price},
+		},
+	}
+
+	for {
+		var more bool
+		for {
+			m, err := getYesNo(scanner, "Add another item to the invoice?")
+			if err == nil {
+				more = m
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		if !more {
+			break
+		}
+
+		for {
+			l, err := getInvoiceLine(scanner)
+			if err == nil {
+				inv.lines = append(inv.lines, l)
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	inv.calculate()
+
+	for {
+		d, reason, err := getDiscount(scanner, inv.subtotal)
+		if err == nil {
+			inv.discount, inv.discountReason = d, reason
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	inv.calculate()
+
+	inv.paid, err = paidForAppointment(db, a.id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Print(inv.invoiceString())
+
+	var confirmed bool
+	for {
+		c, err := getYesNo(scanner, "Complete the appointment and issue this invoice?")
+		if err == nil {
+			confirmed = c
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if !confirmed {
+		fmt.Println("Appointment not completed.")
+		return
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(`UPDATE appointments SET status = 'Completed' WHERE id = $1 AND status IN ('Checked-in', 'In progress')`, a.id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		fmt.Println("Error: this appointment can no longer be completed")
+		return
+	}
+
+	if err := insertInvoice(tx, &inv); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	used, shortfalls, err := useAppointmentStock(tx, a)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Appointment completed.")
+	fmt.Print(inv.invoiceString())
+	fmt.Print(stockUsageString(used, shortfalls))
+}
+
+// invoiceFileName is the file an invoice is exported to when no other name is given, such as "INV-000012.pdf".
+func invoiceFileName(inv invoice, format string) string {
+	return exportFileName(inv.number, format)
+}
+
+// viewInvoice is called when staff select "Invoices" in the staff menu.
+// Staff look an invoice up by its number, and can export it to a file.
+func viewInvoice(scanner *bufio.Scanner, db *sql.DB) {
+	fmt.Println("Please enter the invoice number (e.g. INV-000012):")
+	fmt.Print("> ")
+	scanner.Scan()
+
+	inv, err := getInvoice(db, scanner.Text())
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Print(inv.invoiceString())
+
+	var format string
+	for {
+		f, err := getExportFormat(scanner, "Export as:", "Back", "text", "pdf", "json")
+		if err == nil {
+			format = f
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if format == "" {
+		return
+	}
+
+	b, err := exportInvoice(inv, format)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := writeExport(b, invoiceFileName(inv, format), "Invoice"); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// runInvoicesCommand handles the "invoices" command.
+//   - invoices show <number>: print an invoice
+//   - invoices export <number> text|pdf|json [file]: save an invoice to a file, or print it when the file is "-"
+func runInvoicesCommand(db *sql.DB, args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf("usage: invoices show <number> | invoices export <number> text|pdf|json [file]")
+	}
+
+	inv, err := getInvoice(db, args[1])
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		fmt.Print(inv.invoiceString())
+		return nil
+
+	case "export":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: invoices export <number> text|pdf|json [file]")
+		}
+		b, err := exportInvoice(inv, args[2])
+		if err != nil {
+			return err
+		}
+
+		name := invoiceFileName(inv, args[2])
+		if len(args) > 3 {
+			name = args[3]
+		}
+		return writeExport(b, name, "Invoice")
+
+	default:
+		return fmt.Errorf("unknown invoices command %q, expected show or export", args[0])
+	}
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// upcomingWhere selects a user's appointments that have not happened yet and have not been cancelled.
+// historyWhere selects everything else: appointments in the past and cancelled appointments.
+const (
+	upcomingWhere = "WHERE a.user_id = $1 AND a.appointment_time >= now() AND a.status <> 'Cancelled'\n"
+	historyWhere  = "WHERE a.user_id = $1 AND (a.appointment_time < now() OR a.status = 'Cancelled')\n"
+)
+
+// getUpcomingAppointments fetches one page of a user's upcoming appointments, soonest first.
+func getUpcomingAppointments(db *sql.DB, userID int, offset int) ([]appointment, error) {
+	return queryAppointments(db,
+		upcomingWhere+"ORDER BY a.appointment_time, a.id LIMIT $2 OFFSET $3",
+		userID, pageSize, offset,
+	)
+}
+
+// getPastAppointments fetches one page of a user's appointment history, most recent first.
+func getPastAppointments(db *sql.DB, userID int, offset int) ([]appointment, error) {
+	return queryAppointments(db,
+		historyWhere+"ORDER BY a.appointment_time DESC, a.id DESC LIMIT $2 OFFSET $3",
+		userID, pageSize, offset,
+	)
+}
+
+// relativeTime describes how far t is from now in words, such as "in 3 days" or "2 hours ago".
+func relativeTime(t, now time.Time) string {
+	d := t.Sub(now)
+	past := d < 0
+	if past {
+		d = -d
+	}
+
+	var amount int
+	var unit string
+
+	switch {
+	case d < time.Minute:
+		return "now"
+	case d < time.Hour:
+		amount, unit = int(d/time.Minute), "minute"
+	case d < 24*time.Hour:
+		amount, unit = int(d/time.Hour), "hour"
+	case d < 7*24*time.Hour:
+		amount, unit = int(d/(24*time.Hour)), "day"
+	case d < 30*24*time.Hour:
+		amount, unit = int(d/(7*24*time.Hour)), "week"
+	case d < 365*24*time.Hour:
+		amount, unit = int(d/(30*24*time.Hour)), "month"
+	default:
+		amount, unit = int(d/(365*24*time.Hour)), "year"
+	}
+
+	if amount != 1 {
+		unit += "s"
+	}
+
+	if past {
+		return fmt.Sprintf("%d %s ago", amount, unit)
+	}
+	return fmt.Sprintf("in %d %s", amount, unit)
+}
+
+// appointmentViewMenu is a function that displays the sections of the user's appointments they can switch between.
+// The option that the user selects is normalised and then passed to viewAppointments().
+func appointmentViewMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Show upcoming appointments")
+	fmt.Println("2. Show appointment history")
+	fmt.Println("3. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// showAppointmentSection prints one section of a user's appointments a page at a time.
+// where selects the section and fetch loads one page of it.
+func showAppointmentSection(scanner *bufio.Scanner, db *sql.DB, userID int, title, where string, fetch func(db *sql.DB, userID int, offset int) ([]appointment, error)) {
+	total, err := countAppointments(db, where, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println(title)
+	if total == 0 {
+		fmt.Println("None.")
+		return
+	}
+
+	pageAppointments(scanner, total, detailedFormat, func(offset int) ([]appointment, error) {
+		return fetch(db, userID, offset)
+	})
+}
+
+// viewAppointments is called when the user selects option "2" in the appointment menu.
+// Upcoming appointments are shown first, and the user can then switch to their appointment history.
+func viewAppointments(scanner *bufio.Scanner, db *sql.DB, u *user, userID int) {
+	total, err := countAppointments(db, "WHERE a.user_id = $1\n", userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if total == 0 {
+		fmt.Println("No appointments yet.")
+		return
+	}
+
+	fmt.Println(u.ownerSummaryString())
+	showAppointmentSection(scanner, db, userID, "Upcoming appointments:", upcomingWhere, getUpcomingAppointments)
+
+	for {
+		switch appointmentViewMenu(scanner) {
+		case "1":
+			showAppointmentSection(scanner, db, userID, "Upcoming appointments:", upcomingWhere, getUpcomingAppointments)
+
+		case "2":
+			showAppointmentSection(scanner, db, userID, "Appointment history:", historyWhere, getPastAppointments)
+
+		case "3":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
@@ -3,6 +3,7 @@ package main
 import (
 	"bufio"
 	"database/sql"
+	"errors"
 	"fmt"
 	"os"
 	"strconv"
@@ -35,10 +36,21 @@ type pet struct {
 // appointment is a struct that holds all information related to an appointment booked by the user.
 // This information is stored in the appointments table in the database.
 type appointment struct {
+	id              int
+	userID          int
 	appointmentType string
 	pet             pet
 	vet             string
 	dateTime        time.Time
+	status          string
+	owner           user
+	holdID          int
+	series          *appointmentSeries
+	price           int64
+	// triageLevel is set for appointments booked through the emergency path, such as "Emergency"; it is empty for routine bookings.
+	triageLevel string
+	// answers holds the owner's answers to the questionnaire for the appointment type, if it has one.
+	answers []questionAnswer
 }
 
 // allowedSpecies is a list that holds the options for choosing the pet's species for the appointment.
@@ -60,6 +72,17 @@ var allowedAppointmentTypes = []string{
 	"Dental",
 }
 
+// allowedStatuses is a list that holds the states an appointment can be in.
+// New appointments start as "Booked", move to "Checked-in" when the pet arrives and "In progress" when the vet calls them in.
+var allowedStatuses = []string{
+	"Booked",
+	"Checked-in",
+	"In progress",
+	"Cancelled",
+	"Completed",
+	"No-show",
+}
+
 // allowedVets is a list that holds the veterinarians that are available to the user.
 var allowedVets = []string{
 	"Dr Smith",
@@ -68,12 +91,13 @@ var allowedVets = []string{
 	"Dr Brown",
 }
 
-// mainMenu is a function displays a menu screen to the user with 3 options.
+// mainMenu is a function displays a menu screen to the user with 4 options.
 // The option that the user selects is normalised and then passed to main().
 func mainMenu(scanner *bufio.Scanner) string {
 	fmt.Println("1. New user")
 	fmt.Println("2. Existing user")
-	fmt.Println("3. Exit")
+	fmt.Println("3. Staff")
+	fmt.Println("4. Exit")
 	fmt.Print("> ")
 
 	scanner.Scan()
@@ -122,24 +146,41 @@ func getExistingUser(scanner *bufio.Scanner, db *sql.DB) (*user, int, error) {
 	return &u, id, nil
 }
 
-// getAppointmentsByUserID is a special function that is called when the user selects option "2" in the appointment menu to display their current appointments
-// This function queries the database using the user's previously submitted ID to fetch and save in memory any appointments tied to that user.
-// Any appointments in the database are returned in a list format.
-func getAppointmentsByUserID(db *sql.DB, userID int) ([]appointment, error) {
-	rows, err := db.Query(
-		`SELECT
-			pet_name,
-			pet_species,
-			pet_age,
-			pet_weight,
-			vaccinated,
-			appointment_type,
-			vet_name,
-			appointment_time
-		FROM appointments
-		WHERE user_id = $1`,
-		userID,
-	)
+// appointmentSelect is the query shared by every appointment lookup.
+// It joins each appointment to its owner so staff views can show who the booking belongs to.
+// Callers append their own WHERE and ORDER BY clauses.
+const appointmentSelect = `SELECT
+	a.id,
+	a.user_id,
+	a.pet_name,
+	a.pet_species,
+	a.pet_age,
+	a.pet_weight,
+	a.vaccinated,
+	a.appointment_type,
+	a.vet_name,
+	a.appointment_time,
+	a.status,
+	a.series_id,
+	a.price,
+	COALESCE(a.triage_level, ''),
+	u.first_name,
+	u.last_name,
+	u.phone,
+	u.email
+FROM appointments a
+JOIN users u ON u.id = a.user_id
+`
+
+// queryer is satisfied by both *sql.DB and *sql.Tx, so lookups can run inside the caller's transaction.
+type queryer interface {
+	Query(query string, args ...any) (*sql.Rows, error)
+}
+
+// queryAppointments runs appointmentSelect with the given clause appended and scans every row into an appointment.
+// The clause holds the WHERE/ORDER BY part of the query and args holds its placeholder values.
+func queryAppointments(db queryer, clause string, args ...any) ([]appointment, error) {
+	rows, err := db.Query(appointmentSelect+clause, args...)
 	if err != nil {
 		return nil, err
 	}
@@ -150,8 +191,11 @@ func getAppointmentsByUserID(db *sql.DB, userID int) ([]appointment, error) {
 	for rows.Next() {
 		var a appointment
 		var p pet
+		var seriesID sql.NullInt64
 
 		err := rows.Scan(
+			&a.id,
+			&a.userID,
 			&p.name,
 			&p.species,
 			&p.age,
@@ -160,16 +204,27 @@ func getAppointmentsByUserID(db *sql.DB, userID int) ([]appointment, error) {
 			&a.appointmentType,
 			&a.vet,
 			&a.dateTime,
+			&a.status,
+			&seriesID,
+			&a.price,
+			&a.triageLevel,
+			&a.owner.firstName,
+			&a.owner.lastName,
+			&a.owner.phone,
+			&a.owner.email,
 		)
 		if err != nil {
 			return nil, err
 		}
 
 		a.pet = p
+		if seriesID.Valid {
+			a.series = &appointmentSeries{id: int(seriesID.Int64)}
+		}
 		appointments = append(appointments, a)
 	}
 
-	return appointments, nil
+	return appointments, rows.Err()
 }
 
 // getUserFirstName is a helper function that prompts the user for their first name and then stores it.
@@ -430,12 +485,19 @@ func gatherUserInfo(scanner *bufio.Scanner) user {
 	return user
 }
 
-// appointmentMenu is a function displays a menu screen to the user with 3 options.
+// appointmentMenu is a function displays a menu screen to the user with the options for managing their appointments.
 // The option that the user selects is normalised and then passed to main().
 func appointmentMenu(scanner *bufio.Scanner) string {
 	fmt.Println("1. Create new appointment")
 	fmt.Println("2. View existing appointments")
-	fmt.Println("3. Exit")
+	fmt.Println("3. Reschedule an appointment")
+	fmt.Println("4. Cancel an appointment")
+	fmt.Println("5. Waitlist offers")
+	fmt.Println("6. Vaccination records")
+	fmt.Println("7. Account and payments")
+	fmt.Println("8. Pet history")
+	fmt.Println("9. Consent forms")
+	fmt.Println("10. Exit")
 	fmt.Print("> ")
 
 	scanner.Scan()
@@ -581,6 +643,22 @@ func getVaccinationStatus(scanner *bufio.Scanner, i int) (bool, error) {
 	}
 }
 
+// getYesNo is a helper function that asks the user a yes or no question.
+// If the input is not y/n, an error is returned.
+func getYesNo(scanner *bufio.Scanner, question string) (bool, error) {
+	fmt.Println(question, "(y/n): ")
+	scanner.Scan()
+
+	switch strings.TrimSpace(scanner.Text()) {
+	case "y", "Y":
+		return true, nil
+	case "n", "N":
+		return false, nil
+	default:
+		return false, fmt.Errorf("input must be y/n")
+	}
+}
+
 // getAppointmentType is a helper function that prompts the user to choose an appointment type and lists available options using the "allowedAppointmentTypes" list.
 // The input is stored and normalised.
 // If the input is not listed in "allowedAppointmentTypes", the user is prompted again.
@@ -626,28 +704,53 @@ func getVet(scanner *bufio.Scanner, i int) (string, error) {
 }
 
 // getPreferredDateTime is a helper function that allows the user to enter a preferred date and time for their appointment.
-// The user is prompted for a date and time in a specified format.
+// The user is prompted for a date and time, which can be typed exactly or in an everyday form such as "tomorrow 9am".
 // The input is stored and normalised.
-// The input is parsed and converted into a date and time format.
+// The input is parsed as a time in the clinic's timezone, including around daylight saving changes.
 // The input is then validated and an error is displayed if it doesn't pass the validation checks.
+// The fully resolved date and time is shown back to the user, and is only accepted once they confirm it.
 func getPreferredDateTime(scanner *bufio.Scanner, i int) (time.Time, error) {
 	fmt.Println("Please enter preferred date and time for appointment", i+1)
-	fmt.Println("Format: YYYY-MM-DD HH:MM (24-hour time)")
-	fmt.Println("Example: 2026-01-13 12:30")
+	fmt.Println("Times are in clinic time (" + clinicLocation.String() + ")")
+	fmt.Println("Examples: 2026-01-13 12:30, 13/01 12:30, tomorrow 9am, next tue 14:30, +2d 11:00")
 
 	scanner.Scan()
 	input := strings.TrimSpace(scanner.Text())
 
-	layout := "2006-01-02 15:04"
-	t, err := time.Parse(layout, input)
+	wall, err := parseFlexibleDateTime(input, time.Now())
+	if err != nil {
+		return time.Time{}, err
+	}
+
+	t, err := resolveClinicTime(scanner, wall)
 	if err != nil {
-		return time.Time{}, fmt.Errorf("invalid date/time format")
+		return time.Time{}, err
 	}
 
 	if t.Before(time.Now()) {
 		return time.Time{}, fmt.Errorf("Appointment cannot be in the past")
 	}
 
+	if err := checkClinicHours(t); err != nil {
+		return time.Time{}, err
+	}
+
+	fmt.Println("Appointment time:", t.Format("Monday, 02 Jan 2006 at 15:04 MST"), "("+relativeTime(t, time.Now())+")")
+
+	var confirmed bool
+	for {
+		c, err := getYesNo(scanner, "Is this correct?")
+		if err == nil {
+			confirmed = c
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	if !confirmed {
+		return time.Time{}, fmt.Errorf("please enter the date and time again")
+	}
+
 	return t, nil
 }
 
@@ -655,8 +758,9 @@ func getPreferredDateTime(scanner *bufio.Scanner, i int) (time.Time, error) {
 // If an error is received for a helper function, bookAppointments calls the function again, and the user is prompted for a valid input.
 // If a valid input is received for a helper function, bookAppointments will pass the valid input to the corresponding field in the newly initialised "appointment" objects.
 // The appointment objects are stored in a list to accommodate multiple appointments.
+// If the chosen time is not free, the user can pick another slot or join the waitlist, in which case that pet is left out of the list.
 // Once all fields in "appointment" are filled, bookAppointments returns the list of "appointment" objects.
-func bookAppointments(scanner *bufio.Scanner, petCount int) []appointment {
+func bookAppointments(scanner *bufio.Scanner, db *sql.DB, userID int, petCount int) []appointment {
 	appointments := make([]appointment, 0, petCount)
 
 	for i := 0; i < petCount; i++ {
@@ -699,56 +803,275 @@ func bookAppointments(scanner *bufio.Scanner, petCount int) []appointment {
 			fmt.Println("Error:", err)
 		}
 
+		d.vaccinated = getPetVaccinationStatus(scanner, db, userID, d, i)
+
+		a := appointment{pet: d}
+
+		// The booking rules are checked once the type and vet are known.
+		// A blocked booking, or a warning the user does not accept, goes back to choosing the appointment type.
 		for {
-			vaccinated, err := getVaccinationStatus(scanner, i)
-			if err == nil {
-				d.vaccinated = vaccinated
+			for {
+				appointmentType, err := getAppointmentType(scanner, i)
+				if err == nil {
+					a.appointmentType = appointmentType
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+
+			for {
+				v, err := getVet(scanner, i)
+				if err == nil {
+					a.vet = v
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+
+			results := evaluateRules(bookingRules, a)
+			warned := showRuleResults(results)
+
+			if _, blocked := blockingResult(results); blocked {
+				fmt.Println("Please choose a different appointment type.")
+				continue
+			}
+			if !warned {
+				break
+			}
+
+			var proceed bool
+			for {
+				p, err := getYesNo(scanner, "Do you still want to book this appointment?")
+				if err == nil {
+					proceed = p
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+			if proceed {
 				break
 			}
-			fmt.Println("Error:", err)
 		}
 
-		var a appointment
+		a.answers = askQuestionnaire(scanner, a)
 
+		// Vaccinations warn when a vaccine the pet needs will be out of stock on the chosen date, and the user can choose another date.
+		var chosen bool
 		for {
-			appointmentType, err := getAppointmentType(scanner, i)
-			if err == nil {
-				a.appointmentType = appointmentType
+			dt, holdID, ok := getAvailableDateTime(scanner, db, userID, i, a, appointments, true)
+			if !ok {
 				break
 			}
-			fmt.Println("Error:", err)
-		}
+			a.dateTime = dt
+			a.holdID = holdID
 
-		for {
-			v, err := getVet(scanner, i)
-			if err == nil {
-				a.vet = v
+			if confirmVaccineStock(scanner, db, userID, a) {
+				chosen = true
 				break
 			}
-			fmt.Println("Error:", err)
+			if err := releaseHolds(db, []appointment{a}); err != nil {
+				fmt.Println("Error:", err)
+			}
+			a.holdID = 0
+		}
+		if !chosen {
+			continue
 		}
 
+		a.status = "Booked"
+
+		var repeat bool
 		for {
-			dt, err := getPreferredDateTime(scanner, i)
+			r, err := getYesNo(scanner, "Should this appointment repeat?")
 			if err == nil {
-				a.dateTime = dt
+				repeat = r
 				break
 			}
 			fmt.Println("Error:", err)
 		}
 
-		a.pet = d
+		if !repeat {
+			appointments = append(appointments, a)
+			continue
+		}
+
+		occurrences := bookSeries(scanner, db, userID, &a, append(appointments, a))
 		appointments = append(appointments, a)
+		appointments = append(appointments, occurrences...)
 	}
 
 	return appointments
 }
 
+// insertAppointment stores one appointment for a user inside the caller's transaction.
+// The appointment's ID, user ID and owner are filled in once it is saved.
+// An error wrapping errBookingBlocked is returned, and nothing is saved, if the appointment breaks a blocking booking rule.
+// Emergency bookings, which have a triage level, are not held to the booking rules, as they displace routine work.
+// The appointment is priced from the price list as it is saved, so the invoice matches the quote even if prices change later.
+func insertAppointment(tx *sql.Tx, userID int, u user, a *appointment) error {
+	if a.triageLevel == "" {
+		if err := checkBookingRules(*a); err != nil {
+			return err
+		}
+	}
+
+	price, err := clinicPrices.priceFor(*a)
+	if err != nil {
+		return err
+	}
+	a.price = price
+
+	// Whether the type needs the owner's consent is stored with the appointment, so the database can refuse to admit the pet without it.
+	needsConsent, err := consentRequired(a.appointmentType)
+	if err != nil {
+		return err
+	}
+
+	err = tx.QueryRow(
+		`INSERT INTO appointments (
+			user_id,
+			pet_name,
+			pet_species,
+			pet_age,
+			pet_weight,
+			vaccinated,
+			appointment_type,
+			vet_name,
+			appointment_time,
+			series_id,
+			price,
+			triage_level,
+			consent_required
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10,$11,NULLIF($12, ''),$13)
+		RETURNING id`,
+		userID,
+		a.pet.name,
+		a.pet.species,
+		a.pet.age,
+		a.pet.weightKg,
+		a.pet.vaccinated,
+		a.appointmentType,
+		a.vet,
+		a.dateTime,
+		a.seriesID(),
+		a.price,
+		a.triageLevel,
+		needsConsent,
+	).Scan(&a.id)
+	if err != nil {
+		return err
+	}
+
+	if err := insertAnswers(tx, a.id, a.answers); err != nil {
+		return err
+	}
+
+	a.userID = userID
+	a.owner = u
+	return nil
+}
+
+// queueConfirmation renders the confirmation for a saved appointment and queues it to the owner inside the caller's transaction.
+// The plain text confirmation is returned so it can be shown on screen once the transaction commits.
+func queueConfirmation(tx *sql.Tx, channels []channel, u user, a appointment) (string, error) {
+	text, html, err := renderConfirmation(u, a)
+	if err != nil {
+		return "", err
+	}
+
+	if c := confirmationChannel(channels); c != nil {
+		if _, err := enqueueMessage(tx, confirmationMessage(a.userID, u, a, c, text, html)); err != nil {
+			return "", err
+		}
+	}
+	return text, nil
+}
+
+// showConfirmations prints confirmations on screen and then delivers the outbox straight away where possible.
+func showConfirmations(db *sql.DB, channels []channel, confirmations []string) {
+	for _, text := range confirmations {
+		fmt.Println("-------------------------------------")
+		fmt.Print(text)
+		fmt.Println("-------------------------------------")
+	}
+
+	// Anything that cannot be sent now stays in the outbox and is retried by the reminders runner.
+	if _, _, err := deliverPending(db, channels); err != nil {
+		fmt.Println("Error: could not send confirmation:", err)
+	}
+}
+
+// saveAppointments turns the held slots of newly booked appointments into confirmed bookings and queues a confirmation for each one, all in one transaction.
+// If a hold ran out and someone else took the slot in the meantime, that appointment is left out and the user is told.
+// Each confirmation is shown on screen once everything is saved.
+// The saved appointments are returned with their database IDs filled in.
+func saveAppointments(db *sql.DB, channels []channel, userID int, u user, appts []appointment) ([]appointment, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if err := releaseHolds(tx, appts); err != nil {
+		return nil, err
+	}
+
+	saved := make([]appointment, 0, len(appts))
+	confirmations := make([]string, 0, len(appts))
+
+	for _, a := range appts {
+		taken, err := slotTaken(tx, a.vet, a.dateTime, 0, nil)
+		if err != nil {
+			return nil, err
+		}
+		if taken {
+			fmt.Printf("Sorry, the hold on %s's appointment ran out and %s is no longer available at %s. Please book it again.\n",
+				a.pet.name, a.vet, a.dateTime.In(clinicLocation).Format("15:04 on Monday, 02 Jan"))
+			continue
+		}
+
+		if err := insertSeries(tx, userID, a.series); err != nil {
+			return nil, err
+		}
+
+		a.holdID = 0
+		err = insertAppointment(tx, userID, u, &a)
+		if errors.Is(err, errBookingBlocked) {
+			fmt.Printf("Sorry, %s's %s appointment cannot be booked: %s\n", a.pet.name, a.appointmentType, err)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if _, err := recordDeposit(tx, a); err != nil {
+			return nil, err
+		}
+
+		text, err := queueConfirmation(tx, channels, u, a)
+		if err != nil {
+			return nil, err
+		}
+		saved = append(saved, a)
+		confirmations = append(confirmations, text)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	showConfirmations(db, channels, confirmations)
+	return saved, nil
+}
+
 // summaryString prints a summary of each appointment's details.
 func (a *appointment) summaryString(i int) string {
 	var s string
 	s = "-------------------------------------\n"
 	s += fmt.Sprintf("Appointment %d information:\n", i)
+	if a.owner.lastName != "" {
+		s += fmt.Sprintf("Owner: %s %s\n", a.owner.firstName, a.owner.lastName)
+	}
 	s += fmt.Sprintf("Pet Name: %s\n", a.pet.name)
 	s += fmt.Sprintf("Species: %s\n", a.pet.species)
 	s += fmt.Sprintf("Age: %d\n", a.pet.age)
@@ -756,7 +1079,14 @@ func (a *appointment) summaryString(i int) string {
 	s += fmt.Sprintf("Vaccinated?: %t\n", a.pet.vaccinated)
 	s += fmt.Sprintf("Appointment Type: %s\n", a.appointmentType)
 	s += fmt.Sprintf("Vet: %s\n", a.vet)
-	s += fmt.Sprintf("Appointment Date & Time: %s\n", a.dateTime.Format("Monday, 02 Jan 2006 at 15:04"))
+	s += fmt.Sprintf("Appointment Date & Time: %s (%s)\n", a.dateTime.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04 MST"), relativeTime(a.dateTime, time.Now()))
+	if a.series != nil {
+		s += "Repeats: part of a series\n"
+	}
+	if a.triageLevel != "" {
+		s += fmt.Sprintf("Triage: %s\n", a.triageLevel)
+	}
+	s += fmt.Sprintf("Status: %s\n", a.status)
 	s += "-------------------------------------\n"
 
 	return s
@@ -790,12 +1120,68 @@ func main() {
 		return
 	}
 
+	loc, err := loadClinicLocation()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	clinicLocation = loc
+
+	channels, err := loadChannels()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+
+	rules, err := loadBookingRules()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	bookingRules = rules
+
+	prices, err := loadPriceList()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	clinicPrices = prices
+
+	drugs, err := loadFormulary()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	clinicFormulary = drugs
+
+	questionnaires, err := loadQuestionnaires()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	clinicQuestionnaires = questionnaires
+
+	pin, err := loadStaffPIN()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	staffPIN = pin
+
 	db, err := sql.Open("postgres", connStr)
 	if err != nil {
 		panic(err)
 	}
 	defer db.Close()
 
+	if len(os.Args) > 1 {
+		if err := runCommand(db, os.Args[1:]); err != nil {
+			fmt.Println("Error:", err)
+			os.Exit(1)
+		}
+		return
+	}
+
 	for {
 		choice := mainMenu(scanner)
 
@@ -832,6 +1218,12 @@ func main() {
 			}
 
 		case "3":
+			if checkStaffPIN(scanner) {
+				runStaffMenu(scanner, db, channels)
+			}
+			continue
+
+		case "4":
 			fmt.Println("Goodbye!")
 			return
 
@@ -843,6 +1235,10 @@ func main() {
 		break
 	}
 
+	if offers, err := getActiveOffers(db, userID); err == nil && len(offers) > 0 {
+		fmt.Printf("You have %d waitlist offer(s) waiting. Choose \"Waitlist offers\" to see them.\n", len(offers))
+	}
+
 	for {
 		userChoice := appointmentMenu(scanner)
 
@@ -858,55 +1254,71 @@ func main() {
 				fmt.Println("Error:", err)
 			}
 
-			newAppointments := bookAppointments(scanner, petCount)
-			appointments = append(appointments, newAppointments...)
-
-			for _, a := range newAppointments {
-				_, err := db.Exec(
-					`INSERT INTO appointments (
-						user_id,
-						pet_name,
-						pet_species,
-						pet_age,
-						pet_weight,
-						vaccinated,
-						appointment_type,
-						vet_name,
-						appointment_time
-					) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9)`,
-					userID,
-					a.pet.name,
-					a.pet.species,
-					a.pet.age,
-					a.pet.weightKg,
-					a.pet.vaccinated,
-					a.appointmentType,
-					a.vet,
-					a.dateTime,
-				)
-				if err != nil {
-					panic(err)
+			newAppointments := bookAppointments(scanner, db, userID, petCount)
+			if len(newAppointments) == 0 {
+				continue
+			}
+
+			for i, a := range newAppointments {
+				fmt.Print(a.summaryString(i + 1))
+			}
+			fmt.Print(quoteString(newAppointments))
+
+			var confirmed bool
+			for {
+				c, err := getYesNo(scanner, "Confirm these bookings?")
+				if err == nil {
+					confirmed = c
+					break
 				}
+				fmt.Println("Error:", err)
 			}
 
-		case "2":
-			appts, err := getAppointmentsByUserID(db, userID)
-			if err != nil {
-				fmt.Println("Error: ", err)
+			if !confirmed {
+				if err := releaseHolds(db, newAppointments); err != nil {
+					fmt.Println("Error:", err)
+				}
+				fmt.Println("Booking cancelled. No appointments were made.")
 				continue
 			}
 
-			if len(appts) == 0 {
-				fmt.Println("No appointments yet.")
+			newAppointments = confirmDeposits(scanner, db, userID, newAppointments)
+			if len(newAppointments) == 0 {
 				continue
 			}
 
-			fmt.Println(currentUser.ownerSummaryString())
-			for i, a := range appts {
-				fmt.Println(a.summaryString(i + 1))
+			saved, err := saveAppointments(db, channels, userID, *currentUser, newAppointments)
+			if err != nil {
+				fmt.Println("Error: could not save appointments:", err)
+				continue
 			}
+			appointments = append(appointments, saved...)
+
+		case "2":
+			viewAppointments(scanner, db, currentUser, userID)
 
 		case "3":
+			rescheduleAppointment(scanner, db, channels, userID)
+
+		case "4":
+			cancelAppointment(scanner, db, channels, userID)
+
+		case "5":
+			respondToOffers(scanner, db, channels, *currentUser, userID)
+
+		case "6":
+			viewVaccinations(scanner, db, userID)
+
+		case "7":
+			viewAccount(db, userID)
+
+		case "8":
+			viewPetHistory(scanner, db, *currentUser, userID, true)
+
+		case "9":
+			giveConsent(scanner, db, *currentUser, userID)
+
+		case "10":
 			fmt.Println("Goodbye!")
 			return
 
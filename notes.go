@@ -0,0 +1,477 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// clinicalNote is a struct that holds one version of a vet's SOAP notes for an appointment.
+// Notes start as a draft that can be edited; once signed they can never change, and any correction is made by adding a new version
+// with the reason for the amendment.
+type clinicalNote struct {
+	id              int
+	appointmentID   int
+	version         int
+	subjective      string
+	objective       string
+	assessment      string
+	plan            string
+	author          string
+	amendmentReason string
+	createdAt       time.Time
+	signedAt        sql.NullTime
+}
+
+// noteSection is a struct that holds one SOAP section of a note: its heading and the text it points at.
+type noteSection struct {
+	name  string
+	value *string
+}
+
+// noteSections lists the note's SOAP sections in order, so notes can be edited and printed section by section.
+func (n *clinicalNote) noteSections() []noteSection {
+	return []noteSection{
+		{"Subjective", &n.subjective},
+		{"Objective", &n.objective},
+		{"Assessment", &n.assessment},
+		{"Plan", &n.plan},
+	}
+}
+
+// signed reports whether the notes have been signed and can no longer be edited.
+func (n *clinicalNote) signed() bool {
+	return n.signedAt.Valid
+}
+
+// noteColumns is the column list shared by every clinical note lookup.
+const noteColumns = `n.id, n.appointment_id, n.version, n.subjective, n.objective, n.assessment, n.plan, n.author, n.amendment_reason,
+	n.created_at, n.signed_at`
+
+// scanNotes scans every row returned by a query over noteColumns.
+func scanNotes(rows *sql.Rows) ([]clinicalNote, error) {
+	defer rows.Close()
+
+	var notes []clinicalNote
+	for rows.Next() {
+		var n clinicalNote
+		err := rows.Scan(&n.id, &n.appointmentID, &n.version, &n.subjective, &n.objective, &n.assessment, &n.plan, &n.author,
+			&n.amendmentReason, &n.createdAt, &n.signedAt)
+		if err != nil {
+			return nil, err
+		}
+		notes = append(notes, n)
+	}
+	return notes, rows.Err()
+}
+
+// getNoteVersions fetches every version of an appointment's notes, oldest first.
+func getNoteVersions(db queryer, appointmentID int) ([]clinicalNote, error) {
+	rows, err := db.Query(
+		`SELECT `+noteColumns+`
+		 FROM clinical_notes n
+		 WHERE n.appointment_id = $1
+		 ORDER BY n.version`,
+		appointmentID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	return scanNotes(rows)
+}
+
+// getLatestNotes fetches the latest version of the notes for each of the given appointments, keyed by appointment ID.
+// For owners, only signed notes the clinic has shared with them are returned, so an amendment that is still a draft is not shown.
+func getLatestNotes(db queryer, appointmentIDs []int, forOwner bool) (map[int]clinicalNote, error) {
+	if len(appointmentIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(
+		`SELECT DISTINCT ON (n.appointment_id) `+noteColumns+`
+		 FROM clinical_notes n
+		 JOIN appointments a ON a.id = n.appointment_id
+		 WHERE n.appointment_id = ANY($1) AND (NOT $2 OR (n.signed_at IS NOT NULL AND a.notes_shared))
+		 ORDER BY n.appointment_id, n.version DESC`,
+		pq.Array(appointmentIDs),
+		forOwner,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	notes, err := scanNotes(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	latest := make(map[int]clinicalNote, len(notes))
+	for _, n := range notes {
+		latest[n.appointmentID] = n
+	}
+	return latest, nil
+}
+
+// noteString prints one version of an appointment's notes.
+// Staff also see whether the notes are signed and why they were amended.
+func noteString(n clinicalNote, forOwner bool) string {
+	var s string
+	if !forOwner {
+		status := "Draft, not signed"
+		if n.signed() {
+			status = "Signed by " + n.author + " on " + n.signedAt.Time.In(clinicLocation).Format("02 Jan 2006 at 15:04")
+		}
+		s += fmt.Sprintf("Notes version %d (%s)\n", n.version, status)
+		if n.amendmentReason != "" {
+			s += fmt.Sprintf("Amended: %s\n", n.amendmentReason)
+		}
+	} else {
+		s += fmt.Sprintf("Notes from %s:\n", n.author)
+	}
+
+	for _, section := range n.noteSections() {
+		value := *section.value
+		if value == "" {
+			value = "-"
+		}
+		s += fmt.Sprintf("  %s: %s\n", section.name, value)
+	}
+	return s
+}
+
+// getNoteSection is a helper function that prompts the vet for one section of their notes.
+// An empty input keeps the current text.
+func getNoteSection(scanner *bufio.Scanner, name string, current string) (string, error) {
+	if current == "" {
+		fmt.Printf("%s:\n", name)
+	} else {
+		fmt.Printf("%s (currently %q), or leave blank to keep it:\n", name, current)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if input == "" {
+		return current, nil
+	}
+	if len(input) > 2000 {
+		return "", fmt.Errorf("character limit is 2000 characters")
+	}
+	return input, nil
+}
+
+// getNoteAuthor is a helper function that asks which vet is writing the notes.
+// An empty input picks def, the appointment's vet.
+func getNoteAuthor(scanner *bufio.Scanner, def string) (string, error) {
+	fmt.Printf("Which vet is writing these notes? Leave blank for %s:\n", def)
+	for i, v := range allowedVets {
+		fmt.Printf("%d. %s\n", i+1, v)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+	if input == "" {
+		return def, nil
+	}
+
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > len(allowedVets) {
+		return "", fmt.Errorf("please select one of the vets displayed")
+	}
+	return allowedVets[choice-1], nil
+}
+
+// saveNoteDraft inserts a new version of an appointment's notes, or updates the draft it has already saved.
+// Signed notes are protected by the database and cannot be updated.
+func saveNoteDraft(db *sql.DB, n *clinicalNote) error {
+	if n.id == 0 {
+		return db.QueryRow(
+			`INSERT INTO clinical_notes (appointment_id, version, subjective, objective, assessment, plan, author, amendment_reason)
+			 VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			 RETURNING id, created_at`,
+			n.appointmentID,
+			n.version,
+			n.subjective,
+			n.objective,
+			n.assessment,
+			n.plan,
+			n.author,
+			n.amendmentReason,
+		).Scan(&n.id, &n.createdAt)
+	}
+
+	res, err := db.Exec(
+		`UPDATE clinical_notes
+		 SET subjective = $2, objective = $3, assessment = $4, plan = $5, author = $6, amendment_reason = $7
+		 WHERE id = $1 AND signed_at IS NULL`,
+		n.id,
+		n.subjective,
+		n.objective,
+		n.assessment,
+		n.plan,
+		n.author,
+		n.amendmentReason,
+	)
+	if err != nil {
+		return err
+	}
+	if rows, err := res.RowsAffected(); err != nil || rows == 0 {
+		return fmt.Errorf("these notes have already been signed")
+	}
+	return nil
+}
+
+// signNote signs a draft, after which it can never be changed.
+// Every section must be filled in first.
+func signNote(db *sql.DB, n *clinicalNote) error {
+	for _, section := range n.noteSections() {
+		if *section.value == "" {
+			return fmt.Errorf("the %s section must be filled in before signing", strings.ToLower(section.name))
+		}
+	}
+
+	err := db.QueryRow(
+		`UPDATE clinical_notes SET signed_at = now() WHERE id = $1 AND signed_at IS NULL RETURNING signed_at`,
+		n.id,
+	).Scan(&n.signedAt)
+	if err == sql.ErrNoRows {
+		return fmt.Errorf("these notes have already been signed")
+	}
+	return err
+}
+
+// getNotesAppointment is a helper function that asks staff for an owner and then for one of their appointments that has been seen.
+// false is returned if the owner has no such appointments or staff choose to go back.
+func getNotesAppointment(scanner *bufio.Scanner, db *sql.DB) (appointment, bool) {
+	var userID int
+	for {
+		_, id, err := getStaffOwner(scanner, db)
+		if err == nil {
+			userID = id
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	appts, err := queryAppointments(db,
+		`WHERE a.user_id = $1 AND a.status IN ('In progress', 'Completed')
+		 ORDER BY a.appointment_time DESC, a.id DESC
+		 LIMIT 20`,
+		userID,
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return appointment{}, false
+	}
+	if len(appts) == 0 {
+		fmt.Println("This owner has no appointments that have been seen yet.")
+		return appointment{}, false
+	}
+
+	return chooseAppointment(scanner, appts)
+}
+
+// writeNotes is called when staff select "Write or amend notes" in the clinical notes menu.
+// A draft can be edited as often as needed. Once notes are signed, changing them adds a new version with the reason for the amendment.
+func writeNotes(scanner *bufio.Scanner, db *sql.DB) {
+	a, ok := getNotesAppointment(scanner, db)
+	if !ok {
+		return
+	}
+
+	versions, err := getNoteVersions(db, a.id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	n := clinicalNote{appointmentID: a.id, version: 1, author: a.vet}
+	if len(versions) > 0 {
+		latest := versions[len(versions)-1]
+		fmt.Print(noteString(latest, false))
+
+		if !latest.signed() {
+			n = latest
+		} else {
+			var amend bool
+			for {
+				am, err := getYesNo(scanner, "These notes are signed and cannot be changed. Add an amended version?")
+				if err == nil {
+					amend = am
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+			if !amend {
+				return
+			}
+
+			n = latest
+			n.id = 0
+			n.version = latest.version + 1
+			n.signedAt = sql.NullTime{}
+			for {
+				r, err := getRequiredText(scanner, "Reason for the amendment:", 200)
+				if err == nil {
+					n.amendmentReason = r
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+		}
+	}
+
+	for {
+		v, err := getNoteAuthor(scanner, n.author)
+		if err == nil {
+			n.author = v
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	for _, section := range n.noteSections() {
+		for {
+			v, err := getNoteSection(scanner, section.name, *section.value)
+			if err == nil {
+				*section.value = v
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	if err := saveNoteDraft(db, &n); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Notes saved as a draft.")
+
+	var sign bool
+	for {
+		s, err := getYesNo(scanner, "Sign these notes now? Signed notes can no longer be edited.")
+		if err == nil {
+			sign = s
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if !sign {
+		return
+	}
+
+	if err := signNote(db, &n); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Notes signed.")
+}
+
+// shareNotes is called when staff select "Share notes with an owner" in the clinical notes menu.
+// Owners only see an appointment's signed notes once they have been shared, and sharing can be withdrawn again.
+func shareNotes(scanner *bufio.Scanner, db *sql.DB) {
+	a, ok := getNotesAppointment(scanner, db)
+	if !ok {
+		return
+	}
+
+	var share bool
+	for {
+		s, err := getYesNo(scanner, "Let the owner see the signed notes for "+a.pet.name+"'s appointment?")
+		if err == nil {
+			share = s
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	if _, err := db.Exec(`UPDATE appointments SET notes_shared = $2 WHERE id = $1`, a.id, share); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if share {
+		fmt.Println("The owner can now see the signed notes for this appointment.")
+	} else {
+		fmt.Println("The notes for this appointment are no longer shared with the owner.")
+	}
+}
+
+// viewNoteVersions is called when staff select "View every version of an appointment's notes" in the clinical notes menu.
+func viewNoteVersions(scanner *bufio.Scanner, db *sql.DB) {
+	a, ok := getNotesAppointment(scanner, db)
+	if !ok {
+		return
+	}
+
+	versions, err := getNoteVersions(db, a.id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(versions) == 0 {
+		fmt.Println("There are no notes for this appointment yet.")
+		return
+	}
+
+	fmt.Println("-------------------------------------")
+	for _, n := range versions {
+		fmt.Print(noteString(n, false))
+		fmt.Println("-------------------------------------")
+	}
+}
+
+// notesMenu is a function that displays the clinical notes menu and returns the option selected.
+func notesMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Write or amend notes")
+	fmt.Println("2. Share notes with an owner")
+	fmt.Println("3. View a pet's history")
+	fmt.Println("4. View every version of an appointment's notes")
+	fmt.Println("5. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// manageNotes is called when staff select "Clinical notes" in the staff menu.
+func manageNotes(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch notesMenu(scanner) {
+		case "1":
+			writeNotes(scanner, db)
+
+		case "2":
+			shareNotes(scanner, db)
+
+		case "3":
+			var u user
+			var userID int
+			for {
+				o, id, err := getStaffOwner(scanner, db)
+				if err == nil {
+					u, userID = o, id
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+			viewPetHistory(scanner, db, u, userID, false)
+
+		case "4":
+			viewNoteVersions(scanner, db)
+
+		case "5":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
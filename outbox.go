@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/smtp"
+	"net/textproto"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// maxDeliveryAttempts is how many times a message is tried before it is marked as failed.
+// retryBaseDelay is the wait before the first retry; it doubles after every failed attempt.
+// deliveryClaim is how long a runner has to send the messages it has claimed before another runner may pick them up.
+const (
+	maxDeliveryAttempts = 5
+	retryBaseDelay      = time.Minute
+	deliveryClaim       = 30 * time.Minute
+)
+
+// execer is satisfied by both *sql.DB and *sql.Tx, so messages can be queued inside the caller's transaction.
+type execer interface {
+	Exec(query string, args ...any) (sql.Result, error)
+}
+
+// outboxMessage is a struct that holds one message waiting in, or sent from, the outbox table.
+// appointmentAt is the appointment time a reminder was written for, so it is not sent once the appointment has moved.
+type outboxMessage struct {
+	id            int
+	userID        int
+	appointmentID sql.NullInt64
+	appointmentAt sql.NullTime
+	kind          string
+	channel       string
+	recipient     string
+	subject       string
+	body          string
+	htmlBody      string
+	dedupeKey     string
+	attempts      int
+}
+
+// enqueueMessage adds a message to the outbox.
+// A message whose dedupe key has already been queued is skipped, and false is returned.
+func enqueueMessage(db execer, m outboxMessage) (bool, error) {
+	res, err := db.Exec(
+		`INSERT INTO outbox (user_id, appointment_id, appointment_time, kind, channel, recipient, subject, body, html_body, dedupe_key)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, NULLIF($9, ''), $10)
+		 ON CONFLICT (dedupe_key) DO NOTHING`,
+		m.userID,
+		m.appointmentID,
+		m.appointmentAt,
+		m.kind,
+		m.channel,
+		m.recipient,
+		m.subject,
+		m.body,
+		m.htmlBody,
+		m.dedupeKey,
+	)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := res.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return n == 1, nil
+}
+
+// channel is a way of delivering outbox messages to an owner.
+type channel interface {
+	// name is the name the channel is configured and stored under, such as "email".
+	name() string
+	// recipient picks the address the channel delivers to from the owner's contact details.
+	recipient(u user) string
+	// send delivers one message.
+	send(m outboxMessage) error
+}
+
+// emailChannel delivers messages by email through an SMTP server.
+type emailChannel struct {
+	addr     string
+	from     string
+	username string
+	password string
+}
+
+func (c *emailChannel) name() string { return "email" }
+
+func (c *emailChannel) recipient(u user) string { return u.email }
+
+func (c *emailChannel) send(m outboxMessage) error {
+	var auth smtp.Auth
+	if c.username != "" {
+		host, _, _ := strings.Cut(c.addr, ":")
+		auth = smtp.PlainAuth("", c.username, c.password, host)
+	}
+
+	var msg bytes.Buffer
+	msg.WriteString("From: " + c.from + "\r\n")
+	msg.WriteString("To: " + m.recipient + "\r\n")
+	msg.WriteString("Subject: " + mime.QEncoding.Encode("UTF-8", m.subject) + "\r\n")
+	msg.WriteString("MIME-Version: 1.0\r\n")
+
+	if m.htmlBody == "" {
+		msg.WriteString("Content-Type: text/plain; charset=UTF-8\r\n\r\n")
+		msg.WriteString(strings.ReplaceAll(m.body, "\n", "\r\n"))
+		return smtp.SendMail(c.addr, auth, c.from, []string{m.recipient}, msg.Bytes())
+	}
+
+	// Messages with an HTML body are sent with the plain text as an alternative for mail clients that cannot show HTML.
+	var parts bytes.Buffer
+	w := multipart.NewWriter(&parts)
+
+	for _, part := range []struct{ contentType, body string }{
+		{"text/plain; charset=UTF-8", m.body},
+		{"text/html; charset=UTF-8", m.htmlBody},
+	} {
+		pw, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {part.contentType}})
+		if err != nil {
+			return err
+		}
+		if _, err := pw.Write([]byte(strings.ReplaceAll(part.body, "\n", "\r\n"))); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	msg.WriteString("Content-Type: multipart/alternative; boundary=" + w.Boundary() + "\r\n\r\n")
+	msg.Write(parts.Bytes())
+
+	return smtp.SendMail(c.addr, auth, c.from, []string{m.recipient}, msg.Bytes())
+}
+
+// smsGateway is a provider that can send a text message to a phone number.
+type smsGateway interface {
+	sendSMS(to, body string) error
+}
+
+// httpSMSGateway sends text messages by posting them to an HTTP gateway.
+// The gateway receives the "to" and "body" form fields, with the token sent as a bearer token.
+type httpSMSGateway struct {
+	url    string
+	token  string
+	client *http.Client
+}
+
+func (g *httpSMSGateway) sendSMS(to, body string) error {
+	form := url.Values{}
+	form.Set("to", to)
+	form.Set("body", body)
+
+	req, err := http.NewRequest(http.MethodPost, g.url, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if g.token != "" {
+		req.Header.Set("Authorization", "Bearer "+g.token)
+	}
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("sms gateway returned %s", resp.Status)
+	}
+	return nil
+}
+
+// smsChannel delivers messages as text messages through an SMS gateway.
+type smsChannel struct {
+	gateway smsGateway
+}
+
+func (c *smsChannel) name() string { return "sms" }
+
+func (c *smsChannel) recipient(u user) string { return u.phone }
+
+func (c *smsChannel) send(m outboxMessage) error {
+	return c.gateway.sendSMS(m.recipient, m.subject+"\n"+m.body)
+}
+
+// logChannel "delivers" messages by appending them to a local file.
+// It is useful for trying reminders out without an email server or SMS gateway.
+type logChannel struct {
+	path string
+}
+
+func (c *logChannel) name() string { return "log" }
+
+func (c *logChannel) recipient(u user) string { return u.email }
+
+func (c *logChannel) send(m outboxMessage) error {
+	f, err := os.OpenFile(c.path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	_, err = fmt.Fprintf(f, "[%s] %s to %s (%s)\n%s\n\n",
+		time.Now().In(clinicLocation).Format("2006-01-02 15:04:05"),
+		m.kind,
+		m.recipient,
+		m.subject,
+		m.body,
+	)
+	return err
+}
+
+// loadChannels builds the delivery channels listed in the NOTIFY_CHANNELS environment variable (for example "email,sms").
+// If the variable is not set, only the log channel is used.
+func loadChannels() ([]channel, error) {
+	names := os.Getenv("NOTIFY_CHANNELS")
+	if names == "" {
+		names = "log"
+	}
+
+	var channels []channel
+
+	for _, name := range strings.Split(names, ",") {
+		switch strings.TrimSpace(name) {
+		case "email":
+			host := os.Getenv("SMTP_HOST")
+			from := os.Getenv("SMTP_FROM")
+			if host == "" || from == "" {
+				return nil, fmt.Errorf("SMTP_HOST and SMTP_FROM must be set to use the email channel")
+			}
+			port := os.Getenv("SMTP_PORT")
+			if port == "" {
+				port = "587"
+			}
+			channels = append(channels, &emailChannel{
+				addr:     host + ":" + port,
+				from:     from,
+				username: os.Getenv("SMTP_USERNAME"),
+				password: os.Getenv("SMTP_PASSWORD"),
+			})
+
+		case "sms":
+			gatewayURL := os.Getenv("SMS_GATEWAY_URL")
+			if gatewayURL == "" {
+				return nil, fmt.Errorf("SMS_GATEWAY_URL must be set to use the sms channel")
+			}
+			channels = append(channels, &smsChannel{gateway: &httpSMSGateway{
+				url:    gatewayURL,
+				token:  os.Getenv("SMS_GATEWAY_TOKEN"),
+				client: &http.Client{Timeout: 10 * time.Second},
+			}})
+
+		case "log":
+			path := os.Getenv("NOTIFY_LOG_FILE")
+			if path == "" {
+				path = "notifications.log"
+			}
+			channels = append(channels, &logChannel{path: path})
+
+		case "":
+			continue
+
+		default:
+			return nil, fmt.Errorf("unknown notification channel %q", name)
+		}
+	}
+
+	return channels, nil
+}
+
+// deliverPending sends every outbox message that is due, using the channel each message was queued for.
+// Reminders for appointments that are no longer booked, or have moved since the reminder was written, are marked as skipped instead of being sent.
+// Successful deliveries are marked as sent.
+// Failed deliveries are retried later with a growing delay, and are marked as failed after maxDeliveryAttempts.
+// The number of messages sent and failed on this run is returned.
+func deliverPending(db *sql.DB, channels []channel) (int, int, error) {
+	byName := map[string]channel{}
+	for _, c := range channels {
+		byName[c.name()] = c
+	}
+
+	// Messages are claimed in one short statement by pushing their next attempt back by deliveryClaim, so that two copies
+	// of the reminder runner never send the same message, and no locks are held while slow gateways are contacted.
+	// If the runner stops before recording the outcome, the messages are picked up again once the claim runs out.
+	// Stale reminders are skipped in the same statement, so a reminder waiting on a retry is never sent after a cancellation or reschedule.
+	rows, err := db.Query(
+		`WITH due AS (
+			SELECT o.id,
+			       o.kind = 'reminder' AND (
+			           a.status IS DISTINCT FROM 'Booked'
+			           OR (o.appointment_time IS NOT NULL AND a.appointment_time <> o.appointment_time)
+			       ) AS stale
+			FROM outbox o
+			LEFT JOIN appointments a ON a.id = o.appointment_id
+			WHERE o.status = 'Pending' AND o.next_attempt_at <= now()
+			ORDER BY o.id
+			LIMIT 100
+			FOR UPDATE OF o SKIP LOCKED
+		 )
+		 UPDATE outbox o
+		 SET status = CASE WHEN due.stale THEN 'Skipped' ELSE o.status END,
+		     last_error = CASE WHEN due.stale THEN 'The appointment was cancelled or moved before the reminder was sent' ELSE o.last_error END,
+		     next_attempt_at = CASE WHEN due.stale THEN o.next_attempt_at ELSE now() + $1 * interval '1 second' END
+		 FROM due
+		 WHERE o.id = due.id
+		 RETURNING o.id, o.kind, o.channel, o.recipient, o.subject, o.body, COALESCE(o.html_body, ''), o.attempts, due.stale`,
+		int(deliveryClaim.Seconds()),
+	)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	var pending []outboxMessage
+	for rows.Next() {
+		var m outboxMessage
+		var stale bool
+		if err := rows.Scan(&m.id, &m.kind, &m.channel, &m.recipient, &m.subject, &m.body, &m.htmlBody, &m.attempts, &stale); err != nil {
+			rows.Close()
+			return 0, 0, err
+		}
+		if !stale {
+			pending = append(pending, m)
+		}
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, 0, err
+	}
+	slices.SortFunc(pending, func(a, b outboxMessage) int { return a.id - b.id })
+
+	sent, failed := 0, 0
+
+	for _, m := range pending {
+		var sendErr error
+		c, ok := byName[m.channel]
+		if ok {
+			sendErr = c.send(m)
+		} else {
+			sendErr = fmt.Errorf("channel %q is not configured", m.channel)
+		}
+
+		if sendErr == nil {
+			_, err = db.Exec(
+				`UPDATE outbox
+				 SET status = 'Sent', attempts = attempts + 1, sent_at = now(), last_error = NULL
+				 WHERE id = $1`,
+				m.id,
+			)
+			if err != nil {
+				return sent, failed, err
+			}
+			sent++
+			continue
+		}
+
+		status := "Pending"
+		if m.attempts+1 >= maxDeliveryAttempts {
+			status = "Failed"
+		}
+		delay := retryBaseDelay << m.attempts
+
+		_, err = db.Exec(
+			`UPDATE outbox
+			 SET status = $2, attempts = attempts + 1, last_error = $3, next_attempt_at = now() + $4 * interval '1 second'
+			 WHERE id = $1`,
+			m.id,
+			status,
+			sendErr.Error(),
+			int(delay.Seconds()),
+		)
+		if err != nil {
+			return sent, failed, err
+		}
+		failed++
+	}
+
+	return sent, failed, nil
+}
+
+// outboxStatusString prints how many outbox messages are in each state, followed by the messages that have failed.
+func outboxStatusString(db *sql.DB) (string, error) {
+	rows, err := db.Query(`SELECT status, COUNT(*) FROM outbox GROUP BY status ORDER BY status`)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var s string
+	s = "-------------------------------------\n"
+	s += "Outbox:\n"
+	for rows.Next() {
+		var status string
+		var count int
+		if err := rows.Scan(&status, &count); err != nil {
+			return "", err
+		}
+		s += fmt.Sprintf("%s: %d\n", status, count)
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	failedRows, err := db.Query(
+		`SELECT id, kind, channel, recipient, attempts, COALESCE(last_error, '')
+		 FROM outbox
+		 WHERE status = 'Failed'
+		 ORDER BY id DESC
+		 LIMIT 20`,
+	)
+	if err != nil {
+		return "", err
+	}
+	defer failedRows.Close()
+
+	for failedRows.Next() {
+		var m outboxMessage
+		var lastError string
+		if err := failedRows.Scan(&m.id, &m.kind, &m.channel, &m.recipient, &m.attempts, &lastError); err != nil {
+			return "", err
+		}
+		s += fmt.Sprintf("Failed #%d: %s via %s to %s after %d attempts: %s\n", m.id, m.kind, m.channel, m.recipient, m.attempts, lastError)
+	}
+	s += "-------------------------------------\n"
+
+	return s, failedRows.Err()
+}
@@ -0,0 +1,648 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// paymentMethods lists the ways an owner can pay or be refunded.
+var paymentMethods = []string{"Card", "Cash", "Bank transfer"}
+
+// payment is a struct that holds one entry in the payments ledger.
+// The kind is Deposit (paid up front against an appointment rather than an invoice), Payment (paid towards an invoice, including a deposit invoice), Refund (paid back to the owner)
+// or Retained (part of a payment kept by the clinic when an appointment is cancelled, which has no method).
+// Amounts are in minor units and are always positive; the kind says which way the money went.
+// This information is stored in the payments table in the database.
+type payment struct {
+	id            int
+	userID        int
+	appointmentID sql.NullInt64
+	invoiceID     sql.NullInt64
+	kind          string
+	amount        int64
+	method        string
+	reference     string
+	createdAt     time.Time
+}
+
+// insertPayment records an entry in the payments ledger.
+func insertPayment(db execer, p payment) error {
+	_, err := db.Exec(
+		`INSERT INTO payments (user_id, appointment_id, invoice_id, kind, amount, method, reference)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		p.userID,
+		p.appointmentID,
+		p.invoiceID,
+		p.kind,
+		p.amount,
+		p.method,
+		p.reference,
+	)
+	return err
+}
+
+// rowQueryer is satisfied by both *sql.DB and *sql.Tx, for lookups that return a single row.
+type rowQueryer interface {
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+// lookupQueryer is satisfied by both *sql.DB and *sql.Tx, for lookups that need both kinds of query.
+type lookupQueryer interface {
+	queryer
+	rowQueryer
+}
+
+// paidForAppointment adds up what has been paid for an appointment so far, less anything refunded.
+// Payments made against the appointment's invoice count as well as deposits made against the appointment itself.
+func paidForAppointment(db rowQueryer, appointmentID int) (int64, error) {
+	var paid int64
+	err := db.QueryRow(
+		`SELECT COALESCE(SUM(CASE WHEN p.kind IN ('Refund', 'Retained') THEN -p.amount ELSE p.amount END), 0)
+		 FROM payments p
+		 LEFT JOIN invoices i ON i.id = p.invoice_id
+		 WHERE p.appointment_id = $1 OR i.appointment_id = $1`,
+		appointmentID,
+	).Scan(&paid)
+	return paid, err
+}
+
+// recordDeposit charges the deposit for a newly booked appointment inside the caller's transaction, if its type or the owner's record needs one.
+// The deposit is issued as a deposit invoice, so it shows as owed on the owner's account until staff record the payment against it.
+// The amount charged is returned.
+func recordDeposit(tx *sql.Tx, a appointment) (int64, error) {
+	restricted, err := isRestricted(tx, a.userID)
+	if err != nil {
+		return 0, err
+	}
+
+	deposit, err := clinicPrices.depositFor(a, restricted)
+	if err != nil || deposit == 0 {
+		return 0, err
+	}
+
+	inv := invoice{
+		appointmentID: a.id,
+		userID:        a.userID,
+		currency:      clinicPrices.Currency,
+		deposit:       true,
+		lines:         []invoiceLine{{description: "Deposit: " + a.appointmentType + " appointment", quantity: 1, unitPrice: deposit}},
+	}
+	inv.calculate()
+
+	return deposit, insertInvoice(tx, &inv)
+}
+
+// paymentMethodFor finds how the most recent payment for an appointment was made, so a refund can be paid back the same way.
+func paymentMethodFor(db rowQueryer, appointmentID int) (string, error) {
+	var method string
+	err := db.QueryRow(
+		`SELECT p.method
+		 FROM payments p
+		 LEFT JOIN invoices i ON i.id = p.invoice_id
+		 WHERE (p.appointment_id = $1 OR i.appointment_id = $1) AND p.kind IN ('Deposit', 'Payment')
+		 ORDER BY p.created_at DESC, p.id DESC
+		 LIMIT 1`,
+		appointmentID,
+	).Scan(&method)
+	return method, err
+}
+
+// refundCancellation settles what was paid for a cancelled appointment inside the caller's transaction, following the refund policy.
+// How much is refunded depends on how much notice was given before the appointment; the rest is recorded as retained by the clinic.
+// The refund is paid back the same way the owner last paid.
+// The amount refunded is returned, which is 0 when nothing was paid or the policy gives nothing back.
+func refundCancellation(tx *sql.Tx, userID int, appointmentID int, appointmentTime time.Time, now time.Time) (int64, error) {
+	paid, err := paidForAppointment(tx, appointmentID)
+	if err != nil || paid <= 0 {
+		return 0, err
+	}
+
+	percent := clinicPrices.refundPercent(appointmentTime.Sub(now))
+	refund := paid * int64(percent) / 100
+	appointment := sql.NullInt64{Int64: int64(appointmentID), Valid: true}
+
+	if refund > 0 {
+		method, err := paymentMethodFor(tx, appointmentID)
+		if err != nil {
+			return 0, err
+		}
+		err = insertPayment(tx, payment{
+			userID:        userID,
+			appointmentID: appointment,
+			kind:          "Refund",
+			amount:        refund,
+			method:        method,
+			reference:     fmt.Sprintf("Cancellation refund (%d%%)", percent),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	if kept := paid - refund; kept > 0 {
+		err := insertPayment(tx, payment{
+			userID:        userID,
+			appointmentID: appointment,
+			kind:          "Retained",
+			amount:        kept,
+			reference:     fmt.Sprintf("Kept under the cancellation policy (%d%% refunded)", percent),
+		})
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return refund, nil
+}
+
+// refundPolicyString describes the cancellation refund policy, for showing to owners before they pay a deposit.
+func refundPolicyString() string {
+	var parts []string
+	for _, tier := range clinicPrices.RefundPolicy {
+		if tier.NoticeHours == 0 {
+			parts = append(parts, fmt.Sprintf("%d%% with less notice", tier.Percent))
+			continue
+		}
+		parts = append(parts, fmt.Sprintf("%d%% if cancelled at least %d hours before", tier.Percent, tier.NoticeHours))
+	}
+	if len(parts) == 0 {
+		return "Deposits are not refunded if the appointment is cancelled."
+	}
+	return "Refunds on cancellation: " + strings.Join(parts, ", ") + "."
+}
+
+// ledgerEntry is a struct that holds one line of an owner's account: an invoice they were charged, or money paid or refunded.
+// amount is positive for what the owner owes and negative for what they paid.
+type ledgerEntry struct {
+	at          time.Time
+	description string
+	amount      int64
+}
+
+// getLedger fetches an owner's invoices and payments in the order they happened.
+// A deposit invoice is only owed while its appointment is still to come: once the appointment is completed its full price is on the final invoice,
+// and once it is cancelled or missed the cancellation policy decides what is kept, so the deposit invoice is then listed as closed with nothing owed.
+func getLedger(db queryer, userID int) ([]ledgerEntry, error) {
+	rows, err := db.Query(
+		`SELECT i.issued_at,
+		        CASE WHEN NOT i.deposit THEN 'Invoice ' || i.invoice_number
+		             WHEN a.status IN ('Booked', 'Checked-in', 'In progress') THEN 'Deposit invoice ' || i.invoice_number
+		             ELSE 'Deposit invoice ' || i.invoice_number || ' (closed)' END,
+		        CASE WHEN NOT i.deposit OR a.status IN ('Booked', 'Checked-in', 'In progress') THEN i.total ELSE 0 END
+		 FROM invoices i
+		 JOIN appointments a ON a.id = i.appointment_id
+		 WHERE i.user_id = $1
+		 UNION ALL
+		 SELECT created_at, kind || CASE WHEN method <> '' THEN ' (' || method || ')' ELSE '' END || CASE WHEN reference <> '' THEN ' - ' || reference ELSE '' END,
+		        CASE WHEN kind IN ('Refund', 'Retained') THEN amount ELSE -amount END
+		 FROM payments
+		 WHERE user_id = $1
+		 ORDER BY 1`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []ledgerEntry
+	for rows.Next() {
+		var e ledgerEntry
+		if err := rows.Scan(&e.at, &e.description, &e.amount); err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// balanceString describes a balance: what the owner owes, or the credit they have when it is negative.
+func balanceString(balance int64) string {
+	switch {
+	case balance > 0:
+		return formatMoney(balance, clinicPrices.Currency) + " owed"
+	case balance < 0:
+		return formatMoney(-balance, clinicPrices.Currency) + " in credit"
+	default:
+		return "nothing owed"
+	}
+}
+
+// ledgerString prints an owner's account with a running balance.
+// A paid deposit invoice is balanced by its payment, and once the appointment is completed the deposit paid counts towards its final invoice.
+func ledgerString(entries []ledgerEntry) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += "Account:\n"
+
+	if len(entries) == 0 {
+		s += "No invoices or payments yet.\n"
+	}
+
+	var balance int64
+	for _, e := range entries {
+		balance += e.amount
+		s += fmt.Sprintf("%s  %-50s %14s %14s\n",
+			e.at.In(clinicLocation).Format("2006-01-02"),
+			e.description,
+			formatMoney(e.amount, clinicPrices.Currency),
+			formatMoney(balance, clinicPrices.Currency),
+		)
+	}
+	s += fmt.Sprintf("Balance: %s\n", balanceString(balance))
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// viewAccount shows an owner's account and balance.
+func viewAccount(db *sql.DB, userID int) {
+	entries, err := getLedger(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Print(ledgerString(entries))
+
+	strikes, err := getStrikeCounts(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if strikes.total() > 0 {
+		fmt.Print(strikesString(strikes))
+	}
+}
+
+// ownerBalance is a struct that holds an owner's outstanding balance for the balances report.
+type ownerBalance struct {
+	userID  int
+	owner   user
+	balance int64
+}
+
+// getOutstandingBalances fetches every owner who owes the clinic money, largest balance first.
+// Deposit invoices count in the same way as on the owner's account, see getLedger.
+func getOutstandingBalances(db queryer) ([]ownerBalance, error) {
+	rows, err := db.Query(
+		`SELECT u.id, u.first_name, u.last_name, u.phone, u.email, b.balance
+		 FROM (
+			SELECT user_id, SUM(amount) AS balance
+			FROM (
+				SELECT i.user_id, i.total AS amount
+				FROM invoices i
+				JOIN appointments a ON a.id = i.appointment_id
+				WHERE NOT i.deposit OR a.status IN ('Booked', 'Checked-in', 'In progress')
+				UNION ALL
+				SELECT user_id, CASE WHEN kind IN ('Refund', 'Retained') THEN amount ELSE -amount END FROM payments
+			) l
+			GROUP BY user_id
+		 ) b
+		 JOIN users u ON u.id = b.user_id
+		 WHERE b.balance > 0
+		 ORDER BY b.balance DESC, u.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var balances []ownerBalance
+	for rows.Next() {
+		var b ownerBalance
+		err := rows.Scan(&b.userID, &b.owner.firstName, &b.owner.lastName, &b.owner.phone, &b.owner.email, &b.balance)
+		if err != nil {
+			return nil, err
+		}
+		balances = append(balances, b)
+	}
+	return balances, rows.Err()
+}
+
+// outstandingBalancesString prints the outstanding balances report.
+func outstandingBalancesString(balances []ownerBalance) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += "Outstanding balances:\n"
+
+	if len(balances) == 0 {
+		s += "None.\n"
+	}
+
+	var total int64
+	for _, b := range balances {
+		total += b.balance
+		s += fmt.Sprintf("%6d  %-30s %-15s %14s\n", b.userID, b.owner.firstName+" "+b.owner.lastName, b.owner.phone, formatMoney(b.balance, clinicPrices.Currency))
+	}
+	s += fmt.Sprintf("Total outstanding: %s\n", formatMoney(total, clinicPrices.Currency))
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// getPaymentMethod is a helper function that prompts for how a payment was made, listing the options in "paymentMethods".
+func getPaymentMethod(scanner *bufio.Scanner) (string, error) {
+	fmt.Println("Payment method:")
+	for i, m := range paymentMethods {
+		fmt.Printf("%d. %s\n", i+1, m)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(paymentMethods) {
+		return "", fmt.Errorf("please select one of the methods displayed")
+	}
+	return paymentMethods[choice-1], nil
+}
+
+// getAmount is a helper function that prompts for an amount of money, which must be more than 0.
+// An empty input uses def, when def is more than 0.
+func getAmount(scanner *bufio.Scanner, prompt string, def int64) (int64, error) {
+	if def > 0 {
+		fmt.Printf("%s, or leave blank for %s:\n", prompt, formatMoney(def, clinicPrices.Currency))
+	} else {
+		fmt.Printf("%s:\n", prompt)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+	if input == "" && def > 0 {
+		return def, nil
+	}
+
+	amount, err := parseMoney(input)
+	if err != nil {
+		return 0, err
+	}
+	if amount <= 0 {
+		return 0, fmt.Errorf("amount must be more than 0")
+	}
+	return amount, nil
+}
+
+// recordInvoicePayment is called when staff select "Record a payment" in the payments menu.
+// Staff enter the invoice being paid, the amount (the amount still due by default) and how it was paid.
+func recordInvoicePayment(scanner *bufio.Scanner, db *sql.DB) {
+	fmt.Println("Please enter the invoice number (e.g. INV-000012):")
+	fmt.Print("> ")
+	scanner.Scan()
+
+	inv, err := getInvoice(db, scanner.Text())
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Print(inv.invoiceString())
+
+	var amount int64
+	for {
+		a, err := getAmount(scanner, "Amount paid", inv.total-inv.paid)
+		if err == nil {
+			amount = a
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var method string
+	for {
+		m, err := getPaymentMethod(scanner)
+		if err == nil {
+			method = m
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	fmt.Println("Reference (optional):")
+	fmt.Print("> ")
+	scanner.Scan()
+
+	err = insertPayment(db, payment{
+		userID:    inv.userID,
+		invoiceID: sql.NullInt64{Int64: int64(inv.id), Valid: true},
+		kind:      "Payment",
+		amount:    amount,
+		method:    method,
+		reference: strings.TrimSpace(scanner.Text()),
+	})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Payment recorded.")
+	if due := inv.total - inv.paid - amount; due > 0 {
+		fmt.Println("Still due on this invoice:", formatMoney(due, inv.currency))
+	}
+}
+
+// recordRefund is called when staff select "Record a refund" in the payments menu, for refunds outside the cancellation policy.
+func recordRefund(scanner *bufio.Scanner, db *sql.DB) {
+	var userID int
+	for {
+		_, id, err := getStaffOwner(scanner, db)
+		if err == nil {
+			userID = id
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	viewAccount(db, userID)
+
+	var amount int64
+	for {
+		a, err := getAmount(scanner, "Amount refunded", 0)
+		if err == nil {
+			amount = a
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var method string
+	for {
+		m, err := getPaymentMethod(scanner)
+		if err == nil {
+			method = m
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var reason string
+	for {
+		fmt.Println("Reason for the refund:")
+		fmt.Print("> ")
+		scanner.Scan()
+		reason = strings.TrimSpace(scanner.Text())
+		if reason != "" {
+			break
+		}
+		fmt.Println("Error: please give a reason for the refund")
+	}
+
+	err := insertPayment(db, payment{userID: userID, kind: "Refund", amount: amount, method: method, reference: reason})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Println("Refund recorded.")
+}
+
+// paymentsMenu is a function that displays the staff payments menu.
+func paymentsMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Record a payment")
+	fmt.Println("2. Record a refund")
+	fmt.Println("3. View an owner's account")
+	fmt.Println("4. Outstanding balances")
+	fmt.Println("5. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// managePayments is called when staff select "Payments" in the staff menu.
+func managePayments(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch paymentsMenu(scanner) {
+		case "1":
+			recordInvoicePayment(scanner, db)
+
+		case "2":
+			recordRefund(scanner, db)
+
+		case "3":
+			var userID int
+			for {
+				_, id, err := getStaffOwner(scanner, db)
+				if err == nil {
+					userID = id
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+			viewAccount(db, userID)
+
+		case "4":
+			balances, err := getOutstandingBalances(db)
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Print(outstandingBalancesString(balances))
+
+		case "5":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// runPaymentsCommand handles the "payments" command.
+//   - payments balances: print every owner with an outstanding balance
+//   - payments account <login ID>: print an owner's account
+func runPaymentsCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: payments balances | payments account <login ID>")
+	}
+
+	switch args[0] {
+	case "balances":
+		balances, err := getOutstandingBalances(db)
+		if err != nil {
+			return err
+		}
+		fmt.Print(outstandingBalancesString(balances))
+		return nil
+
+	case "account":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: payments account <login ID>")
+		}
+		userID, err := strconv.Atoi(args[1])
+		if err != nil || userID <= 0 {
+			return fmt.Errorf("login ID must be a positive number")
+		}
+		entries, err := getLedger(db, userID)
+		if err != nil {
+			return err
+		}
+		fmt.Print(ledgerString(entries))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown payments command %q, expected balances or account", args[0])
+	}
+}
+
+// confirmDeposits is called before new bookings are saved, for any appointments that need a deposit.
+// The owner is shown the deposits and the refund policy and asked to agree to them; if they decline, those appointments are dropped and their holds released.
+// Agreeing adds a deposit invoice to the owner's account for each appointment, which they pay at the clinic (see recordDeposit).
+// Owners with too many late cancellations or no-shows need a deposit for every appointment.
+// The appointments that can go ahead are returned.
+func confirmDeposits(scanner *bufio.Scanner, db *sql.DB, userID int, appts []appointment) []appointment {
+	restricted, err := isRestricted(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	if restricted {
+		fmt.Println("Because of recent late cancellations or missed appointments, a deposit is needed for every booking.")
+	}
+
+	var total int64
+	var needDeposit []appointment
+	for _, a := range appts {
+		deposit, err := clinicPrices.depositFor(a, restricted)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if deposit > 0 {
+			fmt.Printf("%s's %s appointment needs a deposit of %s.\n", a.pet.name, a.appointmentType, formatMoney(deposit, clinicPrices.Currency))
+			total += deposit
+			needDeposit = append(needDeposit, a)
+		}
+	}
+	if len(needDeposit) == 0 {
+		return appts
+	}
+
+	fmt.Println(refundPolicyString())
+
+	var agree bool
+	for {
+		a, err := getYesNo(scanner, "Book with a deposit of "+formatMoney(total, clinicPrices.Currency)+"? It is added to your account and is paid at the clinic before the appointment.")
+		if err == nil {
+			agree = a
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if agree {
+		return appts
+	}
+
+	if err := releaseHolds(db, needDeposit); err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	var kept []appointment
+	for _, a := range appts {
+		if deposit, _ := clinicPrices.depositFor(a, restricted); deposit == 0 {
+			kept = append(kept, a)
+		}
+	}
+	fmt.Println("Appointments that need a deposit were not booked.")
+	return kept
+}
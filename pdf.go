@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// pdfLinesPerPage is how many lines of text fit on one A4 page at the size textPDF uses.
+const pdfLinesPerPage = 60
+
+// textPDF lays out lines of plain text as a PDF document in a fixed-width font, starting a new page every pdfLinesPerPage lines.
+// The fixed-width font keeps columns lined up the same way they are on screen.
+// Characters outside plain ASCII are printed as "?", as the standard PDF fonts cannot show them without embedding a font.
+func textPDF(title string, lines []string) []byte {
+	var pages [][]string
+	for len(lines) > pdfLinesPerPage {
+		pages = append(pages, lines[:pdfLinesPerPage])
+		lines = lines[pdfLinesPerPage:]
+	}
+	pages = append(pages, lines)
+
+	// Objects 1 to 4 are the catalog, page tree, font and document info; each page then takes two objects, the page and its content.
+	var objects []string
+	kids := make([]string, len(pages))
+	for i := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", 5+2*i)
+	}
+
+	objects = append(objects,
+		"<< /Type /Catalog /Pages 2 0 R >>",
+		fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages)),
+		"<< /Type /Font /Subtype /Type1 /BaseFont /Courier /Encoding /WinAnsiEncoding >>",
+		fmt.Sprintf("<< /Title (%s) /Producer (vet-booking-cli) >>", pdfString(title)),
+	)
+
+	for i, page := range pages {
+		var content bytes.Buffer
+		content.WriteString("BT\n/F1 10 Tf\n12 TL\n50 792 Td\n")
+		for _, line := range page {
+			fmt.Fprintf(&content, "(%s) '\n", pdfString(line))
+		}
+		content.WriteString("ET\n")
+
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 595 842] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>", 6+2*i),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%sendstream", content.Len(), content.String()),
+		)
+	}
+
+	var out bytes.Buffer
+	out.WriteString("%PDF-1.4\n")
+
+	offsets := make([]int, len(objects))
+	for i, obj := range objects {
+		offsets[i] = out.Len()
+		fmt.Fprintf(&out, "%d 0 obj\n%s\nendobj\n", i+1, obj)
+	}
+
+	xref := out.Len()
+	fmt.Fprintf(&out, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&out, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&out, "trailer\n<< /Size %d /Root 1 0 R /Info 4 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+
+	return out.Bytes()
+}
+
+// pdfString escapes text for use inside a PDF string literal.
+func pdfString(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r == '(' || r == ')' || r == '\\':
+			b.WriteRune('\\')
+			b.WriteRune(r)
+		case r == '\t':
+			b.WriteString("    ")
+		case r < 32 || r > 126:
+			b.WriteRune('?')
+		default:
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// formatNames holds how each export format is shown in menus.
+var formatNames = map[string]string{
+	"text": "Text",
+	"pdf":  "PDF",
+	"json": "JSON",
+	"html": "HTML",
+}
+
+// getExportFormat is a helper function that asks staff which of the formats to export a document in, listed under heading.
+// When skip is set, such as "Back" or "Don't export", it is offered as the last option and an empty string is returned if it is chosen.
+func getExportFormat(scanner *bufio.Scanner, heading string, skip string, formats ...string) (string, error) {
+	fmt.Println(heading)
+	for i, f := range formats {
+		fmt.Printf("%d. %s\n", i+1, formatNames[f])
+	}
+	options := len(formats)
+	if skip != "" {
+		options++
+		fmt.Printf("%d. %s\n", options, skip)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > options {
+		return "", fmt.Errorf("please select one of the options displayed")
+	}
+	if choice > len(formats) {
+		return "", nil
+	}
+	return formats[choice-1], nil
+}
+
+// exportFileName is the file an export is saved to when no other name is given: base with the format's extension, such as "INV-000012.txt".
+func exportFileName(base string, format string) string {
+	ext := format
+	if format == "text" {
+		ext = "txt"
+	}
+	return base + "." + ext
+}
+
+// writeExport saves an exported document to the named file, or prints it when the name is "-".
+// what describes the document in the message saying where it was saved, such as "Invoice".
+func writeExport(b []byte, name string, what string) error {
+	if name == "-" {
+		_, err := os.Stdout.Write(b)
+		return err
+	}
+	if err := os.WriteFile(name, b, 0o644); err != nil {
+		return err
+	}
+	fmt.Println(what, "saved to", name)
+	return nil
+}
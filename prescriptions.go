@@ -0,0 +1,813 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"database/sql"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// defaultFormulary holds the list of drugs shipped with the program.
+// It can be replaced by pointing FORMULARY_FILE at a file in the same format.
+//
+//go:embed formulary.json
+var defaultFormulary []byte
+
+// clinicFormulary is the list of drugs vets can prescribe from.
+// It is loaded once at startup by loadFormulary.
+var clinicFormulary []drug
+
+// labelWidth is the width a prescription label is printed at, so it fits on a label roll.
+const labelWidth = 40
+
+// drugUnits lists the units a drug can be measured out in.
+var drugUnits = []string{"tablet", "capsule", "ml"}
+
+// doseFrequency is a struct that holds one option for how often a dose is given.
+type doseFrequency struct {
+	name   string
+	perDay int
+}
+
+// doseFrequencies lists how often a prescription can be given.
+var doseFrequencies = []doseFrequency{
+	{"Once daily", 1},
+	{"Twice daily", 2},
+	{"Three times daily", 3},
+	{"Four times daily", 4},
+}
+
+// drugDose is a struct that holds the dose range of a drug for one species, in mg per kg of body weight.
+// A maxMgPerDose of 0 means a single dose has no limit beyond the per kg range.
+type drugDose struct {
+	Species      string  `json:"species"`
+	MinMgPerKg   float64 `json:"minMgPerKg"`
+	MaxMgPerKg   float64 `json:"maxMgPerKg"`
+	MaxMgPerDose float64 `json:"maxMgPerDose"`
+}
+
+// drug is a struct that holds one drug in the formulary and the species it may be prescribed for.
+// strengthMg is the amount of drug in one unit, such as one tablet or one ml, and step is the smallest amount of a unit that can be measured out.
+// Every movement of a controlled drug's stock must be logged in the controlled drug register, so controlled drugs are
+// administered and recorded there rather than prescribed to take home.
+type drug struct {
+	Name       string     `json:"name"`
+	Form       string     `json:"form"`
+	Unit       string     `json:"unit"`
+	StrengthMg float64    `json:"strengthMg"`
+	Step       float64    `json:"step"`
+	Controlled bool       `json:"controlled"`
+	Doses      []drugDose `json:"doses"`
+}
+
+// formulary is a struct that holds the drugs listed in formulary.json.
+type formulary struct {
+	Drugs []drug `json:"drugs"`
+}
+
+// loadFormulary reads the list of drugs from the file named in the FORMULARY_FILE environment variable,
+// or uses the built-in list when it is not set.
+func loadFormulary() ([]drug, error) {
+	data := defaultFormulary
+	source := "built-in formulary"
+
+	if path := os.Getenv("FORMULARY_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading FORMULARY_FILE: %w", err)
+		}
+		data = b
+		source = path
+	}
+
+	var f formulary
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	var names []string
+	for _, d := range f.Drugs {
+		if d.Name == "" {
+			return nil, fmt.Errorf("%s: every drug needs a name", source)
+		}
+		if slices.Contains(names, strings.ToLower(d.Name)) {
+			return nil, fmt.Errorf("%s: %s is listed more than once", source, d.Name)
+		}
+		names = append(names, strings.ToLower(d.Name))
+
+		if !slices.Contains(drugUnits, d.Unit) {
+			return nil, fmt.Errorf("%s: %s: unit must be one of %s", source, d.Name, strings.Join(drugUnits, ", "))
+		}
+		if d.StrengthMg <= 0 || d.Step <= 0 {
+			return nil, fmt.Errorf("%s: %s: strengthMg and step must be more than 0", source, d.Name)
+		}
+
+		var species []string
+		for _, dose := range d.Doses {
+			if !slices.Contains(allowedSpecies, dose.Species) {
+				return nil, fmt.Errorf("%s: %s: unknown species %q", source, d.Name, dose.Species)
+			}
+			if slices.Contains(species, dose.Species) {
+				return nil, fmt.Errorf("%s: %s: %s has more than one dose", source, d.Name, dose.Species)
+			}
+			species = append(species, dose.Species)
+
+			if dose.MinMgPerKg <= 0 || dose.MaxMgPerKg < dose.MinMgPerKg || dose.MaxMgPerDose < 0 {
+				return nil, fmt.Errorf("%s: %s: invalid dose range for %s", source, d.Name, dose.Species)
+			}
+		}
+	}
+
+	return f.Drugs, nil
+}
+
+// doseFor finds the drug's dose range for a species.
+// false is returned if the drug is not listed for that species.
+func (d *drug) doseFor(species string) (drugDose, bool) {
+	i := slices.IndexFunc(d.Doses, func(dose drugDose) bool { return strings.EqualFold(dose.Species, species) })
+	if i < 0 {
+		return drugDose{}, false
+	}
+	return d.Doses[i], true
+}
+
+// strengthString prints how much drug one unit holds, such as "50 mg" for a tablet or "1.5 mg/ml" for a liquid.
+func strengthString(strengthMg float64, unit string) string {
+	if unit == "ml" {
+		return formatAmount(strengthMg) + " mg/ml"
+	}
+	return formatAmount(strengthMg) + " mg"
+}
+
+// formatAmount prints an amount of a drug to at most two decimal places, without trailing zeros.
+func formatAmount(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// unitsString prints an amount of a drug in its unit, such as "1.5 tablets" or "0.8 ml".
+func unitsString(amount float64, unit string) string {
+	if unit != "ml" && formatAmount(amount) != "1" {
+		unit += "s"
+	}
+	return formatAmount(amount) + " " + unit
+}
+
+// rangeMg works out the smallest and largest single dose in mg for a pet of the given weight.
+func (dose drugDose) rangeMg(weightKg float64) (float64, float64) {
+	minMg, maxMg := dose.MinMgPerKg*weightKg, dose.MaxMgPerKg*weightKg
+	if dose.MaxMgPerDose > 0 && maxMg > dose.MaxMgPerDose {
+		maxMg = dose.MaxMgPerDose
+	}
+	return minMg, maxMg
+}
+
+// suggestedAmount finds the smallest amount of the drug that can be measured out and gives at least minMg.
+// false is returned if that amount would be over maxMg, meaning this strength cannot be measured out accurately for the pet.
+func (d *drug) suggestedAmount(minMg, maxMg float64) (float64, bool) {
+	// Rounding keeps floating point error from pushing an exact number of steps up to the next one.
+	steps := math.Ceil(math.Round(minMg/d.StrengthMg/d.Step*1e6) / 1e6)
+	if steps < 1 {
+		steps = 1
+	}
+	amount := steps * d.Step
+	return amount, amount*d.StrengthMg <= maxMg+1e-9
+}
+
+// doseRangeString prints the dose calculator's working for a pet: the dose range in mg, and in the drug's unit.
+func doseRangeString(d drug, dose drugDose, weightKg float64) string {
+	minMg, maxMg := dose.rangeMg(weightKg)
+
+	s := fmt.Sprintf("%s %s %s for a %s kg %s:\n", d.Name, strengthString(d.StrengthMg, d.Unit), d.Form, formatAmount(weightKg), dose.Species)
+	s += fmt.Sprintf("  %s to %s mg/kg", formatAmount(dose.MinMgPerKg), formatAmount(dose.MaxMgPerKg))
+	if dose.MaxMgPerDose > 0 {
+		s += fmt.Sprintf(", at most %s mg a dose", formatAmount(dose.MaxMgPerDose))
+	}
+	s += "\n"
+	s += fmt.Sprintf("  Dose: %s to %s mg (%s to %s)\n", formatAmount(minMg), formatAmount(maxMg),
+		unitsString(minMg/d.StrengthMg, d.Unit), unitsString(maxMg/d.StrengthMg, d.Unit))
+
+	if amount, ok := d.suggestedAmount(minMg, maxMg); ok {
+		s += fmt.Sprintf("  Suggested: %s (%s mg)\n", unitsString(amount, d.Unit), formatAmount(amount*d.StrengthMg))
+	} else {
+		s += fmt.Sprintf("  This strength cannot be measured out within the range in steps of %s.\n", unitsString(d.Step, d.Unit))
+	}
+	return s
+}
+
+// checkDose checks an amount of a drug against the species limits for a pet's weight.
+// An error is returned if the dose is above the limit, which cannot be prescribed; true is returned if it is below the usual range.
+func checkDose(d drug, dose drugDose, weightKg, amount float64) (bool, error) {
+	if amount <= 0 {
+		return false, fmt.Errorf("dose must be more than 0")
+	}
+	if r := amount / d.Step; math.Abs(r-math.Round(r)) > 1e-6 {
+		return false, fmt.Errorf("dose must be measured in steps of %s", unitsString(d.Step, d.Unit))
+	}
+
+	mg := amount * d.StrengthMg
+	minMg, maxMg := dose.rangeMg(weightKg)
+	if mg > maxMg+1e-9 {
+		return false, fmt.Errorf("%s mg is above the limit of %s mg for a %s kg %s", formatAmount(mg), formatAmount(maxMg), formatAmount(weightKg), dose.Species)
+	}
+	return mg < minMg-1e-9, nil
+}
+
+// prescription is a struct that holds one drug prescribed at an appointment.
+// The drug's form, unit and strength are copied from the formulary, so the record stays correct if the formulary changes later.
+// weightKg is the weight the dose was worked out from.
+type prescription struct {
+	id            int
+	appointmentID int
+	drug          string
+	form          string
+	unit          string
+	strengthMg    float64
+	doseAmount    float64
+	doseMg        float64
+	weightKg      float64
+	frequency     string
+	durationDays  int
+	quantity      float64
+	instructions  string
+	prescribedBy  string
+	prescribedAt  time.Time
+}
+
+// drugString prints the drug's name, strength and form, such as "Carprofen 50 mg tablets".
+func (rx *prescription) drugString() string {
+	return fmt.Sprintf("%s %s %s", rx.drug, strengthString(rx.strengthMg, rx.unit), rx.form)
+}
+
+// doseString prints how much of the drug to give and how often, such as "Give 1.5 tablets (75 mg) twice daily for 7 days".
+func (rx *prescription) doseString() string {
+	days := "days"
+	if rx.durationDays == 1 {
+		days = "day"
+	}
+	return fmt.Sprintf("Give %s (%s mg) %s for %d %s",
+		unitsString(rx.doseAmount, rx.unit),
+		formatAmount(rx.doseMg),
+		strings.ToLower(rx.frequency),
+		rx.durationDays,
+		days,
+	)
+}
+
+// dispenseQuantity works out how much of the drug covers the whole course.
+// Tablets and capsules are rounded up to whole units and liquids to whole ml.
+func dispenseQuantity(amount float64, perDay, days int) float64 {
+	return math.Ceil(math.Round(amount*float64(perDay*days)*1e6) / 1e6)
+}
+
+// prescriptionColumns is the column list shared by every prescription lookup.
+const prescriptionColumns = `r.id, r.appointment_id, r.drug, r.form, r.unit, r.strength_mg, r.dose_amount, r.dose_mg, r.weight_kg,
+	r.frequency, r.duration_days, r.quantity, r.instructions, r.prescribed_by, r.prescribed_at`
+
+// getPrescriptions fetches every prescription issued at the given appointments, oldest first.
+func getPrescriptions(db queryer, appointmentIDs []int) ([]prescription, error) {
+	if len(appointmentIDs) == 0 {
+		return nil, nil
+	}
+
+	rows, err := db.Query(
+		`SELECT `+prescriptionColumns+`
+		 FROM prescriptions r
+		 WHERE r.appointment_id = ANY($1)
+		 ORDER BY r.prescribed_at, r.id`,
+		pq.Array(appointmentIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var list []prescription
+	for rows.Next() {
+		var rx prescription
+		err := rows.Scan(&rx.id, &rx.appointmentID, &rx.drug, &rx.form, &rx.unit, &rx.strengthMg, &rx.doseAmount, &rx.doseMg, &rx.weightKg,
+			&rx.frequency, &rx.durationDays, &rx.quantity, &rx.instructions, &rx.prescribedBy, &rx.prescribedAt)
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, rx)
+	}
+	return list, rows.Err()
+}
+
+// getPrescription fetches one prescription by its number, along with the appointment it was issued at.
+func getPrescription(db *sql.DB, id int) (prescription, appointment, error) {
+	var rx prescription
+	err := db.QueryRow(
+		`SELECT `+prescriptionColumns+`
+		 FROM prescriptions r
+		 WHERE r.id = $1`,
+		id,
+	).Scan(&rx.id, &rx.appointmentID, &rx.drug, &rx.form, &rx.unit, &rx.strengthMg, &rx.doseAmount, &rx.doseMg, &rx.weightKg,
+		&rx.frequency, &rx.durationDays, &rx.quantity, &rx.instructions, &rx.prescribedBy, &rx.prescribedAt)
+	if err == sql.ErrNoRows {
+		return prescription{}, appointment{}, fmt.Errorf("no prescription found with that number")
+	}
+	if err != nil {
+		return prescription{}, appointment{}, err
+	}
+
+	appts, err := queryAppointments(db, `WHERE a.id = $1`, rx.appointmentID)
+	if err != nil {
+		return prescription{}, appointment{}, err
+	}
+	if len(appts) == 0 {
+		return prescription{}, appointment{}, fmt.Errorf("the appointment for this prescription could not be found")
+	}
+	return rx, appts[0], nil
+}
+
+// insertPrescription saves a new prescription, filling in its number and when it was issued.
+func insertPrescription(db *sql.DB, rx *prescription) error {
+	return db.QueryRow(
+		`INSERT INTO prescriptions (appointment_id, drug, form, unit, strength_mg, dose_amount, dose_mg, weight_kg,
+			frequency, duration_days, quantity, instructions, prescribed_by)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13)
+		 RETURNING id, prescribed_at`,
+		rx.appointmentID,
+		rx.drug,
+		rx.form,
+		rx.unit,
+		rx.strengthMg,
+		rx.doseAmount,
+		rx.doseMg,
+		rx.weightKg,
+		rx.frequency,
+		rx.durationDays,
+		rx.quantity,
+		rx.instructions,
+		rx.prescribedBy,
+	).Scan(&rx.id, &rx.prescribedAt)
+}
+
+// labelString prints a prescription label to go on the medicine dispensed to the owner.
+func labelString(rx prescription, a appointment) string {
+	line := strings.Repeat("-", labelWidth) + "\n"
+
+	var s string
+	s = line
+	s += fmt.Sprintf("Prescription no. %d\n", rx.id)
+	s += fmt.Sprintf("%s (%s), %s kg\n", a.pet.name, a.pet.species, formatAmount(rx.weightKg))
+	s += fmt.Sprintf("Owner: %s %s\n", a.owner.firstName, a.owner.lastName)
+	s += line
+	for _, l := range wrapText(rx.drugString(), labelWidth) {
+		s += l + "\n"
+	}
+	for _, l := range wrapText(rx.doseString(), labelWidth) {
+		s += l + "\n"
+	}
+	s += fmt.Sprintf("Quantity: %s\n", unitsString(rx.quantity, rx.unit))
+	for _, l := range wrapText(rx.instructions, labelWidth) {
+		s += l + "\n"
+	}
+	s += line
+	s += fmt.Sprintf("%s, %s\n", rx.prescribedBy, rx.prescribedAt.In(clinicLocation).Format("02 Jan 2006"))
+	s += "For animal treatment only.\n"
+	s += "Keep out of the reach of children.\n"
+	s += line
+
+	return s
+}
+
+// exportLabel renders a prescription label as plain text or a PDF.
+func exportLabel(rx prescription, a appointment, format string) ([]byte, error) {
+	switch format {
+	case "text":
+		return []byte(labelString(rx, a)), nil
+
+	case "pdf":
+		text := strings.Trim(labelString(rx, a), "\n")
+		return textPDF(fmt.Sprintf("Prescription %d", rx.id), strings.Split(text, "\n")), nil
+
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected text or pdf", format)
+	}
+}
+
+// labelFileName is the file a prescription label is saved to when no other name is given, such as "prescription-12.pdf".
+func labelFileName(rx prescription, format string) string {
+	return exportFileName(fmt.Sprintf("prescription-%d", rx.id), format)
+}
+
+// prescriptionString prints a prescription on one line for lists, such as in a pet's history.
+func prescriptionString(rx prescription) string {
+	return fmt.Sprintf("%s: %s", rx.drugString(), rx.doseString())
+}
+
+// prescribableFor reports whether any drug in the formulary can be prescribed for a species.
+// Controlled drugs cannot be prescribed to take home, so they do not count.
+func prescribableFor(species string) bool {
+	return slices.ContainsFunc(clinicFormulary, func(d drug) bool {
+		_, ok := d.doseFor(species)
+		return ok && !d.Controlled
+	})
+}
+
+// getDrug is a helper function that prompts the vet to choose a drug from the formulary.
+// Drugs that are not listed for the pet's species, and controlled drugs, are shown but cannot be chosen.
+// false is returned if the vet chooses to go back.
+func getDrug(scanner *bufio.Scanner, species string) (drug, drugDose, bool, error) {
+	fmt.Println("Which drug is being prescribed?")
+	for i, d := range clinicFormulary {
+		fmt.Printf("%d. %s %s %s", i+1, d.Name, strengthString(d.StrengthMg, d.Unit), d.Form)
+		if d.Controlled {
+			fmt.Print(" (controlled)")
+		} else if _, ok := d.doseFor(species); !ok {
+			fmt.Printf(" (not for %ss)", strings.ToLower(species))
+		}
+		fmt.Println()
+	}
+	fmt.Printf("%d. Back\n", len(clinicFormulary)+1)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(clinicFormulary)+1 {
+		return drug{}, drugDose{}, false, fmt.Errorf("please select one of the drugs displayed")
+	}
+	if choice == len(clinicFormulary)+1 {
+		return drug{}, drugDose{}, false, nil
+	}
+
+	d := clinicFormulary[choice-1]
+	if d.Controlled {
+		return drug{}, drugDose{}, false, fmt.Errorf("%s is a controlled drug; record it in the register from Controlled drugs in the staff menu instead", d.Name)
+	}
+	dose, ok := d.doseFor(species)
+	if !ok {
+		return drug{}, drugDose{}, false, fmt.Errorf("%s is not listed for use in %ss", d.Name, strings.ToLower(species))
+	}
+	return d, dose, true, nil
+}
+
+// getDoseAmount is a helper function that prompts the vet for the amount given in each dose, in the drug's unit.
+// An empty input picks the suggested amount, if there is one.
+func getDoseAmount(scanner *bufio.Scanner, d drug, suggested float64) (float64, error) {
+	if suggested > 0 {
+		fmt.Printf("How many %ss a dose? Leave blank for %s:\n", d.Unit, formatAmount(suggested))
+	} else {
+		fmt.Printf("How many %ss a dose?\n", d.Unit)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if input == "" && suggested > 0 {
+		return suggested, nil
+	}
+
+	amount, err := strconv.ParseFloat(input, 64)
+	if err != nil || math.IsNaN(amount) || math.IsInf(amount, 0) {
+		return 0, fmt.Errorf("dose must be a number, such as 1.5")
+	}
+	return amount, nil
+}
+
+// getDoseFrequency is a helper function that prompts the vet for how often the dose is given.
+func getDoseFrequency(scanner *bufio.Scanner) (doseFrequency, error) {
+	fmt.Println("How often?")
+	for i, f := range doseFrequencies {
+		fmt.Printf("%d. %s\n", i+1, f.name)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(doseFrequencies) {
+		return doseFrequency{}, fmt.Errorf("please select one of the options displayed")
+	}
+	return doseFrequencies[choice-1], nil
+}
+
+// getDuration is a helper function that prompts the vet for how many days the course lasts.
+func getDuration(scanner *bufio.Scanner) (int, error) {
+	fmt.Println("For how many days?")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	days, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || days < 1 || days > 365 {
+		return 0, fmt.Errorf("days must be a number between 1 and 365")
+	}
+	return days, nil
+}
+
+// getInstructions is a helper function that prompts for any extra instructions to print on the label, such as "Give with food".
+// An empty input means there are none.
+func getInstructions(scanner *bufio.Scanner) (string, error) {
+	fmt.Println("Any extra instructions for the label (e.g. Give with food), or leave blank:")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.Join(strings.Fields(scanner.Text()), " ")
+
+	if len(input) > 200 {
+		return "", fmt.Errorf("character limit is 200 characters")
+	}
+	return input, nil
+}
+
+// printLabel shows a prescription label and saves it to a file in the format staff choose.
+func printLabel(scanner *bufio.Scanner, rx prescription, a appointment) {
+	fmt.Print(labelString(rx, a))
+
+	var format string
+	for {
+		f, err := getExportFormat(scanner, "Print a label:", "Don't print", "text", "pdf")
+		if err == nil {
+			format = f
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	if format == "" {
+		return
+	}
+
+	b, err := exportLabel(rx, a, format)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if err := writeExport(b, labelFileName(rx, format), "Label"); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// writePrescription is called when staff select "Write a prescription" in the prescriptions menu.
+// The dose is worked out from the weight recorded for the appointment and the drug's range for the pet's species.
+// Doses above the range cannot be prescribed, and doses below it must be confirmed.
+func writePrescription(scanner *bufio.Scanner, db *sql.DB) {
+	if len(clinicFormulary) == 0 {
+		fmt.Println("There are no drugs in the formulary.")
+		return
+	}
+
+	a, ok := getNotesAppointment(scanner, db)
+	if !ok {
+		return
+	}
+	if !prescribableFor(a.pet.species) {
+		fmt.Printf("None of the drugs in the formulary can be prescribed for %ss.\n", strings.ToLower(a.pet.species))
+		return
+	}
+
+	var d drug
+	var dose drugDose
+	for {
+		dr, ds, ok, err := getDrug(scanner, a.pet.species)
+		if err == nil {
+			if !ok {
+				return
+			}
+			d, dose = dr, ds
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	fmt.Print(doseRangeString(d, dose, a.pet.weightKg))
+	minMg, maxMg := dose.rangeMg(a.pet.weightKg)
+	suggested, ok := d.suggestedAmount(minMg, maxMg)
+	if !ok {
+		suggested = 0
+	}
+
+	var amount float64
+	for {
+		am, err := getDoseAmount(scanner, d, suggested)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+
+		low, err := checkDose(d, dose, a.pet.weightKg, am)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if low {
+			confirm, err := getYesNo(scanner, fmt.Sprintf("%s mg is below the usual range for this pet. Prescribe it anyway?", formatAmount(am*d.StrengthMg)))
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			if !confirm {
+				continue
+			}
+		}
+		amount = am
+		break
+	}
+
+	var freq doseFrequency
+	for {
+		f, err := getDoseFrequency(scanner)
+		if err == nil {
+			freq = f
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var days int
+	for {
+		dy, err := getDuration(scanner)
+		if err == nil {
+			days = dy
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var instructions string
+	for {
+		in, err := getInstructions(scanner)
+		if err == nil {
+			instructions = in
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var vet string
+	for {
+		v, err := getNoteAuthor(scanner, a.vet)
+		if err == nil {
+			vet = v
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	rx := prescription{
+		appointmentID: a.id,
+		drug:          d.Name,
+		form:          d.Form,
+		unit:          d.Unit,
+		strengthMg:    d.StrengthMg,
+		doseAmount:    amount,
+		doseMg:        amount * d.StrengthMg,
+		weightKg:      a.pet.weightKg,
+		frequency:     freq.name,
+		durationDays:  days,
+		quantity:      dispenseQuantity(amount, freq.perDay, days),
+		instructions:  instructions,
+		prescribedBy:  vet,
+	}
+
+	if err := insertPrescription(db, &rx); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	fmt.Printf("Prescription no. %d saved.\n", rx.id)
+
+	printLabel(scanner, rx, a)
+}
+
+// reprintLabel is called when staff select "Print a label" in the prescriptions menu.
+// Staff pick the appointment and then one of the prescriptions issued at it.
+func reprintLabel(scanner *bufio.Scanner, db *sql.DB) {
+	a, ok := getNotesAppointment(scanner, db)
+	if !ok {
+		return
+	}
+
+	list, err := getPrescriptions(db, []int{a.id})
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(list) == 0 {
+		fmt.Println("Nothing has been prescribed at this appointment.")
+		return
+	}
+
+	var rx prescription
+	for {
+		fmt.Println("Which prescription?")
+		for i, r := range list {
+			fmt.Printf("%d. %s\n", i+1, prescriptionString(r))
+		}
+		fmt.Print("> ")
+
+		scanner.Scan()
+		choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+		if err == nil && choice >= 1 && choice <= len(list) {
+			rx = list[choice-1]
+			break
+		}
+		fmt.Println("Error: please select one of the prescriptions displayed")
+	}
+
+	printLabel(scanner, rx, a)
+}
+
+// prescriptionsMenu is a function that displays the prescriptions menu and returns the option selected.
+func prescriptionsMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Write a prescription")
+	fmt.Println("2. Print a label")
+	fmt.Println("3. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// managePrescriptions is called when staff select "Prescriptions" in the staff menu.
+func managePrescriptions(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch prescriptionsMenu(scanner) {
+		case "1":
+			writePrescription(scanner, db)
+
+		case "2":
+			reprintLabel(scanner, db)
+
+		case "3":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// runPrescriptionsCommand handles the "prescriptions" command.
+//   - prescriptions label <number> text|pdf [file]: save a prescription label to a file, or print it when the file is "-"
+//   - prescriptions dose <drug> <species> <weight in kg>: show the dose calculator's working for a pet
+func runPrescriptionsCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: prescriptions label <number> text|pdf [file] | prescriptions dose <drug> <species> <weight in kg>")
+	}
+
+	switch args[0] {
+	case "label":
+		if len(args) < 3 {
+			return fmt.Errorf("usage: prescriptions label <number> text|pdf [file]")
+		}
+		id, err := strconv.Atoi(args[1])
+		if err != nil || id <= 0 {
+			return fmt.Errorf("prescription number must be a positive number")
+		}
+
+		rx, a, err := getPrescription(db, id)
+		if err != nil {
+			return err
+		}
+		b, err := exportLabel(rx, a, args[2])
+		if err != nil {
+			return err
+		}
+
+		name := labelFileName(rx, args[2])
+		if len(args) > 3 {
+			name = args[3]
+		}
+		return writeExport(b, name, "Label")
+
+	case "dose":
+		if len(args) != 4 {
+			return fmt.Errorf("usage: prescriptions dose <drug> <species> <weight in kg>")
+		}
+		i := slices.IndexFunc(clinicFormulary, func(d drug) bool { return strings.EqualFold(d.Name, args[1]) })
+		if i < 0 {
+			return fmt.Errorf("no drug called %q in the formulary", args[1])
+		}
+		d := clinicFormulary[i]
+
+		dose, ok := d.doseFor(args[2])
+		if !ok {
+			return fmt.Errorf("%s is not listed for use in %q", d.Name, args[2])
+		}
+
+		weight, err := strconv.ParseFloat(args[3], 64)
+		if err != nil || weight <= 0 {
+			return fmt.Errorf("weight must be a number above 0")
+		}
+
+		fmt.Print(doseRangeString(d, dose, weight))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown prescriptions command %q, expected label or dose", args[0])
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"math"
+	"testing"
+)
+
+var (
+	testCarprofen  = drug{Name: "Carprofen", Unit: "tablet", StrengthMg: 50, Step: 0.5}
+	testMeloxicam  = drug{Name: "Meloxicam", Unit: "ml", StrengthMg: 1.5, Step: 0.1}
+	testGabapentin = drug{Name: "Gabapentin", Unit: "capsule", StrengthMg: 100, Step: 1}
+
+	carprofenDog  = drugDose{Species: "Dog", MinMgPerKg: 2, MaxMgPerKg: 4}
+	meloxicamDog  = drugDose{Species: "Dog", MinMgPerKg: 0.1, MaxMgPerKg: 0.2}
+	meloxicamCat  = drugDose{Species: "Cat", MinMgPerKg: 0.05, MaxMgPerKg: 0.1}
+	gabapentinDog = drugDose{Species: "Dog", MinMgPerKg: 10, MaxMgPerKg: 20, MaxMgPerDose: 600}
+)
+
+func TestRangeMg(t *testing.T) {
+	tests := []struct {
+		name     string
+		dose     drugDose
+		weightKg float64
+		minMg    float64
+		maxMg    float64
+	}{
+		{"per kg", carprofenDog, 12.5, 25, 50},
+		{"small pet", meloxicamCat, 4, 0.2, 0.4},
+		{"under the per dose cap", gabapentinDog, 20, 200, 400},
+		{"capped per dose", gabapentinDog, 40, 400, 600},
+	}
+
+	for _, tt := range tests {
+		minMg, maxMg := tt.dose.rangeMg(tt.weightKg)
+		if math.Abs(minMg-tt.minMg) > 1e-9 || math.Abs(maxMg-tt.maxMg) > 1e-9 {
+			t.Errorf("%s: rangeMg(%v) = %v, %v, want %v, %v", tt.name, tt.weightKg, minMg, maxMg, tt.minMg, tt.maxMg)
+		}
+	}
+}
+
+func TestCheckDose(t *testing.T) {
+	tests := []struct {
+		name     string
+		drug     drug
+		dose     drugDose
+		weightKg float64
+		amount   float64
+		low      bool
+		wantErr  bool
+	}{
+		{"bottom of range", testCarprofen, carprofenDog, 12.5, 0.5, false, false},
+		{"top of range", testCarprofen, carprofenDog, 12.5, 1, false, false},
+		{"above range", testCarprofen, carprofenDog, 12.5, 1.5, false, true},
+		{"below range", testCarprofen, carprofenDog, 30, 1, true, false},
+		{"not a whole step", testCarprofen, carprofenDog, 12.5, 0.25, false, true},
+		{"zero", testCarprofen, carprofenDog, 12.5, 0, false, true},
+		{"negative", testCarprofen, carprofenDog, 12.5, -0.5, false, true},
+		{"liquid step with float error", testMeloxicam, meloxicamDog, 15, 0.7, true, false},
+		{"liquid exactly at the limit", testMeloxicam, meloxicamDog, 15, 2, false, false},
+		{"liquid just over the limit", testMeloxicam, meloxicamDog, 15, 2.1, false, true},
+		{"liquid off step", testMeloxicam, meloxicamDog, 15, 1.05, false, true},
+		{"at the per dose cap", testGabapentin, gabapentinDog, 40, 6, false, false},
+		{"over the per dose cap", testGabapentin, gabapentinDog, 40, 7, false, true},
+	}
+
+	for _, tt := range tests {
+		low, err := checkDose(tt.drug, tt.dose, tt.weightKg, tt.amount)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: checkDose(%v) error = %v, want error %v", tt.name, tt.amount, err, tt.wantErr)
+			continue
+		}
+		if err == nil && low != tt.low {
+			t.Errorf("%s: checkDose(%v) low = %v, want %v", tt.name, tt.amount, low, tt.low)
+		}
+	}
+}
+
+func TestSuggestedAmount(t *testing.T) {
+	tests := []struct {
+		name     string
+		drug     drug
+		dose     drugDose
+		weightKg float64
+		amount   float64
+		ok       bool
+	}{
+		{"rounded up to a whole step", testCarprofen, carprofenDog, 10, 0.5, true},
+		{"exact number of steps is not bumped up", testMeloxicam, meloxicamDog, 15, 1, true},
+		{"small liquid dose", testMeloxicam, meloxicamCat, 4, 0.2, true},
+		{"smallest step is over the range", testCarprofen, carprofenDog, 5, 0.5, false},
+		{"capped per dose", testGabapentin, gabapentinDog, 40, 4, true},
+	}
+
+	for _, tt := range tests {
+		minMg, maxMg := tt.dose.rangeMg(tt.weightKg)
+		amount, ok := tt.drug.suggestedAmount(minMg, maxMg)
+		if math.Abs(amount-tt.amount) > 1e-9 || ok != tt.ok {
+			t.Errorf("%s: suggestedAmount(%v, %v) = %v, %v, want %v, %v", tt.name, minMg, maxMg, amount, ok, tt.amount, tt.ok)
+		}
+		if ok {
+			if _, err := checkDose(tt.drug, tt.dose, tt.weightKg, amount); err != nil {
+				t.Errorf("%s: suggested amount %v fails checkDose: %v", tt.name, amount, err)
+			}
+		}
+	}
+}
+
+func TestDispenseQuantity(t *testing.T) {
+	tests := []struct {
+		amount float64
+		perDay int
+		days   int
+		want   float64
+	}{
+		{1.5, 2, 7, 21},
+		{0.5, 1, 5, 3},
+		{0.1, 3, 7, 3},
+		{0.7, 2, 5, 7},
+		{1, 1, 1, 1},
+	}
+
+	for _, tt := range tests {
+		if got := dispenseQuantity(tt.amount, tt.perDay, tt.days); got != tt.want {
+			t.Errorf("dispenseQuantity(%v, %d, %d) = %v, want %v", tt.amount, tt.perDay, tt.days, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,277 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// defaultPriceList holds the price list shipped with the program.
+// It can be replaced by pointing PRICE_LIST_FILE at a file in the same format.
+//
+//go:embed price_list.json
+var defaultPriceList []byte
+
+// clinicPrices is the price list bookings are quoted and invoiced from.
+// It is loaded once at startup by loadPriceList.
+var clinicPrices priceList
+
+// priceEntry is a struct that holds the price of one appointment type, optionally only for one species and weight band.
+// The weight band includes minWeightKg and stops short of maxWeightKg; a maximum of 0 means there is no upper limit.
+type priceEntry struct {
+	Type        string  `json:"type"`
+	Species     string  `json:"species"`
+	MinWeightKg float64 `json:"minWeightKg"`
+	MaxWeightKg float64 `json:"maxWeightKg"`
+	Price       string  `json:"price"`
+
+	// The price in minor units (pence, cents), decoded when the list is loaded.
+	amount int64
+}
+
+// refundTier is a struct that holds one step of the cancellation refund policy:
+// cancelling at least noticeHours before the appointment refunds percent of what was paid for it.
+type refundTier struct {
+	NoticeHours int `json:"noticeHours"`
+	Percent     int `json:"percent"`
+}
+
+// priceList is a struct that holds the clinic's prices, the currency they are in and the tax added to them.
+// Prices are listed before tax.
+// depositPercent holds the share of the price, including tax, that must be paid up front to book each appointment type that needs a deposit.
+type priceList struct {
+	Currency           string             `json:"currency"`
+	TaxPercent         float64            `json:"taxPercent"`
+	DepositPercent     map[string]int     `json:"depositPercent"`
+	RefundPolicy       []refundTier       `json:"refundPolicy"`
+	CancellationPolicy cancellationPolicy `json:"cancellationPolicy"`
+	Prices             []priceEntry       `json:"prices"`
+}
+
+// loadPriceList reads the price list from the file named in the PRICE_LIST_FILE environment variable,
+// or uses the built-in list when it is not set.
+// Every appointment type must have a price that applies to any pet, so every booking can be priced.
+func loadPriceList() (priceList, error) {
+	data := defaultPriceList
+	source := "built-in price list"
+
+	if path := os.Getenv("PRICE_LIST_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return priceList{}, fmt.Errorf("reading PRICE_LIST_FILE: %w", err)
+		}
+		data = b
+		source = path
+	}
+
+	var p priceList
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		return priceList{}, fmt.Errorf("%s: %w", source, err)
+	}
+
+	if len(p.Currency) != 3 {
+		return priceList{}, fmt.Errorf("%s: currency must be a 3 letter code such as GBP", source)
+	}
+	if p.TaxPercent < 0 || p.TaxPercent > 100 {
+		return priceList{}, fmt.Errorf("%s: taxPercent must be between 0 and 100", source)
+	}
+
+	for t, percent := range p.DepositPercent {
+		if !slices.Contains(allowedAppointmentTypes, t) {
+			return priceList{}, fmt.Errorf("%s: deposit for unknown appointment type %q", source, t)
+		}
+		if percent < 0 || percent > 100 {
+			return priceList{}, fmt.Errorf("%s: deposit for %s must be between 0 and 100 percent", source, t)
+		}
+	}
+
+	for i, tier := range p.RefundPolicy {
+		if tier.NoticeHours < 0 || tier.Percent < 0 || tier.Percent > 100 {
+			return priceList{}, fmt.Errorf("%s: refund policy step %d must have noticeHours of 0 or more and a percent between 0 and 100", source, i+1)
+		}
+	}
+	// The policy is kept longest notice first, so refundPercent can stop at the first step that applies.
+	slices.SortFunc(p.RefundPolicy, func(a, b refundTier) int { return b.NoticeHours - a.NoticeHours })
+
+	if err := p.CancellationPolicy.validate(); err != nil {
+		return priceList{}, fmt.Errorf("%s: cancellation policy: %w", source, err)
+	}
+
+	// Cancelling with freeNoticeHours notice is free, so the refund policy must give back everything that was paid from that point on.
+	takesDeposits := len(p.DepositPercent) > 0 || (p.CancellationPolicy.StrikeThreshold > 0 && p.CancellationPolicy.StrikeDepositPercent > 0)
+	free := time.Duration(p.CancellationPolicy.FreeNoticeHours) * time.Hour
+	if takesDeposits && p.refundPercent(free) != 100 {
+		return priceList{}, fmt.Errorf("%s: refundPolicy must refund 100%% for cancellations at least freeNoticeHours (%d) before, as they are free",
+			source, p.CancellationPolicy.FreeNoticeHours)
+	}
+
+	for i := range p.Prices {
+		e := &p.Prices[i]
+		if !slices.Contains(allowedAppointmentTypes, e.Type) {
+			return priceList{}, fmt.Errorf("%s: price %d: unknown appointment type %q", source, i+1, e.Type)
+		}
+		if e.Species != "" && !slices.Contains(allowedSpecies, e.Species) {
+			return priceList{}, fmt.Errorf("%s: price %d: unknown species %q", source, i+1, e.Species)
+		}
+		if e.MinWeightKg < 0 || (e.MaxWeightKg != 0 && e.MaxWeightKg <= e.MinWeightKg) {
+			return priceList{}, fmt.Errorf("%s: price %d: invalid weight band", source, i+1)
+		}
+
+		amount, err := parseMoney(e.Price)
+		if err != nil || amount < 0 {
+			return priceList{}, fmt.Errorf("%s: price %d: invalid price %q", source, i+1, e.Price)
+		}
+		e.amount = amount
+	}
+
+	for _, t := range allowedAppointmentTypes {
+		covered := slices.ContainsFunc(p.Prices, func(e priceEntry) bool {
+			return e.Type == t && e.Species == "" && e.MinWeightKg == 0 && e.MaxWeightKg == 0
+		})
+		if !covered {
+			return priceList{}, fmt.Errorf("%s: %s needs a price that applies to every pet", source, t)
+		}
+	}
+
+	return p, nil
+}
+
+// priceFor finds the price of an appointment, in minor units.
+// The first entry in the list that matches the appointment type, species and weight is used, so more specific prices are listed first.
+func (p *priceList) priceFor(a appointment) (int64, error) {
+	for _, e := range p.Prices {
+		if e.Type != a.appointmentType {
+			continue
+		}
+		if e.Species != "" && e.Species != a.pet.species {
+			continue
+		}
+		if a.pet.weightKg < e.MinWeightKg || (e.MaxWeightKg != 0 && a.pet.weightKg >= e.MaxWeightKg) {
+			continue
+		}
+		return e.amount, nil
+	}
+	return 0, fmt.Errorf("no price for a %s appointment", a.appointmentType)
+}
+
+// depositFor works out the deposit needed to book an appointment, in minor units, from its price including tax.
+// 0 is returned for appointment types that do not need a deposit.
+// Owners whose bookings are restricted under the cancellation policy pay at least the policy's deposit on every appointment.
+func (p *priceList) depositFor(a appointment, restricted bool) (int64, error) {
+	percent := p.DepositPercent[a.appointmentType]
+	if restricted {
+		percent = max(percent, p.CancellationPolicy.StrikeDepositPercent)
+	}
+	if percent == 0 {
+		return 0, nil
+	}
+
+	price := a.price
+	if price == 0 {
+		var err error
+		price, err = p.priceFor(a)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	gross := price + taxOn(price, p.TaxPercent)
+	return int64(math.Round(float64(gross) * float64(percent) / 100)), nil
+}
+
+// refundPercent looks up how much of what was paid for an appointment is refunded when it is cancelled with the given notice.
+// Nothing is refunded when no step of the policy applies.
+func (p *priceList) refundPercent(notice time.Duration) int {
+	for _, tier := range p.RefundPolicy {
+		if notice >= time.Duration(tier.NoticeHours)*time.Hour {
+			return tier.Percent
+		}
+	}
+	return 0
+}
+
+// taxOn works out the tax on an amount in minor units, rounded to the nearest minor unit.
+func taxOn(amount int64, percent float64) int64 {
+	return int64(math.Round(float64(amount) * percent / 100))
+}
+
+// parseMoney reads an amount such as "35", "35.5" or "35.50" into minor units.
+// A single leading "-" makes the amount negative; no other signs are allowed.
+func parseMoney(s string) (int64, error) {
+	s = strings.TrimSpace(s)
+
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac, hasFrac := strings.Cut(s, ".")
+	if whole == "" || (hasFrac && (len(frac) == 0 || len(frac) > 2)) {
+		return 0, fmt.Errorf("amounts must look like 35.00")
+	}
+	for _, c := range whole + frac {
+		if c < '0' || c > '9' {
+			return 0, fmt.Errorf("amounts must look like 35.00")
+		}
+	}
+	for len(frac) < 2 {
+		frac += "0"
+	}
+
+	major, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil || major > math.MaxInt64/100-1 {
+		return 0, fmt.Errorf("%s is too large an amount", s)
+	}
+	minor, _ := strconv.ParseInt(frac, 10, 64)
+
+	amount := major*100 + minor
+	if negative {
+		amount = -amount
+	}
+	return amount, nil
+}
+
+// formatMoney prints an amount in minor units with its currency, for example "GBP 35.00".
+func formatMoney(amount int64, currency string) string {
+	sign := ""
+	if amount < 0 {
+		sign = "-"
+		amount = -amount
+	}
+	return fmt.Sprintf("%s %s%d.%02d", currency, sign, amount/100, amount%100)
+}
+
+// quoteString prints the price of each appointment being booked, along with the tax and the total.
+// Appointments that cannot be priced are listed without a price.
+func quoteString(appts []appointment) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += "Quote:\n"
+
+	var subtotal int64
+	for _, a := range appts {
+		price, err := clinicPrices.priceFor(a)
+		if err != nil {
+			s += fmt.Sprintf("%s - %s on %s: %s\n", a.pet.name, a.appointmentType, a.dateTime.In(clinicLocation).Format("02 Jan"), err)
+			continue
+		}
+		subtotal += price
+		s += fmt.Sprintf("%s - %s on %s: %s\n", a.pet.name, a.appointmentType, a.dateTime.In(clinicLocation).Format("02 Jan"), formatMoney(price, clinicPrices.Currency))
+	}
+
+	tax := taxOn(subtotal, clinicPrices.TaxPercent)
+	s += fmt.Sprintf("Subtotal: %s\n", formatMoney(subtotal, clinicPrices.Currency))
+	s += fmt.Sprintf("Tax (%g%%): %s\n", clinicPrices.TaxPercent, formatMoney(tax, clinicPrices.Currency))
+	s += fmt.Sprintf("Total: %s\n", formatMoney(subtotal+tax, clinicPrices.Currency))
+	s += "Prices are confirmed on the invoice after the appointment.\n"
+	s += "-------------------------------------\n"
+
+	return s
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+var testPrices = priceList{
+	Currency:       "GBP",
+	TaxPercent:     20,
+	DepositPercent: map[string]int{"Surgical": 25},
+	RefundPolicy: []refundTier{
+		{NoticeHours: 48, Percent: 100},
+		{NoticeHours: 24, Percent: 50},
+		{NoticeHours: 0, Percent: 0},
+	},
+	CancellationPolicy: cancellationPolicy{StrikeDepositPercent: 50},
+	Prices: []priceEntry{
+		{Type: "Grooming", Species: "Dog", MaxWeightKg: 10, amount: 3500},
+		{Type: "Grooming", amount: 3000},
+		{Type: "Surgical", Species: "Dog", MinWeightKg: 25, amount: 52000},
+		{Type: "Surgical", amount: 30000},
+	},
+}
+
+func TestParseMoney(t *testing.T) {
+	tests := []struct {
+		input   string
+		want    int64
+		wantErr bool
+	}{
+		{"35", 3500, false},
+		{"35.5", 3550, false},
+		{"35.50", 3550, false},
+		{" 0.05 ", 5, false},
+		{"0", 0, false},
+		{"-12.30", -1230, false},
+		{"007.10", 710, false},
+		{"", 0, true},
+		{"-", 0, true},
+		{".50", 0, true},
+		{"35.", 0, true},
+		{"35.505", 0, true},
+		{"1.-5", 0, true},
+		{"1.+5", 0, true},
+		{"--5", 0, true},
+		{"+3", 0, true},
+		{"-+3", 0, true},
+		{"3,50", 0, true},
+		{"1 000", 0, true},
+		{"£35", 0, true},
+		{"1e3", 0, true},
+		{"99999999999999999999", 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := parseMoney(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseMoney(%q) error = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("parseMoney(%q) = %d, want %d", tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestTaxOn(t *testing.T) {
+	tests := []struct {
+		amount  int64
+		percent float64
+		want    int64
+	}{
+		{1000, 20, 200},
+		{0, 20, 0},
+		{1, 20, 0},
+		{3, 20, 1},
+		{5, 10, 1},
+		{250, 17.5, 44},
+		{12345, 0, 0},
+		{-500, 20, -100},
+	}
+
+	for _, tt := range tests {
+		if got := taxOn(tt.amount, tt.percent); got != tt.want {
+			t.Errorf("taxOn(%d, %v) = %d, want %d", tt.amount, tt.percent, got, tt.want)
+		}
+	}
+}
+
+func TestDepositFor(t *testing.T) {
+	tests := []struct {
+		name       string
+		a          appointment
+		restricted bool
+		want       int64
+		wantErr    bool
+	}{
+		{"no deposit for the type", appointment{appointmentType: "Grooming", pet: pet{species: "Dog", weightKg: 5}}, false, 0, false},
+		{"share of the price with tax", appointment{appointmentType: "Surgical", pet: pet{species: "Dog", weightKg: 30}}, false, 15600, false},
+		{"price already set", appointment{appointmentType: "Surgical", pet: pet{species: "Cat", weightKg: 4}, price: 10001}, false, 3000, false},
+		{"restricted owner pays the strike deposit", appointment{appointmentType: "Grooming", pet: pet{species: "Dog", weightKg: 5}}, true, 2100, false},
+		{"restricted owner pays the larger deposit", appointment{appointmentType: "Surgical", pet: pet{species: "Cat", weightKg: 4}}, true, 18000, false},
+		{"no price for the type", appointment{appointmentType: "Bath", pet: pet{species: "Cat", weightKg: 4}}, true, 0, true},
+	}
+
+	for _, tt := range tests {
+		got, err := testPrices.depositFor(tt.a, tt.restricted)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: depositFor error = %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if err == nil && got != tt.want {
+			t.Errorf("%s: depositFor = %d, want %d", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRefundPercent(t *testing.T) {
+	tests := []struct {
+		policy []refundTier
+		notice time.Duration
+		want   int
+	}{
+		{testPrices.RefundPolicy, 72 * time.Hour, 100},
+		{testPrices.RefundPolicy, 48 * time.Hour, 100},
+		{testPrices.RefundPolicy, 48*time.Hour - time.Minute, 50},
+		{testPrices.RefundPolicy, 24 * time.Hour, 50},
+		{testPrices.RefundPolicy, time.Hour, 0},
+		{testPrices.RefundPolicy, -time.Hour, 0},
+		{[]refundTier{{NoticeHours: 24, Percent: 100}}, 10 * time.Hour, 0},
+		{nil, 72 * time.Hour, 0},
+	}
+
+	for _, tt := range tests {
+		p := priceList{RefundPolicy: tt.policy}
+		if got := p.refundPercent(tt.notice); got != tt.want {
+			t.Errorf("refundPercent(%v) with %v = %d, want %d", tt.notice, tt.policy, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/lib/pq"
+)
+
+// defaultQuestionnaires holds the questions shipped with the program for each appointment type.
+// It can be replaced by pointing QUESTIONNAIRE_FILE at a file in the same format.
+//
+//go:embed questionnaires.json
+var defaultQuestionnaires []byte
+
+// clinicQuestionnaires holds the questions owners answer when booking each appointment type.
+var clinicQuestionnaires []questionnaire
+
+// answerLimit is the most characters an owner can write in answer to one question.
+const answerLimit = 200
+
+// questionnaire is a struct that holds the questions asked before one appointment type.
+type questionnaire struct {
+	AppointmentType string   `json:"appointmentType"`
+	Questions       []string `json:"questions"`
+}
+
+// questionnaireFile is the layout of questionnaires.json.
+type questionnaireFile struct {
+	Questionnaires []questionnaire `json:"questionnaires"`
+}
+
+// questionAnswer is a struct that holds an owner's answer to one question asked when booking.
+// The question is stored with the answer, so the vet sees what was asked even if the questions change later.
+type questionAnswer struct {
+	question string
+	answer   string
+}
+
+// loadQuestionnaires reads the questions for each appointment type from QUESTIONNAIRE_FILE, or the built-in questionnaires if it is not set.
+// Every appointment type must be one of "allowedAppointmentTypes", and can only be listed once.
+func loadQuestionnaires() ([]questionnaire, error) {
+	data := defaultQuestionnaires
+	source := "built-in questionnaires"
+
+	if path := os.Getenv("QUESTIONNAIRE_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading QUESTIONNAIRE_FILE: %w", err)
+		}
+		data = b
+		source = path
+	}
+
+	var f questionnaireFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&f); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	var types []string
+	for _, q := range f.Questionnaires {
+		if !slices.Contains(allowedAppointmentTypes, q.AppointmentType) {
+			return nil, fmt.Errorf("%s: unknown appointment type %q", source, q.AppointmentType)
+		}
+		if slices.Contains(types, q.AppointmentType) {
+			return nil, fmt.Errorf("%s: %s is listed more than once", source, q.AppointmentType)
+		}
+		types = append(types, q.AppointmentType)
+
+		for _, question := range q.Questions {
+			if question == "" {
+				return nil, fmt.Errorf("%s: %s has an empty question", source, q.AppointmentType)
+			}
+		}
+	}
+
+	return f.Questionnaires, nil
+}
+
+// questionsFor lists the questions asked before an appointment type, or nothing if it has no questionnaire.
+func questionsFor(appointmentType string) []string {
+	for _, q := range clinicQuestionnaires {
+		if q.AppointmentType == appointmentType {
+			return q.Questions
+		}
+	}
+	return nil
+}
+
+// askQuestionnaire asks the user the questions for the pet's appointment type, one at a time, and returns their answers.
+func askQuestionnaire(scanner *bufio.Scanner, a appointment) []questionAnswer {
+	questions := questionsFor(a.appointmentType)
+	if len(questions) == 0 {
+		return nil
+	}
+
+	fmt.Printf("Before %s's %s appointment, please answer a few questions for the vet:\n", a.pet.name, a.appointmentType)
+
+	answers := make([]questionAnswer, 0, len(questions))
+	for _, question := range questions {
+		for {
+			answer, err := getRequiredText(scanner, question, answerLimit)
+			if err == nil {
+				answers = append(answers, questionAnswer{question: question, answer: answer})
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+	return answers
+}
+
+// insertAnswers stores the questionnaire answers for a saved appointment inside the caller's transaction.
+func insertAnswers(tx execer, appointmentID int, answers []questionAnswer) error {
+	for i, qa := range answers {
+		_, err := tx.Exec(
+			`INSERT INTO appointment_answers (appointment_id, position, question, answer) VALUES ($1, $2, $3, $4)`,
+			appointmentID,
+			i+1,
+			qa.question,
+			qa.answer,
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// getAnswers fetches the questionnaire answers for a list of appointments, in the order the questions were asked, keyed by appointment ID.
+func getAnswers(db queryer, appointmentIDs []int) (map[int][]questionAnswer, error) {
+	rows, err := db.Query(
+		`SELECT appointment_id, question, answer FROM appointment_answers
+		 WHERE appointment_id = ANY($1)
+		 ORDER BY appointment_id, position`,
+		pq.Array(appointmentIDs),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	answers := make(map[int][]questionAnswer)
+	for rows.Next() {
+		var id int
+		var qa questionAnswer
+		if err := rows.Scan(&id, &qa.question, &qa.answer); err != nil {
+			return nil, err
+		}
+		answers[id] = append(answers[id], qa)
+	}
+	return answers, rows.Err()
+}
+
+// attachAnswers fills in the questionnaire answers of each appointment in the list.
+func attachAnswers(db queryer, appts []appointment) error {
+	ids := make([]int, 0, len(appts))
+	for _, a := range appts {
+		ids = append(ids, a.id)
+	}
+
+	answers, err := getAnswers(db, ids)
+	if err != nil {
+		return err
+	}
+	for i := range appts {
+		appts[i].answers = answers[appts[i].id]
+	}
+	return nil
+}
+
+// answersString prints an appointment's questionnaire answers, one per line, indented to sit under its line in the schedule.
+func answersString(answers []questionAnswer) string {
+	var s string
+	for _, qa := range answers {
+		s += fmt.Sprintf("      %s %s\n", qa.question, qa.answer)
+	}
+	return s
+}
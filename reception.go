@@ -0,0 +1,581 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// triageLevels is a list that holds how urgently a patient needs to be seen, most urgent first.
+// Walk-ins are given a level when they are registered. Checked-in appointments wait at the level they were booked with, or "Routine".
+// Only the first two levels can be booked through the emergency path.
+var triageLevels = []string{
+	"Emergency",
+	"Urgent",
+	"Soon",
+	"Routine",
+}
+
+// triageRank gives a triage level's place in triageLevels, so more urgent levels sort first.
+// Unknown levels sort last.
+func triageRank(level string) int {
+	if i := slices.Index(triageLevels, level); i >= 0 {
+		return i
+	}
+	return len(triageLevels)
+}
+
+// queueEntry is a struct that holds one patient in the waiting room: either a checked-in appointment or a walk-in.
+// Exactly one of appointmentID and walkInID is set.
+//   - due is when the patient is due to be seen: the appointment time, or the arrival time for a walk-in.
+//   - arrivedAt is when they checked in or walked in.
+type queueEntry struct {
+	appointmentID int
+	walkInID      int
+	petName       string
+	species       string
+	owner         string
+	reason        string
+	vet           string
+	priority      string
+	due           time.Time
+	arrivedAt     time.Time
+}
+
+// getWaitingQueue fetches everyone waiting to be seen, in the order they should be called.
+// Patients are ordered by triage level, then by when they are due, so an urgent walk-in goes ahead of booked appointments
+// and a routine walk-in waits for appointments booked before they arrived.
+func getWaitingQueue(db queryer) ([]queueEntry, error) {
+	rows, err := db.Query(
+		`SELECT a.id, 0, a.pet_name, a.pet_species, u.first_name || ' ' || u.last_name, a.appointment_type, a.vet_name,
+			COALESCE(a.triage_level, 'Routine'), a.appointment_time, a.checked_in_at
+		 FROM appointments a
+		 JOIN users u ON u.id = a.user_id
+		 WHERE a.status = 'Checked-in'
+		 UNION ALL
+		 SELECT 0, w.id, w.pet_name, w.pet_species, w.owner_name, w.reason, '', w.priority, w.arrived_at, w.arrived_at
+		 FROM walk_ins w
+		 WHERE w.status = 'Waiting'`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var queue []queueEntry
+	for rows.Next() {
+		var e queueEntry
+		err := rows.Scan(&e.appointmentID, &e.walkInID, &e.petName, &e.species, &e.owner, &e.reason, &e.vet,
+			&e.priority, &e.due, &e.arrivedAt)
+		if err != nil {
+			return nil, err
+		}
+		queue = append(queue, e)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	slices.SortStableFunc(queue, func(a, b queueEntry) int {
+		if r := triageRank(a.priority) - triageRank(b.priority); r != 0 {
+			return r
+		}
+		return a.due.Compare(b.due)
+	})
+	return queue, nil
+}
+
+// queueString prints the waiting list with how long each patient has been waiting.
+func queueString(queue []queueEntry, now time.Time) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += fmt.Sprintf("Waiting list at %s:\n", now.In(clinicLocation).Format("15:04"))
+
+	if len(queue) == 0 {
+		s += "Nobody is waiting.\n"
+	}
+	for i, e := range queue {
+		kind := "Walk-in"
+		if e.appointmentID != 0 {
+			kind = e.due.In(clinicLocation).Format("15:04") + " with " + e.vet
+		}
+		s += fmt.Sprintf("%d. [%s] %s (%s), %s - %s, %s, waiting %s\n",
+			i+1, e.priority, e.petName, e.species, e.owner, e.reason, kind, waitString(now.Sub(e.arrivedAt)))
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// waitString describes a length of time spent waiting in whole minutes, such as "25 min".
+func waitString(d time.Duration) string {
+	return fmt.Sprintf("%d min", int(max(d, 0)/time.Minute))
+}
+
+// lateString describes how early or late a patient arrived for an appointment at t.
+func lateString(arrived, t time.Time) string {
+	d := arrived.Sub(t).Round(time.Minute)
+	switch {
+	case d > 0:
+		return fmt.Sprintf("%d minutes late", int(d/time.Minute))
+	case d < 0:
+		return fmt.Sprintf("%d minutes early", int(-d/time.Minute))
+	default:
+		return "on time"
+	}
+}
+
+// checkInAppointment is called when reception select "Check in an appointment".
+// Reception pick one of today's booked appointments, which is marked as checked in with the arrival time recorded.
+// Appointments that need the owner's consent cannot be checked in until it is on record; the owner can give it at the desk.
+func checkInAppointment(scanner *bufio.Scanner, db *sql.DB) {
+	today := clinicToday()
+	appts, err := queryAppointments(db,
+		`WHERE a.status = 'Booked' AND a.appointment_time >= $1 AND a.appointment_time < $2
+		 ORDER BY a.appointment_time, a.id`,
+		today, today.AddDate(0, 0, 1),
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(appts) == 0 {
+		fmt.Println("There are no appointments left to check in today.")
+		return
+	}
+
+	a, ok := chooseAppointment(scanner, appts)
+	if !ok {
+		return
+	}
+	if !confirmConsentAtDesk(scanner, db, a) {
+		fmt.Println(a.pet.name, "has not been checked in.")
+		return
+	}
+
+	now := time.Now()
+	res, err := db.Exec(
+		`UPDATE appointments SET status = 'Checked-in', checked_in_at = $2 WHERE id = $1 AND status = 'Booked'`,
+		a.id,
+		now,
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		fmt.Println("Error: this appointment can no longer be checked in")
+		return
+	}
+
+	fmt.Printf("%s is checked in for their %s appointment with %s (%s).\n", a.pet.name, a.appointmentType, a.vet, lateString(now, a.dateTime))
+}
+
+// getTriageLevel is a helper function that prompts staff to choose how urgently a pet needs to be seen from the given levels.
+func getTriageLevel(scanner *bufio.Scanner, levels []string) (string, error) {
+	fmt.Println("How urgently does the pet need to be seen?")
+	for i, v := range levels {
+		fmt.Printf("%d. %s\n", i+1, v)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(levels) {
+		return "", fmt.Errorf("please select one of the levels displayed")
+	}
+	return levels[choice-1], nil
+}
+
+// getRequiredText is a helper function that prompts for a line of text that cannot be left blank, such as the reason for a visit.
+// Runs of spaces are collapsed, and the text can be at most limit characters long.
+func getRequiredText(scanner *bufio.Scanner, prompt string, limit int) (string, error) {
+	fmt.Println(prompt)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.Join(strings.Fields(scanner.Text()), " ")
+
+	if input == "" {
+		return "", fmt.Errorf("this cannot be left blank")
+	}
+	if len(input) > limit {
+		return "", fmt.Errorf("character limit is %d characters", limit)
+	}
+	return input, nil
+}
+
+// registerWalkIn is called when reception select "Register a walk-in".
+// Walk-ins can belong to a registered owner or to someone without an account, and are added to the waiting list with a triage level.
+func registerWalkIn(scanner *bufio.Scanner, db *sql.DB) {
+	var registered bool
+	for {
+		r, err := getYesNo(scanner, "Does the owner have an account?")
+		if err == nil {
+			registered = r
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var userID sql.NullInt64
+	var ownerName, phone string
+	if registered {
+		for {
+			u, id, err := getStaffOwner(scanner, db)
+			if err == nil {
+				userID = sql.NullInt64{Int64: int64(id), Valid: true}
+				ownerName, phone = u.firstName+" "+u.lastName, u.phone
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	} else {
+		for {
+			n, err := getRequiredText(scanner, "Please enter the owner's name:", 60)
+			if err == nil {
+				ownerName = n
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		for {
+			p, err := getUserPhone(scanner)
+			if err == nil {
+				phone = p
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+	}
+
+	var p pet
+	for {
+		n, err := getName(scanner, 0)
+		if err == nil {
+			p.name = n
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+	for {
+		s, err := getSpecies(scanner, 0)
+		if err == nil {
+			p.species = s
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var reason string
+	for {
+		r, err := getRequiredText(scanner, "What is the reason for the visit?", 200)
+		if err == nil {
+			reason = r
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var priority string
+	for {
+		l, err := getTriageLevel(scanner, triageLevels)
+		if err == nil {
+			priority = l
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO walk_ins (user_id, owner_name, phone, pet_name, pet_species, reason, priority)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		userID,
+		ownerName,
+		phone,
+		p.name,
+		p.species,
+		reason,
+		priority,
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Printf("%s has been added to the waiting list as %s.\n", p.name, strings.ToLower(priority))
+}
+
+// chooseQueueEntry is a helper function that shows the waiting list and asks reception to pick a patient.
+// An empty input picks the first patient in the queue. false is returned if nobody is waiting.
+func chooseQueueEntry(scanner *bufio.Scanner, db *sql.DB, prompt string) (queueEntry, bool) {
+	queue, err := getWaitingQueue(db)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return queueEntry{}, false
+	}
+	if len(queue) == 0 {
+		fmt.Println("Nobody is waiting.")
+		return queueEntry{}, false
+	}
+
+	fmt.Print(queueString(queue, time.Now()))
+	for {
+		fmt.Println(prompt)
+		fmt.Print("> ")
+
+		scanner.Scan()
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			return queue[0], true
+		}
+
+		choice, err := strconv.Atoi(input)
+		if err != nil || choice < 1 || choice > len(queue) {
+			fmt.Println("Error: please select one of the patients displayed")
+			continue
+		}
+		return queue[choice-1], true
+	}
+}
+
+// callNextPatient is called when reception select "Call the next patient".
+// A checked-in appointment moves to "In progress", once any consent it needs is on record; a walk-in is marked as seen by the vet who takes them.
+// The time they were called is recorded for the waiting time statistics.
+func callNextPatient(scanner *bufio.Scanner, db *sql.DB) {
+	e, ok := chooseQueueEntry(scanner, db, "Enter the number of the patient to call, or leave blank for the first:")
+	if !ok {
+		return
+	}
+
+	if e.appointmentID != 0 {
+		appts, err := queryAppointments(db, `WHERE a.id = $1`, e.appointmentID)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if len(appts) == 0 || !confirmConsentAtDesk(scanner, db, appts[0]) {
+			fmt.Println(e.petName, "cannot be called through yet.")
+			return
+		}
+	}
+
+	now := time.Now()
+	var res sql.Result
+	var err error
+
+	if e.appointmentID != 0 {
+		res, err = db.Exec(
+			`UPDATE appointments SET status = 'In progress', started_at = $2 WHERE id = $1 AND status = 'Checked-in'`,
+			e.appointmentID,
+			now,
+		)
+	} else {
+		var vet string
+		for {
+			v, err := getVet(scanner, 0)
+			if err == nil {
+				vet = v
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		res, err = db.Exec(
+			`UPDATE walk_ins SET status = 'Seen', seen_at = $2, vet_name = $3 WHERE id = $1 AND status = 'Waiting'`,
+			e.walkInID,
+			now,
+			vet,
+		)
+		e.vet = vet
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		fmt.Println("Error: this patient is no longer waiting")
+		return
+	}
+
+	fmt.Printf("%s can go through to %s (waited %s).\n", e.petName, e.vet, waitString(now.Sub(e.arrivedAt)))
+}
+
+// removeWalkIn is called when reception select "Remove a walk-in who left".
+func removeWalkIn(scanner *bufio.Scanner, db *sql.DB) {
+	e, ok := chooseQueueEntry(scanner, db, "Enter the number of the walk-in who left:")
+	if !ok {
+		return
+	}
+	if e.walkInID == 0 {
+		fmt.Println("Error: only walk-ins can be removed; booked appointments are cancelled or completed")
+		return
+	}
+
+	res, err := db.Exec(`UPDATE walk_ins SET status = 'Left', seen_at = now() WHERE id = $1 AND status = 'Waiting'`, e.walkInID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if n, err := res.RowsAffected(); err != nil || n == 0 {
+		fmt.Println("Error: this walk-in is no longer waiting")
+		return
+	}
+	fmt.Println(e.petName, "has been taken off the waiting list.")
+}
+
+// waitStats is a struct that holds the waiting room statistics for one day.
+// Waits are measured from arrival, or from the appointment time for patients who arrived early, until they were called.
+type waitStats struct {
+	checkedIn       int
+	late            int
+	avgLateness     time.Duration
+	appointmentWait time.Duration
+	walkIns         int
+	walkInsLeft     int
+	walkInWait      time.Duration
+}
+
+// getWaitStats works out the waiting room statistics for the day starting at day.
+func getWaitStats(db rowQueryer, day time.Time) (waitStats, error) {
+	var s waitStats
+	var lateness, appointmentWait, walkInWait sql.NullFloat64
+
+	err := db.QueryRow(
+		`SELECT
+			(SELECT COUNT(*) FROM appointments
+			 WHERE checked_in_at IS NOT NULL AND appointment_time >= $1 AND appointment_time < $2),
+			(SELECT COUNT(*) FROM appointments
+			 WHERE checked_in_at > appointment_time AND appointment_time >= $1 AND appointment_time < $2),
+			(SELECT AVG(EXTRACT(EPOCH FROM checked_in_at - appointment_time)) FROM appointments
+			 WHERE checked_in_at > appointment_time AND appointment_time >= $1 AND appointment_time < $2),
+			(SELECT AVG(EXTRACT(EPOCH FROM started_at - GREATEST(checked_in_at, appointment_time))) FROM appointments
+			 WHERE started_at IS NOT NULL AND appointment_time >= $1 AND appointment_time < $2),
+			(SELECT COUNT(*) FROM walk_ins WHERE arrived_at >= $1 AND arrived_at < $2),
+			(SELECT COUNT(*) FROM walk_ins WHERE status = 'Left' AND arrived_at >= $1 AND arrived_at < $2),
+			(SELECT AVG(EXTRACT(EPOCH FROM seen_at - arrived_at)) FROM walk_ins
+			 WHERE status = 'Seen' AND arrived_at >= $1 AND arrived_at < $2)`,
+		day,
+		day.AddDate(0, 0, 1),
+	).Scan(&s.checkedIn, &s.late, &lateness, &appointmentWait, &s.walkIns, &s.walkInsLeft, &walkInWait)
+	if err != nil {
+		return waitStats{}, err
+	}
+
+	s.avgLateness = time.Duration(lateness.Float64 * float64(time.Second))
+	s.appointmentWait = time.Duration(appointmentWait.Float64 * float64(time.Second))
+	s.walkInWait = time.Duration(walkInWait.Float64 * float64(time.Second))
+	return s, nil
+}
+
+// waitStatsString prints the waiting room statistics for a day.
+func waitStatsString(day time.Time, s waitStats) string {
+	var str string
+	str = "-------------------------------------\n"
+	str += fmt.Sprintf("Waiting times for %s:\n", day.Format("Mon 02 Jan 2006"))
+	str += fmt.Sprintf("Appointments checked in: %d (%d late, by %s on average)\n", s.checkedIn, s.late, waitString(s.avgLateness))
+	str += fmt.Sprintf("Average wait for appointments: %s\n", waitString(s.appointmentWait))
+	str += fmt.Sprintf("Walk-ins: %d (%d left before being seen)\n", s.walkIns, s.walkInsLeft)
+	str += fmt.Sprintf("Average wait for walk-ins: %s\n", waitString(s.walkInWait))
+	str += "-------------------------------------\n"
+
+	return str
+}
+
+// receptionMenu is a function that displays the reception menu and returns the option selected.
+func receptionMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Check in an appointment")
+	fmt.Println("2. Register a walk-in")
+	fmt.Println("3. Waiting list")
+	fmt.Println("4. Call the next patient")
+	fmt.Println("5. Remove a walk-in who left")
+	fmt.Println("6. Today's waiting times")
+	fmt.Println("7. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// runReception is called when staff select "Reception" in the staff menu.
+func runReception(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch receptionMenu(scanner) {
+		case "1":
+			checkInAppointment(scanner, db)
+
+		case "2":
+			registerWalkIn(scanner, db)
+
+		case "3":
+			queue, err := getWaitingQueue(db)
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Print(queueString(queue, time.Now()))
+
+		case "4":
+			callNextPatient(scanner, db)
+
+		case "5":
+			removeWalkIn(scanner, db)
+
+		case "6":
+			today := clinicToday()
+			stats, err := getWaitStats(db, today)
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Print(waitStatsString(today, stats))
+
+		case "7":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// runReceptionCommand handles the "reception" command.
+//   - reception queue: print the waiting list
+//   - reception stats [YYYY-MM-DD]: print the waiting times for a day (default today)
+func runReceptionCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: reception queue | reception stats [YYYY-MM-DD]")
+	}
+
+	switch args[0] {
+	case "queue":
+		queue, err := getWaitingQueue(db)
+		if err != nil {
+			return err
+		}
+		fmt.Print(queueString(queue, time.Now()))
+		return nil
+
+	case "stats":
+		day := clinicToday()
+		if len(args) > 1 {
+			d, err := time.ParseInLocation("2006-01-02", args[1], clinicLocation)
+			if err != nil {
+				return fmt.Errorf("invalid date format, expected YYYY-MM-DD")
+			}
+			day = d
+		}
+		stats, err := getWaitStats(db, day)
+		if err != nil {
+			return err
+		}
+		fmt.Print(waitStatsString(day, stats))
+		return nil
+
+	default:
+		return fmt.Errorf("unknown reception command %q, expected queue or stats", args[0])
+	}
+}
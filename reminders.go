@@ -0,0 +1,227 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"os/signal"
+	"sort"
+	"strings"
+	"time"
+)
+
+// loadReminderOffsets reads how long before an appointment reminders are sent from the REMINDER_OFFSETS environment variable (for example "48h,2h").
+// The offsets are returned from largest to smallest.
+func loadReminderOffsets() ([]time.Duration, error) {
+	value := os.Getenv("REMINDER_OFFSETS")
+	if value == "" {
+		value = "48h,2h"
+	}
+
+	var offsets []time.Duration
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		d, err := time.ParseDuration(part)
+		if err != nil || d <= 0 {
+			return nil, fmt.Errorf("invalid reminder offset %q in REMINDER_OFFSETS", part)
+		}
+		offsets = append(offsets, d)
+	}
+
+	if len(offsets) == 0 {
+		return nil, fmt.Errorf("REMINDER_OFFSETS must list at least one offset")
+	}
+
+	sort.Slice(offsets, func(i, j int) bool { return offsets[i] > offsets[j] })
+	return offsets, nil
+}
+
+// dueOffset picks the reminder that should have gone out by now for an appointment at t.
+// When several are due, only the closest to the appointment is returned, so a late run does not send stale reminders as well.
+// false is returned when no reminder is due yet.
+func dueOffset(offsets []time.Duration, t, now time.Time) (time.Duration, bool) {
+	due := time.Duration(0)
+	found := false
+
+	for _, offset := range offsets {
+		if !now.Before(t.Add(-offset)) {
+			due = offset
+			found = true
+		}
+	}
+	return due, found
+}
+
+// reminderMessage builds the reminder for an appointment, addressed through the given channel.
+func reminderMessage(a appointment, offset time.Duration, c channel, now time.Time) outboxMessage {
+	t := a.dateTime.In(clinicLocation)
+
+	body := fmt.Sprintf("Hi %s,\n\n", a.owner.firstName)
+	body += fmt.Sprintf("This is a reminder that %s has a %s appointment with %s on %s (%s).\n\n",
+		a.pet.name,
+		a.appointmentType,
+		a.vet,
+		t.Format("Monday, 02 Jan 2006 at 15:04 MST"),
+		relativeTime(t, now),
+	)
+	body += "If you can no longer attend, please contact the clinic.\n"
+
+	return outboxMessage{
+		userID:        a.userID,
+		appointmentID: sql.NullInt64{Int64: int64(a.id), Valid: true},
+		appointmentAt: sql.NullTime{Time: a.dateTime, Valid: true},
+		kind:          "reminder",
+		channel:       c.name(),
+		recipient:     c.recipient(a.owner),
+		subject:       fmt.Sprintf("Appointment reminder: %s on %s", a.pet.name, t.Format("Mon 02 Jan at 15:04")),
+		body:          body,
+		dedupeKey:     fmt.Sprintf("reminder:%d:%d:%s:%s", a.id, a.dateTime.Unix(), offset, c.name()),
+	}
+}
+
+// enqueueReminders queues a reminder on every channel for each booked appointment that has reached one of its reminder offsets.
+// All reminders from one run are queued in a single transaction.
+// The number of new messages queued is returned; reminders queued by an earlier run are not queued again.
+func enqueueReminders(db *sql.DB, offsets []time.Duration, channels []channel) (int, error) {
+	now := time.Now()
+
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	appts, err := queryAppointments(tx,
+		`WHERE a.status = 'Booked' AND a.appointment_time > $1 AND a.appointment_time <= $2
+		 ORDER BY a.appointment_time`,
+		now, now.Add(offsets[0]),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, a := range appts {
+		offset, ok := dueOffset(offsets, a.dateTime, now)
+		if !ok {
+			continue
+		}
+
+		for _, c := range channels {
+			added, err := enqueueMessage(tx, reminderMessage(a, offset, c, now))
+			if err != nil {
+				return 0, err
+			}
+			if added {
+				queued++
+			}
+		}
+	}
+
+	return queued, tx.Commit()
+}
+
+// runReminders queues any reminders that have become due and then delivers everything waiting in the outbox.
+// Owners are also reminded to book vaccinations that are coming due.
+// Waitlist offers that have run out are also passed on here, so their slots are offered to the next owner in line,
+// and appointments that were never checked in are marked as no-shows.
+func runReminders(db *sql.DB, offsets []time.Duration, channels []channel) error {
+	queued, err := enqueueReminders(db, offsets, channels)
+	if err != nil {
+		return err
+	}
+
+	vaccinations, err := enqueueVaccinationReminders(db, channels)
+	if err != nil {
+		return err
+	}
+
+	expired, err := expireWaitlistOffers(db, channels)
+	if err != nil {
+		return err
+	}
+
+	noShows, err := markNoShows(db)
+	if err != nil {
+		return err
+	}
+
+	sent, failed, err := deliverPending(db, channels)
+	if err != nil {
+		return err
+	}
+
+	fmt.Printf("%s reminders queued: %d, vaccination reminders queued: %d, waitlist offers expired: %d, no-shows marked: %d, messages sent: %d, failed: %d\n",
+		time.Now().In(clinicLocation).Format("2006-01-02 15:04:05"), queued, vaccinations, expired, noShows, sent, failed)
+	return nil
+}
+
+// runRemindersCommand handles the "reminders" command.
+//   - reminders run: queue due reminders and deliver the outbox once
+//   - reminders daemon: do the same every REMINDER_INTERVAL (default 5m) until interrupted
+//   - reminders status: show how many messages are pending, sent and failed
+func runRemindersCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: reminders run|daemon|status")
+	}
+
+	if args[0] == "status" {
+		s, err := outboxStatusString(db)
+		if err != nil {
+			return err
+		}
+		fmt.Print(s)
+		return nil
+	}
+
+	offsets, err := loadReminderOffsets()
+	if err != nil {
+		return err
+	}
+
+	channels, err := loadChannels()
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "run":
+		return runReminders(db, offsets, channels)
+
+	case "daemon":
+		interval := 5 * time.Minute
+		if value := os.Getenv("REMINDER_INTERVAL"); value != "" {
+			interval, err = time.ParseDuration(value)
+			if err != nil || interval <= 0 {
+				return fmt.Errorf("invalid REMINDER_INTERVAL %q", value)
+			}
+		}
+
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+		defer stop()
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			// A failed run is reported and retried on the next tick instead of stopping the daemon.
+			if err := runReminders(db, offsets, channels); err != nil {
+				fmt.Println("Error:", err)
+			}
+
+			select {
+			case <-ctx.Done():
+				fmt.Println("Stopping reminder daemon.")
+				return nil
+			case <-ticker.C:
+			}
+		}
+
+	default:
+		return fmt.Errorf("unknown reminders command %q, expected run, daemon or status", args[0])
+	}
+}
@@ -0,0 +1,336 @@
+package main
+
+import (
+	"bytes"
+	_ "embed"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+)
+
+// defaultBookingRules holds the rule set shipped with the program.
+// It can be replaced by pointing BOOKING_RULES_FILE at a file in the same format.
+//
+//go:embed booking_rules.json
+var defaultBookingRules []byte
+
+// bookingRules is the rule set every booking is checked against.
+// It is loaded once at startup by loadBookingRules.
+var bookingRules []bookingRule
+
+// errBookingBlocked is returned when a booking breaks a rule whose outcome is "block".
+var errBookingBlocked = errors.New("booking not allowed")
+
+// ruleOutcomes lists what a rule can do when it matches, from most to least serious.
+//   - block: the booking cannot be made
+//   - warn: the user is shown the message and asked whether to carry on
+//   - info: the message is shown and the booking goes ahead
+var ruleOutcomes = []string{"block", "warn", "info"}
+
+// ruleFields lists the pet and appointment fields a rule condition can test, along with the kind of value each holds.
+var ruleFields = map[string]string{
+	"species":         "text",
+	"appointmentType": "text",
+	"vet":             "text",
+	"age":             "number",
+	"weightKg":        "number",
+	"vaccinated":      "bool",
+}
+
+// ruleCondition is a struct that holds one test a rule makes, such as {"field": "age", "op": "<", "value": 1}.
+// Text fields support =, != and in; numbers also support <, <=, > and >=; booleans support = and !=.
+type ruleCondition struct {
+	Field string          `json:"field"`
+	Op    string          `json:"op"`
+	Value json.RawMessage `json:"value"`
+
+	// The value decoded for the field's kind when the rules are loaded.
+	text    []string
+	number  float64
+	boolean bool
+}
+
+// bookingRule is a struct that holds one eligibility rule.
+// A rule applies to the appointment types it lists (or every type when none are listed) and matches when all of its conditions hold.
+type bookingRule struct {
+	Name    string          `json:"name"`
+	Types   []string        `json:"types"`
+	When    []ruleCondition `json:"when"`
+	Outcome string          `json:"outcome"`
+	Message string          `json:"message"`
+}
+
+// ruleResult is a struct that holds the outcome of one rule that matched a booking.
+type ruleResult struct {
+	rule    string
+	outcome string
+	message string
+}
+
+// loadBookingRules reads the booking rule set from the file named in the BOOKING_RULES_FILE environment variable,
+// or uses the built-in rules when it is not set.
+// Every rule is checked when it is loaded, so a mistake in the file is reported at startup rather than during a booking.
+func loadBookingRules() ([]bookingRule, error) {
+	data := defaultBookingRules
+	source := "built-in booking rules"
+
+	if path := os.Getenv("BOOKING_RULES_FILE"); path != "" {
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("reading BOOKING_RULES_FILE: %w", err)
+		}
+		data = b
+		source = path
+	}
+
+	var file struct {
+		Rules []bookingRule `json:"rules"`
+	}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("%s: %w", source, err)
+	}
+
+	for i := range file.Rules {
+		if err := file.Rules[i].validate(); err != nil {
+			return nil, fmt.Errorf("%s: rule %d: %w", source, i+1, err)
+		}
+	}
+	return file.Rules, nil
+}
+
+// validate checks a rule's outcome, appointment types and conditions, and decodes each condition's value.
+func (r *bookingRule) validate() error {
+	if r.Name == "" {
+		return fmt.Errorf("rule has no name")
+	}
+	if !slices.Contains(ruleOutcomes, r.Outcome) {
+		return fmt.Errorf("%s: outcome must be one of %s", r.Name, strings.Join(ruleOutcomes, ", "))
+	}
+	if r.Message == "" {
+		return fmt.Errorf("%s: rule has no message", r.Name)
+	}
+	for _, t := range r.Types {
+		if !slices.Contains(allowedAppointmentTypes, t) {
+			return fmt.Errorf("%s: unknown appointment type %q", r.Name, t)
+		}
+	}
+
+	for i := range r.When {
+		if err := r.When[i].decode(); err != nil {
+			return fmt.Errorf("%s: %w", r.Name, err)
+		}
+	}
+	return nil
+}
+
+// decode checks a condition's field and operator, and reads its value as the kind the field holds.
+func (c *ruleCondition) decode() error {
+	kind, ok := ruleFields[c.Field]
+	if !ok {
+		return fmt.Errorf("unknown field %q", c.Field)
+	}
+
+	switch kind {
+	case "text":
+		switch c.Op {
+		case "=", "!=":
+			var s string
+			if err := json.Unmarshal(c.Value, &s); err != nil {
+				return fmt.Errorf("%s needs a text value", c.Field)
+			}
+			c.text = []string{s}
+		case "in":
+			if err := json.Unmarshal(c.Value, &c.text); err != nil {
+				return fmt.Errorf("%s in needs a list of text values", c.Field)
+			}
+		default:
+			return fmt.Errorf("%s does not support %q", c.Field, c.Op)
+		}
+
+	case "number":
+		switch c.Op {
+		case "=", "!=", "<", "<=", ">", ">=":
+		default:
+			return fmt.Errorf("%s does not support %q", c.Field, c.Op)
+		}
+		if err := json.Unmarshal(c.Value, &c.number); err != nil {
+			return fmt.Errorf("%s needs a number value", c.Field)
+		}
+
+	case "bool":
+		switch c.Op {
+		case "=", "!=":
+		default:
+			return fmt.Errorf("%s does not support %q", c.Field, c.Op)
+		}
+		if err := json.Unmarshal(c.Value, &c.boolean); err != nil {
+			return fmt.Errorf("%s needs true or false", c.Field)
+		}
+	}
+	return nil
+}
+
+// matches reports whether a condition holds for an appointment.
+func (c *ruleCondition) matches(a appointment) bool {
+	switch c.Field {
+	case "species":
+		return c.matchesText(a.pet.species)
+	case "appointmentType":
+		return c.matchesText(a.appointmentType)
+	case "vet":
+		return c.matchesText(a.vet)
+	case "age":
+		return c.matchesNumber(float64(a.pet.age))
+	case "weightKg":
+		return c.matchesNumber(a.pet.weightKg)
+	case "vaccinated":
+		return (a.pet.vaccinated == c.boolean) == (c.Op == "=")
+	}
+	return false
+}
+
+// matchesText tests a text field; names are compared without regard to case.
+func (c *ruleCondition) matchesText(v string) bool {
+	found := slices.ContainsFunc(c.text, func(s string) bool { return strings.EqualFold(s, v) })
+	return found == (c.Op != "!=")
+}
+
+// matchesNumber tests a number field.
+func (c *ruleCondition) matchesNumber(v float64) bool {
+	switch c.Op {
+	case "=":
+		return v == c.number
+	case "!=":
+		return v != c.number
+	case "<":
+		return v < c.number
+	case "<=":
+		return v <= c.number
+	case ">":
+		return v > c.number
+	case ">=":
+		return v >= c.number
+	}
+	return false
+}
+
+// applies reports whether a rule matches an appointment.
+func (r *bookingRule) applies(a appointment) bool {
+	if len(r.Types) > 0 && !slices.Contains(r.Types, a.appointmentType) {
+		return false
+	}
+	for i := range r.When {
+		if !r.When[i].matches(a) {
+			return false
+		}
+	}
+	return true
+}
+
+// evaluateRules checks an appointment against every rule and returns the ones that matched, most serious first.
+func evaluateRules(rules []bookingRule, a appointment) []ruleResult {
+	var results []ruleResult
+	for _, outcome := range ruleOutcomes {
+		for i := range rules {
+			if rules[i].Outcome == outcome && rules[i].applies(a) {
+				results = append(results, ruleResult{rule: rules[i].Name, outcome: outcome, message: rules[i].Message})
+			}
+		}
+	}
+	return results
+}
+
+// blockingResult returns the first result that blocks the booking, if there is one.
+func blockingResult(results []ruleResult) (ruleResult, bool) {
+	for _, r := range results {
+		if r.outcome == "block" {
+			return r, true
+		}
+	}
+	return ruleResult{}, false
+}
+
+// checkBookingRules returns an error wrapping errBookingBlocked if an appointment breaks a blocking rule.
+// It is the check every path that saves a booking goes through, whether or not the user was shown the rules beforehand.
+func checkBookingRules(a appointment) error {
+	if r, blocked := blockingResult(evaluateRules(bookingRules, a)); blocked {
+		return fmt.Errorf("%w: %s", errBookingBlocked, r.message)
+	}
+	return nil
+}
+
+// showRuleResults prints the messages from the rules that matched a booking.
+// true is returned if any of them is a warning.
+func showRuleResults(results []ruleResult) bool {
+	warned := false
+	for _, r := range results {
+		switch r.outcome {
+		case "block":
+			fmt.Println("Not allowed:", r.message)
+		case "warn":
+			fmt.Println("Warning:", r.message)
+			warned = true
+		case "info":
+			fmt.Println("Note:", r.message)
+		}
+	}
+	return warned
+}
+
+// runRulesCommand handles the "rules" command.
+//   - rules list: print the rules that are loaded
+//   - rules check -type Dental -species Dog -age 3 -weight 1.5 -vaccinated: print what the rules say about a booking
+func runRulesCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: rules list|check")
+	}
+
+	switch args[0] {
+	case "list":
+		for _, r := range bookingRules {
+			types := "all types"
+			if len(r.Types) > 0 {
+				types = strings.Join(r.Types, ", ")
+			}
+			fmt.Printf("%-5s %s (%s): %s\n", r.Outcome, r.Name, types, r.Message)
+		}
+		return nil
+
+	case "check":
+		var a appointment
+
+		fs := flag.NewFlagSet("rules check", flag.ContinueOnError)
+		fs.StringVar(&a.appointmentType, "type", "", "appointment type")
+		fs.StringVar(&a.vet, "vet", "", "vet name")
+		fs.StringVar(&a.pet.species, "species", "", "pet species")
+		fs.IntVar(&a.pet.age, "age", 0, "pet age in years")
+		fs.Float64Var(&a.pet.weightKg, "weight", 0, "pet weight in kg")
+		fs.BoolVar(&a.pet.vaccinated, "vaccinated", false, "whether the pet is vaccinated")
+		if err := fs.Parse(args[1:]); err != nil {
+			return err
+		}
+		if !slices.Contains(allowedAppointmentTypes, a.appointmentType) {
+			return fmt.Errorf("-type must be one of %s", strings.Join(allowedAppointmentTypes, ", "))
+		}
+
+		results := evaluateRules(bookingRules, a)
+		if len(results) == 0 {
+			fmt.Println("No rules apply; the booking is allowed.")
+			return nil
+		}
+		showRuleResults(results)
+		if _, blocked := blockingResult(results); blocked {
+			fmt.Println("The booking would be blocked.")
+		}
+		return nil
+
+	default:
+		return fmt.Errorf("unknown rules command %q, expected list or check", args[0])
+	}
+}
@@ -0,0 +1,368 @@
+package main
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// openingHour and closingHour are the hours the clinic takes appointments between.
+// slotLength is the length of one appointment slot in the schedule grid.
+const (
+	openingHour = 9
+	closingHour = 17
+	slotLength  = 30 * time.Minute
+)
+
+// staffPIN is the PIN that must be entered to open the staff menu, read from STAFF_PIN at startup.
+// The staff menu is turned off when it is not set, leaving the staff features available only as commands.
+var staffPIN string
+
+// staffPINAttempts is how many times the PIN can be entered wrongly before the user is sent back to the main menu.
+const staffPINAttempts = 3
+
+// loadStaffPIN reads the staff PIN from the STAFF_PIN environment variable.
+// The PIN must be at least 4 digits long; an empty string is returned when it is not set.
+func loadStaffPIN() (string, error) {
+	pin := os.Getenv("STAFF_PIN")
+	if pin == "" {
+		return "", nil
+	}
+
+	if len(pin) < 4 {
+		return "", fmt.Errorf("STAFF_PIN must be at least 4 digits long")
+	}
+	for _, c := range pin {
+		if c < '0' || c > '9' {
+			return "", fmt.Errorf("STAFF_PIN can only have digits 0-9")
+		}
+	}
+	return pin, nil
+}
+
+// checkStaffPIN asks for the staff PIN before the staff menu is opened.
+// true is returned once the right PIN is entered; false if the staff menu is turned off or every attempt was wrong.
+func checkStaffPIN(scanner *bufio.Scanner) bool {
+	if staffPIN == "" {
+		fmt.Println("The staff menu is turned off. Set STAFF_PIN in .env to use it.")
+		return false
+	}
+
+	for i := 0; i < staffPINAttempts; i++ {
+		fmt.Println("Please enter the staff PIN:")
+		fmt.Print("> ")
+
+		scanner.Scan()
+		input := strings.TrimSpace(scanner.Text())
+		if subtle.ConstantTimeCompare([]byte(input), []byte(staffPIN)) == 1 {
+			return true
+		}
+		fmt.Println("Error: incorrect PIN")
+	}
+	return false
+}
+
+// staffMenu is a function that displays the staff menu screen with the options available to clinic staff.
+// The option that the user selects is normalised and then passed to runStaffMenu().
+func staffMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. View vet schedule")
+	fmt.Println("2. Search appointments")
+	fmt.Println("3. Waitlist")
+	fmt.Println("4. Vaccinations")
+	fmt.Println("5. Complete an appointment")
+	fmt.Println("6. Invoices")
+	fmt.Println("7. Payments")
+	fmt.Println("8. Reception")
+	fmt.Println("9. Emergency booking")
+	fmt.Println("10. Clinical notes")
+	fmt.Println("11. Prescriptions")
+	fmt.Println("12. Controlled drugs")
+	fmt.Println("13. Inventory")
+	fmt.Println("14. Documents")
+	fmt.Println("15. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// runStaffMenu keeps showing the staff menu until the user chooses to go back to the main menu.
+func runStaffMenu(scanner *bufio.Scanner, db *sql.DB, channels []channel) {
+	for {
+		switch staffMenu(scanner) {
+		case "1":
+			viewSchedule(scanner, db)
+
+		case "2":
+			runSearch(scanner, db)
+
+		case "3":
+			manageWaitlist(scanner, db)
+
+		case "4":
+			manageVaccinations(scanner, db)
+
+		case "5":
+			completeAppointment(scanner, db)
+
+		case "6":
+			viewInvoice(scanner, db)
+
+		case "7":
+			managePayments(scanner, db)
+
+		case "8":
+			runReception(scanner, db)
+
+		case "9":
+			bookEmergency(scanner, db, channels)
+
+		case "10":
+			manageNotes(scanner, db)
+
+		case "11":
+			managePrescriptions(scanner, db)
+
+		case "12":
+			manageControlledDrugs(scanner, db)
+
+		case "13":
+			manageInventory(scanner, db)
+
+		case "14":
+			manageDocuments(scanner, db)
+
+		case "15":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// getAppointmentsByVet fetches every appointment for a vet between from (inclusive) and to (exclusive), ordered by time.
+// Cancelled appointments are left out, as they no longer take up a slot.
+// An empty vet name fetches the appointments of all vets.
+func getAppointmentsByVet(db *sql.DB, vet string, from, to time.Time) ([]appointment, error) {
+	if vet == "" {
+		return queryAppointments(db,
+			`WHERE a.status <> 'Cancelled' AND a.appointment_time >= $1 AND a.appointment_time < $2
+			 ORDER BY a.vet_name, a.appointment_time`,
+			from, to,
+		)
+	}
+
+	return queryAppointments(db,
+		`WHERE a.vet_name = $1 AND a.status <> 'Cancelled' AND a.appointment_time >= $2 AND a.appointment_time < $3
+		 ORDER BY a.appointment_time`,
+		vet, from, to,
+	)
+}
+
+// getScheduleVet is a helper function that prompts the user to pick a vet from "allowedVets", or all vets.
+// An empty string is returned when all vets are chosen.
+func getScheduleVet(scanner *bufio.Scanner) (string, error) {
+	fmt.Println("Please choose a vet:")
+
+	for i, v := range allowedVets {
+		fmt.Printf("%d. %s\n", i+1, v)
+	}
+	fmt.Printf("%d. All vets\n", len(allowedVets)+1)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > len(allowedVets)+1 {
+		return "", fmt.Errorf("please select one of the vets displayed")
+	}
+
+	if choice == len(allowedVets)+1 {
+		return "", nil
+	}
+	return allowedVets[choice-1], nil
+}
+
+// getSchedulePeriod is a helper function that asks whether the schedule should cover a day or a week.
+// The number of days in the chosen period is returned.
+func getSchedulePeriod(scanner *bufio.Scanner) (int, error) {
+	fmt.Println("Show schedule for:")
+	fmt.Println("1. One day")
+	fmt.Println("2. One week")
+	fmt.Print("> ")
+
+	scanner.Scan()
+
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		return 1, nil
+	case "2":
+		return 7, nil
+	default:
+		return 0, fmt.Errorf("please select 1 or 2")
+	}
+}
+
+// getScheduleStartDate is a helper function that prompts for the first day of the schedule.
+// An empty input means today.
+func getScheduleStartDate(scanner *bufio.Scanner) (time.Time, error) {
+	fmt.Println("Please enter the start date (YYYY-MM-DD), or leave blank for today:")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if input == "" {
+		return clinicToday(), nil
+	}
+
+	d, err := time.ParseInLocation("2006-01-02", input, clinicLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date format")
+	}
+	return d, nil
+}
+
+// viewSchedule gathers the vet, period and start date for a schedule and prints it.
+func viewSchedule(scanner *bufio.Scanner, db *sql.DB) {
+	var vet string
+	for {
+		v, err := getScheduleVet(scanner)
+		if err == nil {
+			vet = v
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var days int
+	for {
+		d, err := getSchedulePeriod(scanner)
+		if err == nil {
+			days = d
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var start time.Time
+	for {
+		s, err := getScheduleStartDate(scanner)
+		if err == nil {
+			start = s
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	end := start.AddDate(0, 0, days)
+
+	appts, err := getAppointmentsByVet(db, vet, start, end)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if err := attachAnswers(db, appts); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	vets := allowedVets
+	if vet != "" {
+		vets = []string{vet}
+	}
+
+	reserved, err := loadEmergencySlots()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	for day := start; day.Before(end); day = day.AddDate(0, 0, 1) {
+		for _, v := range vets {
+			fmt.Print(scheduleString(v, day, appts, reserved))
+		}
+	}
+}
+
+// scheduleString prints a time-grid of one vet's appointments for one day.
+// Every slot between openingHour and closingHour is listed so gaps in the day are visible, with free slots reserved for emergencies marked.
+// Appointments that fall outside the clinic's hours are listed underneath the grid, and each appointment's questionnaire answers are listed under it.
+func scheduleString(vet string, day time.Time, appts []appointment, reserved []clockTime) string {
+	open := time.Date(day.Year(), day.Month(), day.Day(), openingHour, 0, 0, 0, day.Location())
+	closing := time.Date(day.Year(), day.Month(), day.Day(), closingHour, 0, 0, 0, day.Location())
+	dayEnd := day.AddDate(0, 0, 1)
+
+	var s string
+	s = "=====================================\n"
+	s += fmt.Sprintf("%s - %s\n", vet, day.Format("Monday, 02 Jan 2006"))
+	s += "=====================================\n"
+
+	for slot := open; slot.Before(closing); slot = slot.Add(slotLength) {
+		var booked []appointment
+		for _, a := range appts {
+			t := a.dateTime.In(day.Location())
+			if a.vet == vet && !t.Before(slot) && t.Before(slot.Add(slotLength)) {
+				booked = append(booked, a)
+			}
+		}
+
+		if len(booked) == 0 {
+			if isEmergencySlot(slot, reserved) {
+				s += fmt.Sprintf("%s | -- reserved for emergencies --\n", slot.Format("15:04"))
+				continue
+			}
+			s += fmt.Sprintf("%s | -- free --\n", slot.Format("15:04"))
+			continue
+		}
+		for _, a := range booked {
+			s += fmt.Sprintf("%s | %s\n", a.dateTime.In(day.Location()).Format("15:04"), a.scheduleLine())
+			s += answersString(a.answers)
+		}
+	}
+
+	var outside []appointment
+	for _, a := range appts {
+		t := a.dateTime.In(day.Location())
+		if a.vet != vet || t.Before(day) || !t.Before(dayEnd) {
+			continue
+		}
+		if t.Before(open) || !t.Before(closing) {
+			outside = append(outside, a)
+		}
+	}
+
+	if len(outside) > 0 {
+		s += "Outside clinic hours:\n"
+		for _, a := range outside {
+			s += fmt.Sprintf("%s | %s\n", a.dateTime.In(day.Location()).Format("15:04"), a.scheduleLine())
+			s += answersString(a.answers)
+		}
+	}
+
+	return s
+}
+
+// scheduleLine prints the pet, species, appointment type and owner of an appointment on one line.
+// Emergency bookings are marked with their triage level.
+func (a *appointment) scheduleLine() string {
+	triage := ""
+	if a.triageLevel != "" {
+		triage = "[" + a.triageLevel + "] "
+	}
+	return fmt.Sprintf("%s%s (%s) - %s - Owner: %s %s",
+		triage,
+		a.pet.name,
+		a.pet.species,
+		a.appointmentType,
+		a.owner.firstName,
+		a.owner.lastName,
+	)
+}
@@ -7,6 +7,15 @@ CREATE TABLE users (
     CONSTRAINT users_email_unique_ci UNIQUE (email)
 );
 
+-- A series groups appointments that were booked together to repeat on a schedule.
+-- rule holds the repeat as an RRULE, such as FREQ=WEEKLY;INTERVAL=3;COUNT=4.
+CREATE TABLE appointment_series (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    rule TEXT NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now()
+);
+
 CREATE TABLE appointments (
     id SERIAL PRIMARY KEY,
     user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
@@ -18,8 +27,431 @@ CREATE TABLE appointments (
     appointment_type TEXT NOT NULL,
     vet_name TEXT NOT NULL,
     appointment_time TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'Booked',
+    series_id INTEGER REFERENCES appointment_series(id) ON DELETE SET NULL,
+    -- The price quoted when the appointment was booked, in minor units (pence, cents) before tax.
+    price INTEGER NOT NULL DEFAULT 0,
+    cancelled_at TIMESTAMPTZ,
+    -- Set when the appointment was cancelled with less notice than the cancellation policy allows for free.
+    late_cancellation BOOLEAN NOT NULL DEFAULT false,
+    -- Set by reception when the pet arrives, and when the vet calls them in.
+    checked_in_at TIMESTAMPTZ,
+    started_at TIMESTAMPTZ,
+    -- Set for appointments booked through the emergency path; NULL for routine bookings.
+    triage_level TEXT,
+    -- Set when the appointment type needs the owner's consent, which must be on record before the pet is admitted.
+    consent_required BOOLEAN NOT NULL DEFAULT false,
+    -- Whether the owner may see the signed clinical notes for this appointment.
+    notes_shared BOOLEAN NOT NULL DEFAULT false,
 
     CONSTRAINT pet_age_positive CHECK (pet_age >= 0),
     CONSTRAINT pet_weight_positive CHECK (pet_weight > 0),
-    CONSTRAINT appointment_in_future CHECK (appointment_time > now())
+    CONSTRAINT appointment_status_valid CHECK (status IN ('Booked', 'Checked-in', 'In progress', 'Cancelled', 'Completed', 'No-show')),
+    CONSTRAINT appointment_cancelled_at CHECK ((status = 'Cancelled') = (cancelled_at IS NOT NULL)),
+    CONSTRAINT late_cancellation_cancelled CHECK (NOT late_cancellation OR status = 'Cancelled'),
+    CONSTRAINT started_after_check_in CHECK (started_at IS NULL OR checked_in_at IS NOT NULL),
+    CONSTRAINT completed_after_check_in CHECK (status <> 'Completed' OR checked_in_at IS NOT NULL),
+    CONSTRAINT appointment_triage_valid CHECK (triage_level IN ('Emergency', 'Urgent'))
+);
+
+CREATE INDEX appointments_time_idx ON appointments (appointment_time);
+CREATE INDEX appointments_user_status_idx ON appointments (user_id, status);
+CREATE INDEX appointments_series_idx ON appointments (series_id) WHERE series_id IS NOT NULL;
+
+-- A vet cannot have two live appointments starting at the same time.
+CREATE UNIQUE INDEX appointments_vet_slot_unique ON appointments (vet_name, appointment_time) WHERE status <> 'Cancelled';
+
+-- Appointments can only be booked, or moved, into the future. This is a trigger rather than a CHECK so that past appointments
+-- can still be checked in and completed. Emergencies may take the slot that is running now, so they get one slot's grace.
+CREATE FUNCTION check_appointment_in_future() RETURNS trigger AS $$
+BEGIN
+    IF NEW.appointment_time <= now() - CASE WHEN NEW.triage_level IS NULL THEN interval '0' ELSE interval '30 minutes' END THEN
+        RAISE EXCEPTION 'appointments cannot be booked in the past (%)', NEW.appointment_time;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER appointment_in_future
+    BEFORE INSERT OR UPDATE OF appointment_time ON appointments
+    FOR EACH ROW EXECUTE FUNCTION check_appointment_in_future();
+
+CREATE TABLE outbox (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    appointment_id INTEGER REFERENCES appointments(id) ON DELETE CASCADE,
+    -- The appointment time a reminder was written for; a reminder is skipped if the appointment has moved since.
+    appointment_time TIMESTAMPTZ,
+    kind TEXT NOT NULL,
+    channel TEXT NOT NULL,
+    recipient TEXT NOT NULL,
+    subject TEXT NOT NULL,
+    body TEXT NOT NULL,
+    html_body TEXT,
+    dedupe_key TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'Pending',
+    attempts INTEGER NOT NULL DEFAULT 0,
+    next_attempt_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    last_error TEXT,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    sent_at TIMESTAMPTZ,
+
+    CONSTRAINT outbox_dedupe_unique UNIQUE (dedupe_key),
+    CONSTRAINT outbox_status_valid CHECK (status IN ('Pending', 'Sent', 'Failed', 'Skipped'))
+);
+
+CREATE INDEX outbox_pending_idx ON outbox (next_attempt_at) WHERE status = 'Pending';
+
+CREATE TABLE waitlist (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    pet_name TEXT NOT NULL,
+    pet_species TEXT NOT NULL,
+    pet_age INTEGER NOT NULL,
+    pet_weight REAL NOT NULL,
+    vaccinated BOOLEAN NOT NULL,
+    appointment_type TEXT NOT NULL,
+    vet_name TEXT NOT NULL,
+    window_start TIMESTAMPTZ NOT NULL,
+    window_end TIMESTAMPTZ NOT NULL,
+    priority INTEGER NOT NULL DEFAULT 0,
+    status TEXT NOT NULL DEFAULT 'Waiting',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT waitlist_window_valid CHECK (window_end > window_start),
+    CONSTRAINT waitlist_status_valid CHECK (status IN ('Waiting', 'Booked', 'Removed'))
+);
+
+CREATE TABLE waitlist_offers (
+    id SERIAL PRIMARY KEY,
+    waitlist_id INTEGER NOT NULL REFERENCES waitlist(id) ON DELETE CASCADE,
+    vet_name TEXT NOT NULL,
+    offered_time TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    status TEXT NOT NULL DEFAULT 'Offered',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT waitlist_offer_status_valid CHECK (status IN ('Offered', 'Accepted', 'Declined', 'Expired'))
+);
+
+CREATE INDEX waitlist_offers_slot_idx ON waitlist_offers (vet_name, offered_time) WHERE status = 'Offered';
+
+-- Patients who arrive without an appointment, waiting to be seen.
+-- user_id is set when the owner has an account.
+CREATE TABLE walk_ins (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER REFERENCES users(id) ON DELETE SET NULL,
+    owner_name TEXT NOT NULL,
+    phone TEXT NOT NULL,
+    pet_name TEXT NOT NULL,
+    pet_species TEXT NOT NULL,
+    reason TEXT NOT NULL,
+    priority TEXT NOT NULL,
+    status TEXT NOT NULL DEFAULT 'Waiting',
+    vet_name TEXT,
+    arrived_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    seen_at TIMESTAMPTZ,
+
+    CONSTRAINT walk_in_priority_valid CHECK (priority IN ('Emergency', 'Urgent', 'Soon', 'Routine')),
+    CONSTRAINT walk_in_status_valid CHECK (status IN ('Waiting', 'Seen', 'Left')),
+    CONSTRAINT walk_in_seen CHECK ((status = 'Waiting') = (seen_at IS NULL)),
+    CONSTRAINT walk_in_vet CHECK ((status = 'Seen') = (vet_name IS NOT NULL))
+);
+
+CREATE INDEX walk_ins_arrived_idx ON walk_ins (arrived_at);
+
+CREATE TABLE slot_holds (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    vet_name TEXT NOT NULL,
+    appointment_time TIMESTAMPTZ NOT NULL,
+    expires_at TIMESTAMPTZ NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT slot_holds_slot_unique UNIQUE (vet_name, appointment_time)
+);
+
+-- Vaccines given to a pet. Pets have no table of their own, so they are identified by owner and name.
+CREATE TABLE vaccinations (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    pet_name TEXT NOT NULL,
+    pet_species TEXT NOT NULL,
+    vaccine TEXT NOT NULL,
+    given_on DATE NOT NULL,
+    batch_number TEXT NOT NULL DEFAULT '',
+    next_due DATE NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT vaccination_due_after_given CHECK (next_due > given_on)
+);
+
+CREATE INDEX vaccinations_pet_idx ON vaccinations (user_id, lower(pet_name));
+CREATE INDEX vaccinations_due_idx ON vaccinations (next_due);
+
+-- Invoices are numbered from their own sequence, so numbers are never reused even if an invoice is rolled back.
+CREATE SEQUENCE invoice_number_seq;
+
+-- Amounts are in minor units (pence, cents). The discount is taken off the subtotal before tax is added.
+CREATE TABLE invoices (
+    id SERIAL PRIMARY KEY,
+    invoice_number TEXT NOT NULL,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id),
+    user_id INTEGER NOT NULL REFERENCES users(id),
+    currency TEXT NOT NULL,
+    subtotal INTEGER NOT NULL,
+    discount INTEGER NOT NULL DEFAULT 0,
+    discount_reason TEXT NOT NULL DEFAULT '',
+    tax_percent NUMERIC(5, 2) NOT NULL,
+    tax INTEGER NOT NULL,
+    total INTEGER NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    -- A deposit invoice is issued when the appointment is booked; the final invoice, or a fee, is issued later.
+    deposit BOOLEAN NOT NULL DEFAULT false,
+
+    CONSTRAINT invoices_number_unique UNIQUE (invoice_number),
+    CONSTRAINT invoices_appointment_unique UNIQUE (appointment_id, deposit),
+    CONSTRAINT invoice_discount_valid CHECK (discount >= 0 AND discount <= subtotal),
+    CONSTRAINT invoice_total_valid CHECK (total = subtotal - discount + tax)
+);
+
+CREATE TABLE invoice_lines (
+    id SERIAL PRIMARY KEY,
+    invoice_id INTEGER NOT NULL REFERENCES invoices(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    description TEXT NOT NULL,
+    quantity INTEGER NOT NULL,
+    unit_price INTEGER NOT NULL,
+    amount INTEGER NOT NULL,
+
+    CONSTRAINT invoice_line_quantity_positive CHECK (quantity > 0),
+    CONSTRAINT invoice_line_amount_valid CHECK (amount = quantity * unit_price)
+);
+
+-- Every deposit, payment, refund and retained amount, in minor units. Amounts are always positive; the kind says which way the money went.
+-- Retained records the part of a payment the clinic keeps under the cancellation policy, so it no longer counts as the owner's credit.
+CREATE TABLE payments (
+    id SERIAL PRIMARY KEY,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    appointment_id INTEGER REFERENCES appointments(id),
+    invoice_id INTEGER REFERENCES invoices(id),
+    kind TEXT NOT NULL,
+    amount INTEGER NOT NULL,
+    method TEXT NOT NULL DEFAULT '',
+    reference TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT payment_kind_valid CHECK (kind IN ('Deposit', 'Payment', 'Refund', 'Retained')),
+    CONSTRAINT payment_amount_positive CHECK (amount > 0),
+    CONSTRAINT payment_method_valid CHECK (
+        (kind = 'Retained' AND method = '') OR (kind <> 'Retained' AND method IN ('Card', 'Cash', 'Bank transfer'))
+    ),
+    CONSTRAINT deposit_has_appointment CHECK (kind <> 'Deposit' OR appointment_id IS NOT NULL)
+);
+
+CREATE INDEX payments_user_idx ON payments (user_id, created_at);
+CREATE INDEX payments_appointment_idx ON payments (appointment_id) WHERE appointment_id IS NOT NULL;
+CREATE INDEX payments_invoice_idx ON payments (invoice_id) WHERE invoice_id IS NOT NULL;
+
+-- Vets' SOAP notes for an appointment. Each change after signing is a new version with the reason for the amendment,
+-- so the full history of the clinical record is kept. An appointment can have at most one unsigned draft at a time.
+CREATE TABLE clinical_notes (
+    id SERIAL PRIMARY KEY,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id),
+    version INTEGER NOT NULL,
+    subjective TEXT NOT NULL DEFAULT '',
+    objective TEXT NOT NULL DEFAULT '',
+    assessment TEXT NOT NULL DEFAULT '',
+    plan TEXT NOT NULL DEFAULT '',
+    author TEXT NOT NULL,
+    amendment_reason TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+    signed_at TIMESTAMPTZ,
+
+    CONSTRAINT clinical_note_version_unique UNIQUE (appointment_id, version),
+    CONSTRAINT clinical_note_version_positive CHECK (version > 0),
+    CONSTRAINT clinical_note_amendment_reason CHECK (version = 1 OR amendment_reason <> '')
+);
+
+CREATE UNIQUE INDEX clinical_notes_one_draft ON clinical_notes (appointment_id) WHERE signed_at IS NULL;
+
+-- Signed notes are part of the clinical record and can never be changed or deleted.
+CREATE FUNCTION protect_signed_notes() RETURNS trigger AS $$
+BEGIN
+    IF OLD.signed_at IS NOT NULL THEN
+        RAISE EXCEPTION 'version % of the notes for appointment % is signed and cannot be changed', OLD.version, OLD.appointment_id;
+    END IF;
+    IF TG_OP = 'DELETE' THEN
+        RETURN OLD;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER clinical_notes_signed
+    BEFORE UPDATE OR DELETE ON clinical_notes
+    FOR EACH ROW EXECUTE FUNCTION protect_signed_notes();
+
+CREATE TABLE prescriptions (
+    id SERIAL PRIMARY KEY,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id),
+    drug TEXT NOT NULL,
+    form TEXT NOT NULL,
+    unit TEXT NOT NULL,
+    strength_mg DOUBLE PRECISION NOT NULL,
+    dose_amount DOUBLE PRECISION NOT NULL,
+    dose_mg DOUBLE PRECISION NOT NULL,
+    weight_kg REAL NOT NULL,
+    frequency TEXT NOT NULL,
+    duration_days INTEGER NOT NULL,
+    quantity DOUBLE PRECISION NOT NULL,
+    instructions TEXT NOT NULL DEFAULT '',
+    prescribed_by TEXT NOT NULL,
+    prescribed_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT prescription_unit CHECK (unit IN ('tablet', 'capsule', 'ml')),
+    CONSTRAINT prescription_frequency CHECK (frequency IN ('Once daily', 'Twice daily', 'Three times daily', 'Four times daily')),
+    CONSTRAINT prescription_dose_positive CHECK (strength_mg > 0 AND dose_amount > 0 AND dose_mg > 0 AND weight_kg > 0),
+    CONSTRAINT prescription_duration CHECK (duration_days BETWEEN 1 AND 365),
+    CONSTRAINT prescription_quantity_positive CHECK (quantity > 0)
+);
+
+CREATE INDEX prescriptions_appointment_idx ON prescriptions (appointment_id);
+
+-- The controlled drug register is a legal record, so entries can only ever be added.
+CREATE TABLE controlled_drug_register (
+    id SERIAL PRIMARY KEY,
+    drug TEXT NOT NULL,
+    unit TEXT NOT NULL,
+    kind TEXT NOT NULL,
+    quantity NUMERIC(10, 2) NOT NULL,
+    appointment_id INTEGER REFERENCES appointments(id),
+    reference TEXT NOT NULL DEFAULT '',
+    recorded_by TEXT NOT NULL,
+    witness TEXT NOT NULL DEFAULT '',
+    recorded_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT register_kind CHECK (kind IN ('Received', 'Administered', 'Destroyed', 'Stock check')),
+    CONSTRAINT register_quantity CHECK (quantity > 0 OR (kind = 'Stock check' AND quantity = 0)),
+    CONSTRAINT register_administered_appointment CHECK ((kind = 'Administered') = (appointment_id IS NOT NULL)),
+    CONSTRAINT register_destroyed_witness CHECK (kind <> 'Destroyed' OR (witness <> '' AND lower(witness) <> lower(recorded_by)))
+);
+
+CREATE INDEX controlled_drug_register_drug_idx ON controlled_drug_register (drug, recorded_at);
+CREATE INDEX controlled_drug_register_appointment_idx ON controlled_drug_register (appointment_id) WHERE appointment_id IS NOT NULL;
+
+CREATE FUNCTION protect_register() RETURNS trigger AS $$
+BEGIN
+    RAISE EXCEPTION 'the controlled drug register is append-only; record a new entry instead';
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER controlled_drug_register_append_only
+    BEFORE UPDATE OR DELETE ON controlled_drug_register
+    FOR EACH ROW EXECUTE FUNCTION protect_register();
+
+CREATE TRIGGER controlled_drug_register_no_truncate
+    BEFORE TRUNCATE ON controlled_drug_register
+    FOR EACH STATEMENT EXECUTE FUNCTION protect_register();
+
+CREATE TABLE inventory_items (
+    id SERIAL PRIMARY KEY,
+    name TEXT NOT NULL UNIQUE,
+    kind TEXT NOT NULL,
+    vaccine TEXT,
+    used_for TEXT,
+    per_use INTEGER NOT NULL DEFAULT 1,
+    reorder_level INTEGER NOT NULL DEFAULT 0,
+
+    CONSTRAINT inventory_item_kind CHECK (
+        (kind = 'Vaccine' AND vaccine IS NOT NULL AND used_for IS NULL)
+        OR (kind = 'Consumable' AND vaccine IS NULL AND used_for IN ('Grooming', 'Vaccination', 'Surgical', 'Bath', 'Dental'))
+    ),
+    CONSTRAINT inventory_item_per_use CHECK (per_use > 0),
+    CONSTRAINT inventory_item_reorder_level CHECK (reorder_level >= 0)
+);
+
+CREATE TABLE inventory_batches (
+    id SERIAL PRIMARY KEY,
+    item_id INTEGER NOT NULL REFERENCES inventory_items(id),
+    batch_number TEXT NOT NULL,
+    expires_on DATE NOT NULL,
+    quantity_received INTEGER NOT NULL,
+    quantity_left INTEGER NOT NULL,
+    received_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT inventory_batch_quantity CHECK (quantity_received > 0 AND quantity_left BETWEEN 0 AND quantity_received)
+);
+
+CREATE INDEX inventory_batches_item_idx ON inventory_batches (item_id, expires_on) WHERE quantity_left > 0;
+
+-- Every unit taken out of a batch, either used at an appointment or written off.
+CREATE TABLE inventory_usage (
+    id SERIAL PRIMARY KEY,
+    batch_id INTEGER NOT NULL REFERENCES inventory_batches(id),
+    appointment_id INTEGER REFERENCES appointments(id),
+    quantity INTEGER NOT NULL,
+    reason TEXT NOT NULL,
+    used_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT inventory_usage_quantity_positive CHECK (quantity > 0),
+    CONSTRAINT inventory_usage_reason CHECK (
+        (reason = 'Used' AND appointment_id IS NOT NULL) OR (reason = 'Written off' AND appointment_id IS NULL)
+    )
+);
+
+CREATE INDEX inventory_usage_appointment_idx ON inventory_usage (appointment_id) WHERE appointment_id IS NOT NULL;
+
+-- An owner's consent to the procedure booked at an appointment, with the form exactly as they saw it.
+CREATE TABLE consents (
+    id SERIAL PRIMARY KEY,
+    appointment_id INTEGER NOT NULL UNIQUE REFERENCES appointments(id),
+    form_text TEXT NOT NULL,
+    signed_name TEXT NOT NULL,
+    captured_by TEXT NOT NULL,
+    consented_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT consent_signed CHECK (signed_name <> ''),
+    CONSTRAINT consent_captured_by CHECK (captured_by IN ('Owner', 'Reception'))
+);
+
+-- A pet cannot be checked in, called through or have its appointment completed without consent when its appointment needs it.
+CREATE FUNCTION check_consent_given() RETURNS trigger AS $$
+BEGIN
+    IF NEW.consent_required AND NEW.status IN ('Checked-in', 'In progress', 'Completed') AND NEW.status IS DISTINCT FROM OLD.status
+       AND NOT EXISTS (SELECT 1 FROM consents WHERE appointment_id = NEW.id) THEN
+        RAISE EXCEPTION '% appointment % needs the owner''s consent on record first', NEW.appointment_type, NEW.id;
+    END IF;
+    RETURN NEW;
+END;
+$$ LANGUAGE plpgsql;
+
+CREATE TRIGGER appointment_consent_given
+    BEFORE UPDATE OF status ON appointments
+    FOR EACH ROW EXECUTE FUNCTION check_consent_given();
+
+-- The owner's answers to the questionnaire for an appointment's type, in the order the questions were asked.
+CREATE TABLE appointment_answers (
+    id SERIAL PRIMARY KEY,
+    appointment_id INTEGER NOT NULL REFERENCES appointments(id) ON DELETE CASCADE,
+    position INTEGER NOT NULL,
+    question TEXT NOT NULL,
+    answer TEXT NOT NULL,
+
+    CONSTRAINT appointment_answer_position UNIQUE (appointment_id, position)
+);
+
+-- Certificates and confirmation cards exactly as they were issued, looked up by their verification code.
+CREATE TABLE documents (
+    id SERIAL PRIMARY KEY,
+    code TEXT NOT NULL UNIQUE,
+    kind TEXT NOT NULL,
+    user_id INTEGER NOT NULL REFERENCES users(id) ON DELETE CASCADE,
+    pet_name TEXT NOT NULL,
+    appointment_id INTEGER REFERENCES appointments(id) ON DELETE CASCADE,
+    body TEXT NOT NULL,
+    issued_at TIMESTAMPTZ NOT NULL DEFAULT now(),
+
+    CONSTRAINT document_kind_valid CHECK (kind IN ('Vaccination certificate', 'Appointment confirmation')),
+    CONSTRAINT document_card_appointment CHECK ((kind = 'Appointment confirmation') = (appointment_id IS NOT NULL))
 );
@@ -0,0 +1,438 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// pageSize is the number of appointments shown on one page of results.
+const pageSize = 10
+
+// appointmentFormat selects how a list of appointments is printed.
+type appointmentFormat int
+
+const (
+	// detailedFormat prints each appointment as a full summary block.
+	detailedFormat appointmentFormat = iota
+	// compactFormat prints each appointment on a single line.
+	compactFormat
+)
+
+// appointmentFilter is a struct that holds the search filters chosen by the user.
+// Empty fields are not filtered on, so any combination of filters can be used together.
+type appointmentFilter struct {
+	petName     string
+	ownerLast   string
+	email       string
+	phone       string
+	vet         string
+	species     string
+	appointType string
+	status      string
+	from        time.Time
+	to          time.Time
+}
+
+// likeEscaper escapes the LIKE wildcards in search text, so "_" and "%" only match themselves.
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// likeEscape escapes search text for use in a LIKE pattern with ESCAPE '\'.
+func likeEscape(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+// where builds the WHERE clause and placeholder values for the filters that are set.
+func (f *appointmentFilter) where() (string, []any) {
+	var conditions []string
+	var args []any
+
+	add := func(condition string, value any) {
+		args = append(args, value)
+		conditions = append(conditions, fmt.Sprintf(condition, len(args)))
+	}
+
+	if f.petName != "" {
+		add(`a.pet_name ILIKE '%%' || $%d || '%%' ESCAPE '\'`, likeEscape(f.petName))
+	}
+	if f.ownerLast != "" {
+		add(`u.last_name ILIKE '%%' || $%d || '%%' ESCAPE '\'`, likeEscape(f.ownerLast))
+	}
+	if f.email != "" {
+		add(`u.email ILIKE '%%' || $%d || '%%' ESCAPE '\'`, likeEscape(f.email))
+	}
+	if f.phone != "" {
+		add(`u.phone LIKE '%%' || $%d || '%%' ESCAPE '\'`, likeEscape(f.phone))
+	}
+	if f.vet != "" {
+		add(`a.vet_name = $%d`, f.vet)
+	}
+	if f.species != "" {
+		add(`a.pet_species = $%d`, f.species)
+	}
+	if f.appointType != "" {
+		add(`a.appointment_type = $%d`, f.appointType)
+	}
+	if f.status != "" {
+		add(`a.status = $%d`, f.status)
+	}
+	if !f.from.IsZero() {
+		add(`a.appointment_time >= $%d`, f.from)
+	}
+	if !f.to.IsZero() {
+		add(`a.appointment_time < $%d`, f.to)
+	}
+
+	if len(conditions) == 0 {
+		return "", nil
+	}
+	return "WHERE " + strings.Join(conditions, " AND ") + "\n", args
+}
+
+// summaryString prints the filters that are currently set.
+func (f *appointmentFilter) summaryString() string {
+	var s string
+	s = "Current filters:\n"
+
+	set := false
+	line := func(label, value string) {
+		if value != "" {
+			s += fmt.Sprintf("  %s: %s\n", label, value)
+			set = true
+		}
+	}
+
+	line("Pet name", f.petName)
+	line("Owner surname", f.ownerLast)
+	line("Email", f.email)
+	line("Phone", f.phone)
+	line("Vet", f.vet)
+	line("Species", f.species)
+	line("Appointment type", f.appointType)
+	line("Status", f.status)
+	if !f.from.IsZero() {
+		line("From", f.from.Format("2006-01-02"))
+	}
+	if !f.to.IsZero() {
+		line("To", f.to.AddDate(0, 0, -1).Format("2006-01-02"))
+	}
+
+	if !set {
+		s += "  (none)\n"
+	}
+	return s
+}
+
+// countAppointments returns how many appointments match the given WHERE clause.
+func countAppointments(db *sql.DB, where string, args ...any) (int, error) {
+	var count int
+	err := db.QueryRow(
+		`SELECT COUNT(*)
+		 FROM appointments a
+		 JOIN users u ON u.id = a.user_id
+		 `+where,
+		args...,
+	).Scan(&count)
+	return count, err
+}
+
+// searchAppointments fetches one page of appointments matching the filter, ordered by appointment time.
+func searchAppointments(db *sql.DB, f *appointmentFilter, offset int) ([]appointment, error) {
+	where, args := f.where()
+	args = append(args, pageSize, offset)
+
+	return queryAppointments(db,
+		where+fmt.Sprintf("ORDER BY a.appointment_time, a.id LIMIT $%d OFFSET $%d", len(args)-1, len(args)),
+		args...,
+	)
+}
+
+// compactString prints an appointment's details on a single line.
+func (a *appointment) compactString(i int) string {
+	return fmt.Sprintf("%d. %s | %s | %s (%s) | %s | Owner: %s %s | %s",
+		i,
+		a.dateTime.In(clinicLocation).Format("2006-01-02 15:04"),
+		a.vet,
+		a.pet.name,
+		a.pet.species,
+		a.appointmentType,
+		a.owner.firstName,
+		a.owner.lastName,
+		a.status,
+	)
+}
+
+// printAppointments prints a list of appointments in the chosen format.
+// offset is the number of appointments shown on earlier pages, so numbering carries on between pages.
+func printAppointments(appts []appointment, offset int, format appointmentFormat) {
+	for i, a := range appts {
+		switch format {
+		case compactFormat:
+			fmt.Println(a.compactString(offset + i + 1))
+		default:
+			fmt.Println(a.summaryString(offset + i + 1))
+		}
+	}
+}
+
+// getAppointmentFormat is a helper function that prompts the user to choose how results are printed.
+func getAppointmentFormat(scanner *bufio.Scanner) (appointmentFormat, error) {
+	fmt.Println("Please choose an output format:")
+	fmt.Println("1. Detailed")
+	fmt.Println("2. Compact")
+	fmt.Print("> ")
+
+	scanner.Scan()
+
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		return detailedFormat, nil
+	case "2":
+		return compactFormat, nil
+	default:
+		return 0, fmt.Errorf("please select 1 or 2")
+	}
+}
+
+// pageAppointments shows the results of fetch one page at a time.
+// total is the number of results across all pages.
+// The user moves between pages until they choose to go back.
+func pageAppointments(scanner *bufio.Scanner, total int, format appointmentFormat, fetch func(offset int) ([]appointment, error)) {
+	offset := 0
+
+	for {
+		appts, err := fetch(offset)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		printAppointments(appts, offset, format)
+
+		pages := (total + pageSize - 1) / pageSize
+		fmt.Printf("Page %d of %d (%d results)\n", offset/pageSize+1, pages, total)
+
+		if pages <= 1 {
+			return
+		}
+
+		fmt.Println("n = next page, p = previous page, q = back")
+		fmt.Print("> ")
+		scanner.Scan()
+
+		switch strings.ToLower(strings.TrimSpace(scanner.Text())) {
+		case "n":
+			if offset+pageSize < total {
+				offset += pageSize
+			} else {
+				fmt.Println("Already on the last page.")
+			}
+		case "p":
+			if offset > 0 {
+				offset -= pageSize
+			} else {
+				fmt.Println("Already on the first page.")
+			}
+		case "q":
+			return
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// searchMenu is a function that displays the search filters and the actions available on them.
+// The option that the user selects is normalised and then passed to runSearch().
+func searchMenu(scanner *bufio.Scanner, f *appointmentFilter) string {
+	fmt.Print(f.summaryString())
+	fmt.Println("1. Pet name")
+	fmt.Println("2. Owner surname")
+	fmt.Println("3. Email")
+	fmt.Println("4. Phone")
+	fmt.Println("5. Vet")
+	fmt.Println("6. Species")
+	fmt.Println("7. Appointment type")
+	fmt.Println("8. Status")
+	fmt.Println("9. Date range")
+	fmt.Println("10. Clear filters")
+	fmt.Println("11. Run search")
+	fmt.Println("12. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// getFilterText is a helper function that prompts for a free text filter value.
+// An empty input clears the filter.
+func getFilterText(scanner *bufio.Scanner, label string) string {
+	fmt.Printf("Please enter %s to search for, or leave blank to clear:\n", label)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// getFilterOption is a helper function that prompts the user to choose a filter value from a list of options.
+// Choosing "Any" clears the filter.
+func getFilterOption(scanner *bufio.Scanner, label string, options []string) (string, error) {
+	fmt.Printf("Please choose %s:\n", label)
+
+	for i, v := range options {
+		fmt.Printf("%d. %s\n", i+1, v)
+	}
+	fmt.Printf("%d. Any\n", len(options)+1)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > len(options)+1 {
+		return "", fmt.Errorf("please select one of the options displayed")
+	}
+
+	if choice == len(options)+1 {
+		return "", nil
+	}
+	return options[choice-1], nil
+}
+
+// getFilterDateRange is a helper function that prompts for the first and last day to search between.
+// Either date can be left blank to leave that end of the range open.
+func getFilterDateRange(scanner *bufio.Scanner) (time.Time, time.Time, error) {
+	layout := "2006-01-02"
+
+	fmt.Println("Please enter the first date (YYYY-MM-DD), or leave blank for no limit:")
+	fmt.Print("> ")
+	scanner.Scan()
+	fromInput := strings.TrimSpace(scanner.Text())
+
+	fmt.Println("Please enter the last date (YYYY-MM-DD), or leave blank for no limit:")
+	fmt.Print("> ")
+	scanner.Scan()
+	toInput := strings.TrimSpace(scanner.Text())
+
+	var from, to time.Time
+	var err error
+
+	if fromInput != "" {
+		from, err = time.ParseInLocation(layout, fromInput, clinicLocation)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid first date format")
+		}
+	}
+
+	if toInput != "" {
+		to, err = time.ParseInLocation(layout, toInput, clinicLocation)
+		if err != nil {
+			return time.Time{}, time.Time{}, fmt.Errorf("invalid last date format")
+		}
+		// The last day is included in the search, so the range ends at the start of the next day.
+		to = to.AddDate(0, 0, 1)
+	}
+
+	if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+		return time.Time{}, time.Time{}, fmt.Errorf("first date must not be after last date")
+	}
+
+	return from, to, nil
+}
+
+// runSearch lets the user build up a set of filters and run a search on all appointments.
+func runSearch(scanner *bufio.Scanner, db *sql.DB) {
+	var f appointmentFilter
+
+	for {
+		switch searchMenu(scanner, &f) {
+		case "1":
+			f.petName = getFilterText(scanner, "a pet name")
+		case "2":
+			f.ownerLast = getFilterText(scanner, "an owner surname")
+		case "3":
+			f.email = strings.ToLower(getFilterText(scanner, "an email address"))
+		case "4":
+			f.phone = strings.ReplaceAll(getFilterText(scanner, "a phone number"), " ", "")
+		case "5":
+			for {
+				v, err := getFilterOption(scanner, "a vet", allowedVets)
+				if err == nil {
+					f.vet = v
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+		case "6":
+			for {
+				v, err := getFilterOption(scanner, "a species", allowedSpecies)
+				if err == nil {
+					f.species = v
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+		case "7":
+			for {
+				v, err := getFilterOption(scanner, "an appointment type", allowedAppointmentTypes)
+				if err == nil {
+					f.appointType = v
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+		case "8":
+			for {
+				v, err := getFilterOption(scanner, "a status", allowedStatuses)
+				if err == nil {
+					f.status = v
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+		case "9":
+			for {
+				from, to, err := getFilterDateRange(scanner)
+				if err == nil {
+					f.from = from
+					f.to = to
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+		case "10":
+			f = appointmentFilter{}
+		case "11":
+			where, args := f.where()
+			total, err := countAppointments(db, where, args...)
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			if total == 0 {
+				fmt.Println("No appointments match those filters.")
+				continue
+			}
+
+			var format appointmentFormat
+			for {
+				v, err := getAppointmentFormat(scanner)
+				if err == nil {
+					format = v
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+
+			pageAppointments(scanner, total, format, func(offset int) ([]appointment, error) {
+				return searchAppointments(db, &f, offset)
+			})
+		case "12":
+			return
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
@@ -0,0 +1,557 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// maxOccurrences is the most appointments a single series can contain.
+const maxOccurrences = 52
+
+// appointmentSeries is a struct that links the appointments booked together as a repeating series.
+// A series that has not been saved yet has an ID of 0.
+type appointmentSeries struct {
+	id   int
+	rule string
+}
+
+// recurrence is a struct that holds how often a series repeats and when it stops.
+// Exactly one of count and until is set.
+type recurrence struct {
+	freq     string
+	interval int
+	count    int
+	until    time.Time
+}
+
+// everyPattern matches repeats typed as "every 3 weeks, 4 times" or "every 2 weeks until 2026-12-01".
+var everyPattern = regexp.MustCompile(`^every\s+(\d+\s+)?(day|week|month)s?\s*,?\s*(?:(\d+)\s+times|until\s+(\d{4}-\d{2}-\d{2}))$`)
+
+// parseRecurrence reads a repeat rule, either in words ("every 3 weeks, 4 times") or as an RRULE ("FREQ=WEEKLY;INTERVAL=3;COUNT=4").
+// The count includes the first appointment.
+func parseRecurrence(input string) (recurrence, error) {
+	input = strings.TrimSpace(input)
+	upper := strings.ToUpper(strings.TrimPrefix(strings.TrimPrefix(input, "RRULE:"), "rrule:"))
+
+	var r recurrence
+
+	if strings.HasPrefix(upper, "FREQ=") {
+		r.interval = 1
+		for _, part := range strings.Split(upper, ";") {
+			key, value, _ := strings.Cut(part, "=")
+			switch key {
+			case "FREQ":
+				r.freq = value
+			case "INTERVAL":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return recurrence{}, fmt.Errorf("INTERVAL must be a positive number")
+				}
+				r.interval = n
+			case "COUNT":
+				n, err := strconv.Atoi(value)
+				if err != nil || n < 1 {
+					return recurrence{}, fmt.Errorf("COUNT must be a positive number")
+				}
+				r.count = n
+			case "UNTIL":
+				t, err := time.ParseInLocation("20060102", value[:min(len(value), 8)], clinicLocation)
+				if err != nil {
+					return recurrence{}, fmt.Errorf("UNTIL must be a date such as 20261201")
+				}
+				r.until = t
+			case "":
+			default:
+				return recurrence{}, fmt.Errorf("%s is not supported in repeat rules", key)
+			}
+		}
+	} else {
+		m := everyPattern.FindStringSubmatch(strings.ToLower(input))
+		if m == nil {
+			return recurrence{}, fmt.Errorf("could not understand the repeat, try something like \"every 3 weeks, 4 times\"")
+		}
+
+		r.interval = 1
+		if m[1] != "" {
+			r.interval, _ = strconv.Atoi(strings.TrimSpace(m[1]))
+		}
+
+		switch m[2] {
+		case "day":
+			r.freq = "DAILY"
+		case "week":
+			r.freq = "WEEKLY"
+		case "month":
+			r.freq = "MONTHLY"
+		}
+
+		if m[3] != "" {
+			r.count, _ = strconv.Atoi(m[3])
+		} else {
+			r.until, _ = time.ParseInLocation("2006-01-02", m[4], clinicLocation)
+		}
+	}
+
+	switch r.freq {
+	case "DAILY", "WEEKLY", "MONTHLY":
+	case "":
+		return recurrence{}, fmt.Errorf("FREQ must be given")
+	default:
+		return recurrence{}, fmt.Errorf("FREQ must be DAILY, WEEKLY or MONTHLY")
+	}
+
+	if r.interval < 1 {
+		return recurrence{}, fmt.Errorf("the repeat interval must be at least 1")
+	}
+	if r.count == 0 && r.until.IsZero() {
+		return recurrence{}, fmt.Errorf("please say how many times the appointment repeats, or until when")
+	}
+	if r.count != 0 && !r.until.IsZero() {
+		return recurrence{}, fmt.Errorf("please give either a number of times or an end date, not both")
+	}
+	if r.count > maxOccurrences {
+		return recurrence{}, fmt.Errorf("a series can have at most %d appointments", maxOccurrences)
+	}
+
+	return r, nil
+}
+
+// String prints the recurrence as an RRULE, which is how it is stored.
+func (r recurrence) String() string {
+	s := fmt.Sprintf("FREQ=%s;INTERVAL=%d", r.freq, r.interval)
+	if r.count != 0 {
+		s += fmt.Sprintf(";COUNT=%d", r.count)
+	} else {
+		s += ";UNTIL=" + r.until.Format("20060102")
+	}
+	return s
+}
+
+// occurrences lists the wall-clock times of every appointment in the series after the first one.
+// Each occurrence keeps the first appointment's clock time, even across daylight saving changes.
+func (r recurrence) occurrences(first time.Time) []time.Time {
+	first = first.In(clinicLocation)
+
+	var times []time.Time
+	for k := 1; len(times)+1 < maxOccurrences; k++ {
+		if r.count != 0 && k >= r.count {
+			break
+		}
+
+		var d time.Time
+		switch r.freq {
+		case "DAILY":
+			d = first.AddDate(0, 0, k*r.interval)
+		case "WEEKLY":
+			d = first.AddDate(0, 0, 7*k*r.interval)
+		case "MONTHLY":
+			d = first.AddDate(0, k*r.interval, 0)
+		}
+
+		// The until date includes the whole of that day.
+		if !r.until.IsZero() && !d.Before(r.until.AddDate(0, 0, 1)) {
+			break
+		}
+
+		times = append(times, time.Date(d.Year(), d.Month(), d.Day(), first.Hour(), first.Minute(), 0, 0, time.UTC))
+	}
+	return times
+}
+
+// getRecurrence is a helper function that prompts the user for how often an appointment repeats.
+func getRecurrence(scanner *bufio.Scanner) (recurrence, error) {
+	fmt.Println("How often should it repeat? For example: every 3 weeks, 4 times / every month until 2027-06-01 / FREQ=WEEKLY;INTERVAL=3;COUNT=4")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return parseRecurrence(scanner.Text())
+}
+
+// occurrenceAlternative is a struct that holds a slot offered in place of an occurrence that clashes.
+type occurrenceAlternative struct {
+	vet string
+	t   time.Time
+}
+
+// getOccurrenceAlternative is a helper function that lists the alternatives for a clashing occurrence and asks the user to pick one.
+// false is returned if the user chooses to skip the occurrence.
+func getOccurrenceAlternative(scanner *bufio.Scanner, alternatives []occurrenceAlternative) (occurrenceAlternative, bool, error) {
+	for i, alt := range alternatives {
+		fmt.Printf("%d. %s with %s\n", i+1, alt.t.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04 MST"), alt.vet)
+	}
+	fmt.Printf("%d. Skip this occurrence\n", len(alternatives)+1)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(alternatives)+1 {
+		return occurrenceAlternative{}, false, fmt.Errorf("please select one of the options displayed")
+	}
+
+	if choice == len(alternatives)+1 {
+		return occurrenceAlternative{}, false, nil
+	}
+	return alternatives[choice-1], true, nil
+}
+
+// findAlternatives suggests slots for an occurrence that cannot go ahead at wall.
+// The same vet's next free slots are suggested first, then any other vet who is free at the same time.
+func findAlternatives(db *sql.DB, vet string, wall time.Time, t time.Time, pending []appointment) ([]occurrenceAlternative, error) {
+	after := time.Date(wall.Year(), wall.Month(), wall.Day(), 0, 0, 0, 0, clinicLocation)
+	if !t.IsZero() {
+		after = t
+	}
+
+	free, err := nextFreeSlots(db, vet, after, 3, pending)
+	if err != nil {
+		return nil, err
+	}
+
+	var alternatives []occurrenceAlternative
+	for _, f := range free {
+		alternatives = append(alternatives, occurrenceAlternative{vet: vet, t: f})
+	}
+
+	if t.IsZero() || checkClinicHours(t) != nil {
+		return alternatives, nil
+	}
+
+	for _, other := range allowedVets {
+		if other == vet {
+			continue
+		}
+		taken, err := slotTaken(db, other, t, 0, pending)
+		if err != nil {
+			return nil, err
+		}
+		if !taken {
+			alternatives = append(alternatives, occurrenceAlternative{vet: other, t: t})
+		}
+	}
+	return alternatives, nil
+}
+
+// holdOccurrence clash-checks one occurrence of a series and holds its slot.
+// a carries the occurrence's vet and pet, and wall is the wall-clock time it should be at.
+// If the slot cannot be used, the clash is reported along with alternatives for the user to choose from.
+// The occurrence is returned with its time, vet and hold filled in, or false if the user skipped it.
+func holdOccurrence(scanner *bufio.Scanner, db *sql.DB, userID int, a appointment, wall time.Time, pending []appointment) (appointment, bool) {
+	var t time.Time
+	var problem string
+
+	times := clinicTimes(wall.Year(), wall.Month(), wall.Day(), wall.Hour(), wall.Minute())
+	switch {
+	case len(times) == 0:
+		problem = "that time does not exist because the clocks go forward"
+	case checkClinicHours(times[0]) != nil:
+		t = times[0]
+		problem = checkClinicHours(t).Error()
+	default:
+		t = times[0]
+		holdID, _, err := placeHold(db, userID, a.vet, t, a.id, pending)
+		if err == nil {
+			a.dateTime = t
+			a.holdID = holdID
+			return a, true
+		}
+		problem = a.vet + " is not available"
+	}
+
+	for {
+		fmt.Printf("The appointment on %s clashes: %s.\n", wall.Format("Monday, 02 Jan 2006 at 15:04"), problem)
+
+		alternatives, err := findAlternatives(db, a.vet, wall, t, pending)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return a, false
+		}
+
+		alt, ok, err := getOccurrenceAlternative(scanner, alternatives)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if !ok {
+			return a, false
+		}
+
+		holdID, _, err := placeHold(db, userID, alt.vet, alt.t, a.id, pending)
+		if err != nil {
+			problem = alt.vet + " is no longer available at " + alt.t.In(clinicLocation).Format("15:04")
+			continue
+		}
+
+		a.vet = alt.vet
+		a.dateTime = alt.t
+		a.holdID = holdID
+		return a, true
+	}
+}
+
+// bookSeries asks how the first appointment should repeat and holds a slot for every later occurrence.
+// Each occurrence is clash-checked on its own, and ones the user skips are left out.
+// The first appointment is updated to belong to the new series, and the later occurrences are returned.
+func bookSeries(scanner *bufio.Scanner, db *sql.DB, userID int, first *appointment, pending []appointment) []appointment {
+	var r recurrence
+	for {
+		rec, err := getRecurrence(scanner)
+		if err == nil {
+			r = rec
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	first.series = &appointmentSeries{rule: r.String()}
+
+	var booked []appointment
+	for _, wall := range r.occurrences(first.dateTime) {
+		a := *first
+		a.holdID = 0
+
+		a, ok := holdOccurrence(scanner, db, userID, a, wall, append(pending, booked...))
+		if !ok {
+			fmt.Println("Skipped the appointment on", wall.Format("Monday, 02 Jan 2006")+".")
+			continue
+		}
+		booked = append(booked, a)
+	}
+
+	fmt.Printf("%d appointments in this series.\n", len(booked)+1)
+	return booked
+}
+
+// insertSeries saves a new series inside the caller's transaction, if it has not been saved already.
+// Every appointment in the series shares the same struct, so they all see its ID once it is saved.
+func insertSeries(tx *sql.Tx, userID int, s *appointmentSeries) error {
+	if s == nil || s.id != 0 {
+		return nil
+	}
+
+	return tx.QueryRow(
+		`INSERT INTO appointment_series (user_id, rule) VALUES ($1, $2) RETURNING id`,
+		userID,
+		s.rule,
+	).Scan(&s.id)
+}
+
+// seriesID returns the database value for an appointment's series, which is NULL when it is not part of one.
+func (a *appointment) seriesID() sql.NullInt64 {
+	if a.series == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: int64(a.series.id), Valid: true}
+}
+
+// getSeriesScope is a helper function that asks whether a change applies to one appointment or to the rest of its series.
+// true is returned for the whole series.
+func getSeriesScope(scanner *bufio.Scanner, action string) (bool, error) {
+	fmt.Println("This appointment is part of a repeating series. What would you like to " + action + "?")
+	fmt.Println("1. Only this appointment")
+	fmt.Println("2. This and every other upcoming appointment in the series")
+	fmt.Print("> ")
+
+	scanner.Scan()
+
+	switch strings.TrimSpace(scanner.Text()) {
+	case "1":
+		return false, nil
+	case "2":
+		return true, nil
+	default:
+		return false, fmt.Errorf("please select 1 or 2")
+	}
+}
+
+// getUpcomingSeries fetches the upcoming appointments in a series that are still booked, soonest first.
+func getUpcomingSeries(db queryer, userID int, seriesID int) ([]appointment, error) {
+	return queryAppointments(db,
+		`WHERE a.user_id = $1 AND a.series_id = $2 AND a.status = 'Booked' AND a.appointment_time >= now()
+		 ORDER BY a.appointment_time`,
+		userID,
+		seriesID,
+	)
+}
+
+// cancelSeries cancels every upcoming appointment in a series and offers each freed slot to the waitlist.
+// Anything paid for the cancelled appointments is refunded under the cancellation policy.
+func cancelSeries(db *sql.DB, channels []channel, userID int, seriesID int) {
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	now := time.Now()
+	rows, err := tx.Query(
+		`UPDATE appointments
+		 SET status = 'Cancelled', cancelled_at = $3,
+			late_cancellation = appointment_time < $3::timestamptz + $4 * interval '1 hour'
+		 WHERE user_id = $1 AND series_id = $2 AND status = 'Booked' AND appointment_time >= $3
+		 RETURNING id, vet_name, appointment_time, late_cancellation`,
+		userID,
+		seriesID,
+		now,
+		clinicPrices.CancellationPolicy.FreeNoticeHours,
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	var ids []int
+	var late []bool
+	var freed []occurrenceAlternative
+	for rows.Next() {
+		var id int
+		var l bool
+		var f occurrenceAlternative
+		if err := rows.Scan(&id, &f.vet, &f.t, &l); err != nil {
+			rows.Close()
+			fmt.Println("Error:", err)
+			return
+		}
+		ids = append(ids, id)
+		late = append(late, l)
+		freed = append(freed, f)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	var refunded, fees int64
+	for i, id := range ids {
+		refund, fee, err := settleCancellation(tx, userID, id, freed[i].t, now, late[i])
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		refunded += refund
+		fees += fee
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Printf("%d appointments cancelled.\n", len(freed))
+	if refunded > 0 {
+		fmt.Println("You will be refunded", formatMoney(refunded, clinicPrices.Currency)+".")
+	}
+	if fees > 0 {
+		fmt.Println("Late cancellation fees of", formatMoney(fees, clinicPrices.Currency), "have been added to your account.")
+	}
+
+	for _, f := range freed {
+		releaseSlot(db, channels, f.vet, f.t)
+	}
+}
+
+// shiftOccurrence works out where an occurrence moves to when one appointment in its series moves from old to moved.
+// The occurrence moves by the same number of days and takes the new clock time.
+func shiftOccurrence(t, old, moved time.Time) time.Time {
+	t = t.In(clinicLocation)
+	old = old.In(clinicLocation)
+	moved = moved.In(clinicLocation)
+
+	oldDay := time.Date(old.Year(), old.Month(), old.Day(), 0, 0, 0, 0, time.UTC)
+	movedDay := time.Date(moved.Year(), moved.Month(), moved.Day(), 0, 0, 0, 0, time.UTC)
+	days := int(movedDay.Sub(oldDay).Hours() / 24)
+
+	d := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, time.UTC).AddDate(0, 0, days)
+	return time.Date(d.Year(), d.Month(), d.Day(), moved.Hour(), moved.Minute(), 0, 0, time.UTC)
+}
+
+// rescheduleSeries moves the rest of a series in step with one appointment that has already been given a new time.
+// Each other occurrence is clash-checked on its own; ones the user skips keep their current time.
+// All the moves are saved in one transaction, confirmations are sent, and the old slots are offered to the waitlist.
+func rescheduleSeries(scanner *bufio.Scanner, db *sql.DB, channels []channel, userID int, moved appointment, old time.Time) {
+	others, err := getUpcomingSeries(db, userID, moved.series.id)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	changes := []appointment{moved}
+	previous := []appointment{{vet: moved.vet, dateTime: old}}
+
+	for _, o := range others {
+		if o.id == moved.id {
+			continue
+		}
+
+		wall := shiftOccurrence(o.dateTime, old, moved.dateTime)
+		n, ok := holdOccurrence(scanner, db, userID, o, wall, changes)
+		if !ok {
+			fmt.Println("Kept the appointment on", o.dateTime.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04")+".")
+			continue
+		}
+
+		changes = append(changes, n)
+		previous = append(previous, o)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	defer tx.Rollback()
+
+	if err := releaseHolds(tx, changes); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	// An occurrence that was cancelled or changed since the series was listed is left as it is, and only the slots of the ones moved are freed.
+	var confirmations []string
+	var freed []appointment
+	for i, c := range changes {
+		res, err := tx.Exec(
+			`UPDATE appointments
+			 SET appointment_time = $1, vet_name = $2
+			 WHERE id = $3 AND user_id = $4 AND status = 'Booked'`,
+			c.dateTime,
+			c.vet,
+			c.id,
+			userID,
+		)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		if n, err := res.RowsAffected(); err != nil || n == 0 {
+			fmt.Printf("Sorry, the appointment on %s can no longer be rescheduled, so it was not moved.\n",
+				previous[i].dateTime.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04"))
+			continue
+		}
+
+		text, err := queueConfirmation(tx, channels, c.owner, c)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		confirmations = append(confirmations, text)
+		freed = append(freed, previous[i])
+	}
+
+	if err := tx.Commit(); err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	showConfirmations(db, channels, confirmations)
+
+	for _, p := range freed {
+		releaseSlot(db, channels, p.vet, p.dateTime)
+	}
+}
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseRecurrence(t *testing.T) {
+	defer func(loc *time.Location) { clinicLocation = loc }(clinicLocation)
+	clinicLocation = time.UTC
+
+	tests := []struct {
+		input   string
+		want    string
+		wantErr bool
+	}{
+		{"every 3 weeks, 4 times", "FREQ=WEEKLY;INTERVAL=3;COUNT=4", false},
+		{"Every week 6 times", "FREQ=WEEKLY;INTERVAL=1;COUNT=6", false},
+		{"every 2 days, 5 times", "FREQ=DAILY;INTERVAL=2;COUNT=5", false},
+		{"every month until 2027-06-01", "FREQ=MONTHLY;INTERVAL=1;UNTIL=20270601", false},
+		{"FREQ=WEEKLY;INTERVAL=3;COUNT=4", "FREQ=WEEKLY;INTERVAL=3;COUNT=4", false},
+		{"RRULE:FREQ=MONTHLY;COUNT=12", "FREQ=MONTHLY;INTERVAL=1;COUNT=12", false},
+		{"rrule:freq=daily;interval=2;until=20261201T000000Z", "FREQ=DAILY;INTERVAL=2;UNTIL=20261201", false},
+		{"FREQ=WEEKLY;COUNT=52", "FREQ=WEEKLY;INTERVAL=1;COUNT=52", false},
+		{"FREQ=WEEKLY;COUNT=53", "", true},
+		{"every week, 53 times", "", true},
+		{"every 0 weeks, 4 times", "", true},
+		{"FREQ=WEEKLY;INTERVAL=0;COUNT=4", "", true},
+		{"FREQ=WEEKLY;COUNT=0", "", true},
+		{"FREQ=WEEKLY;COUNT=x", "", true},
+		{"FREQ=WEEKLY", "", true},
+		{"INTERVAL=2;COUNT=4", "", true},
+		{"FREQ=YEARLY;COUNT=4", "", true},
+		{"FREQ=WEEKLY;BYDAY=MO;COUNT=4", "", true},
+		{"FREQ=WEEKLY;UNTIL=December", "", true},
+		{"FREQ=WEEKLY;COUNT=4;UNTIL=20261201", "", true},
+		{"every fortnight, 4 times", "", true},
+		{"every week", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRecurrence(tt.input)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("parseRecurrence(%q) error = %v, want error %v", tt.input, err, tt.wantErr)
+			continue
+		}
+		if err == nil && r.String() != tt.want {
+			t.Errorf("parseRecurrence(%q) = %s, want %s", tt.input, r, tt.want)
+		}
+	}
+}
+
+func TestOccurrences(t *testing.T) {
+	defer func(loc *time.Location) { clinicLocation = loc }(clinicLocation)
+	clinicLocation = time.UTC
+
+	first := time.Date(2026, 3, 10, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		rule  string
+		count int
+		last  string
+	}{
+		{"FREQ=WEEKLY;INTERVAL=3;COUNT=4", 3, "2026-05-12 09:30"},
+		{"FREQ=DAILY;COUNT=1", 0, ""},
+		{"FREQ=DAILY;COUNT=52", 51, "2026-04-30 09:30"},
+		{"FREQ=MONTHLY;UNTIL=20260610", 3, "2026-06-10 09:30"},
+		{"FREQ=MONTHLY;UNTIL=20260609", 2, "2026-05-10 09:30"},
+		{"FREQ=DAILY;UNTIL=20291231", maxOccurrences - 1, "2026-04-30 09:30"},
+	}
+
+	for _, tt := range tests {
+		r, err := parseRecurrence(tt.rule)
+		if err != nil {
+			t.Fatalf("parseRecurrence(%q): %v", tt.rule, err)
+		}
+
+		times := r.occurrences(first)
+		if len(times) != tt.count {
+			t.Errorf("%s: %d occurrences after the first, want %d", tt.rule, len(times), tt.count)
+			continue
+		}
+		if len(times) > 0 {
+			if last := times[len(times)-1].Format("2006-01-02 15:04"); last != tt.last {
+				t.Errorf("%s: last occurrence is %s, want %s", tt.rule, last, tt.last)
+			}
+		}
+	}
+}
@@ -0,0 +1,235 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// slotSearchDays is how many days ahead nextFreeSlots looks for free slots.
+const slotSearchDays = 14
+
+// checkClinicHours returns an error if t is not the start of one of the clinic's appointment slots.
+func checkClinicHours(t time.Time) error {
+	t = t.In(clinicLocation)
+	open := time.Date(t.Year(), t.Month(), t.Day(), openingHour, 0, 0, 0, clinicLocation)
+	closing := time.Date(t.Year(), t.Month(), t.Day(), closingHour, 0, 0, 0, clinicLocation)
+
+	if t.Before(open) || t.Add(slotLength).After(closing) {
+		return fmt.Errorf("appointments must be between %02d:00 and %02d:00", openingHour, closingHour)
+	}
+	if t.Sub(open)%slotLength != 0 {
+		return fmt.Errorf("appointments start every %d minutes from %02d:00", int(slotLength.Minutes()), openingHour)
+	}
+	return nil
+}
+
+// overlaps reports whether appointments starting at a and b would overlap.
+func overlaps(a, b time.Time) bool {
+	return a.Before(b.Add(slotLength)) && b.Before(a.Add(slotLength))
+}
+
+// busyTimes fetches the start times that are unavailable for a vet between from and to.
+// A slot is unavailable when it has an appointment that has not been cancelled, while it is being offered to someone on the waitlist,
+// or while it is held for an owner who is part way through booking it.
+// excludeID leaves out one appointment, so an appointment being rescheduled does not clash with itself.
+func busyTimes(db queryer, vet string, from, to time.Time, excludeID int) ([]time.Time, error) {
+	rows, err := db.Query(
+		`SELECT appointment_time
+		 FROM appointments
+		 WHERE vet_name = $1 AND status <> 'Cancelled' AND id <> $4
+		   AND appointment_time > $2 AND appointment_time < $3
+		 UNION ALL
+		 SELECT offered_time
+		 FROM waitlist_offers
+		 WHERE vet_name = $1 AND status = 'Offered' AND expires_at > now()
+		   AND offered_time > $2 AND offered_time < $3
+		 UNION ALL
+		 SELECT appointment_time
+		 FROM slot_holds
+		 WHERE vet_name = $1 AND expires_at > now()
+		   AND appointment_time > $2 AND appointment_time < $3`,
+		vet,
+		from.Add(-slotLength),
+		to.Add(slotLength),
+		excludeID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var times []time.Time
+	for rows.Next() {
+		var t time.Time
+		if err := rows.Scan(&t); err != nil {
+			return nil, err
+		}
+		times = append(times, t)
+	}
+	return times, rows.Err()
+}
+
+// slotTaken reports whether a vet is unavailable at t, either in the database or in the list of appointments still being booked.
+func slotTaken(db queryer, vet string, t time.Time, excludeID int, pending []appointment) (bool, error) {
+	for _, a := range pending {
+		if a.vet == vet && overlaps(a.dateTime, t) {
+			return true, nil
+		}
+	}
+
+	busy, err := busyTimes(db, vet, t, t, excludeID)
+	if err != nil {
+		return false, err
+	}
+	return len(busy) > 0, nil
+}
+
+// nextFreeSlots finds up to n free slots for a vet starting from after, looking up to slotSearchDays ahead.
+// Slots reserved for emergencies are never offered.
+func nextFreeSlots(db queryer, vet string, after time.Time, n int, pending []appointment) ([]time.Time, error) {
+	if now := time.Now(); after.Before(now) {
+		after = now
+	}
+	after = after.In(clinicLocation)
+	end := after.AddDate(0, 0, slotSearchDays)
+
+	reserved, err := loadEmergencySlots()
+	if err != nil {
+		return nil, err
+	}
+
+	busy, err := busyTimes(db, vet, after, end, 0)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range pending {
+		if a.vet == vet {
+			busy = append(busy, a.dateTime)
+		}
+	}
+
+	var free []time.Time
+
+	day := time.Date(after.Year(), after.Month(), after.Day(), 0, 0, 0, 0, clinicLocation)
+	for ; day.Before(end) && len(free) < n; day = day.AddDate(0, 0, 1) {
+		open := time.Date(day.Year(), day.Month(), day.Day(), openingHour, 0, 0, 0, clinicLocation)
+		closing := time.Date(day.Year(), day.Month(), day.Day(), closingHour, 0, 0, 0, clinicLocation)
+
+		for slot := open; slot.Before(closing) && len(free) < n; slot = slot.Add(slotLength) {
+			if slot.Before(after) || isEmergencySlot(slot, reserved) {
+				continue
+			}
+
+			taken := false
+			for _, b := range busy {
+				if overlaps(b, slot) {
+					taken = true
+					break
+				}
+			}
+			if !taken {
+				free = append(free, slot)
+			}
+		}
+	}
+
+	return free, nil
+}
+
+// getSlotAlternative is a helper function that is called when the time the user wanted is not available.
+// It lists the vet's next free slots for the user to pick from, along with choosing another time or, if allowed, joining the waitlist.
+// The chosen slot is returned; a zero time means the user wants to enter another time, and waitlist is true if they would rather join the waitlist.
+func getSlotAlternative(scanner *bufio.Scanner, free []time.Time, allowWaitlist bool) (t time.Time, waitlist bool, err error) {
+	fmt.Println("Next available times:")
+	for i, f := range free {
+		fmt.Printf("%d. %s\n", i+1, f.Format("Monday, 02 Jan 2006 at 15:04 MST"))
+	}
+	fmt.Printf("%d. Enter a different time\n", len(free)+1)
+
+	options := len(free) + 1
+	if allowWaitlist {
+		fmt.Printf("%d. Join the waitlist\n", len(free)+2)
+		options++
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	choice, err := strconv.Atoi(input)
+	if err != nil || choice < 1 || choice > options {
+		return time.Time{}, false, fmt.Errorf("please select one of the options displayed")
+	}
+
+	switch {
+	case choice <= len(free):
+		return free[choice-1], false, nil
+	case choice == len(free)+1:
+		return time.Time{}, false, nil
+	default:
+		return time.Time{}, true, nil
+	}
+}
+
+// getAvailableDateTime asks for the appointment time until the user picks a slot that is free for their chosen vet.
+// If the time they ask for is taken, the vet's next free slots are offered instead.
+// The chosen slot is held for the user straight away, so it cannot be taken while they finish booking.
+// When a is an existing appointment being moved, its own slot does not count as taken.
+// pending holds the appointments already chosen earlier in the same booking, which are not in the database yet.
+// The time and the ID of its hold are returned, or false if the user joined the waitlist instead of booking a time.
+func getAvailableDateTime(scanner *bufio.Scanner, db *sql.DB, userID int, i int, a appointment, pending []appointment, allowWaitlist bool) (time.Time, int, bool) {
+	for {
+		dt, err := getPreferredDateTime(scanner, i)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+
+		for {
+			holdID, expires, err := placeHold(db, userID, a.vet, dt, a.id, pending)
+			if err == nil {
+				fmt.Println("This time is being held for you until", expires.In(clinicLocation).Format("15:04")+".")
+				return dt, holdID, true
+			}
+			if !errors.Is(err, errSlotTaken) {
+				fmt.Println("Error:", err)
+				break
+			}
+
+			fmt.Println("Sorry,", a.vet, "is not available at", dt.In(clinicLocation).Format("15:04 on Monday, 02 Jan 2006")+".")
+
+			free, err := nextFreeSlots(db, a.vet, dt, 5, pending)
+			if err != nil {
+				fmt.Println("Error:", err)
+				break
+			}
+
+			var t time.Time
+			var waitlist bool
+			for {
+				t, waitlist, err = getSlotAlternative(scanner, free, allowWaitlist)
+				if err == nil {
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+
+			if waitlist {
+				a.dateTime = dt
+				joinWaitlist(scanner, db, userID, a)
+				return time.Time{}, 0, false
+			}
+			if t.IsZero() {
+				break
+			}
+
+			// The slot picked from the list is held in the same way, in case it has gone in the meantime.
+			dt = t
+		}
+	}
+}
@@ -0,0 +1,293 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timelineWidth is the width details are wrapped to in a pet's timeline, so it fits across a PDF page.
+const timelineWidth = 80
+
+// timelineEvent is a struct that holds one entry in a pet's medical history, such as an appointment or a vaccination.
+type timelineEvent struct {
+	at      time.Time
+	kind    string
+	summary string
+	details []string
+}
+
+// getPetAppointments fetches a pet's past appointments that were not cancelled, oldest first.
+func getPetAppointments(db queryer, userID int, petName string) ([]appointment, error) {
+	return queryAppointments(db,
+		`WHERE a.user_id = $1 AND lower(a.pet_name) = lower($2) AND a.status <> 'Cancelled' AND a.appointment_time < now()
+		 ORDER BY a.appointment_time, a.id`,
+		userID,
+		petName,
+	)
+}
+
+// getPetTimeline gathers a pet's past appointments, clinical notes, prescriptions, vaccinations and weight readings into one list, oldest first.
+// Weights are the ones recorded when each appointment was booked; a reading is only listed when it differs from the one before.
+// Owners only see notes that have been signed and shared with them; staff see the latest version of every appointment's notes.
+func getPetTimeline(db *sql.DB, userID int, p pet, forOwner bool) ([]timelineEvent, error) {
+	appts, err := getPetAppointments(db, userID, p.name)
+	if err != nil {
+		return nil, err
+	}
+
+	var events []timelineEvent
+	var lastWeight float64
+	ids := make([]int, len(appts))
+
+	for i, a := range appts {
+		ids[i] = a.id
+		events = append(events, timelineEvent{
+			at:      a.dateTime,
+			kind:    "Appointment",
+			summary: fmt.Sprintf("%s with %s (%s)", a.appointmentType, a.vet, a.status),
+		})
+
+		if a.pet.weightKg != lastWeight {
+			summary := fmt.Sprintf("%.2f kg", a.pet.weightKg)
+			if lastWeight != 0 {
+				summary += fmt.Sprintf(" (%+.2f kg)", a.pet.weightKg-lastWeight)
+			}
+			events = append(events, timelineEvent{at: a.dateTime, kind: "Weight", summary: summary})
+			lastWeight = a.pet.weightKg
+		}
+	}
+
+	notes, err := getLatestNotes(db, ids, forOwner)
+	if err != nil {
+		return nil, err
+	}
+	for _, a := range appts {
+		n, ok := notes[a.id]
+		if !ok {
+			continue
+		}
+
+		e := timelineEvent{at: a.dateTime, kind: "Notes", summary: fmt.Sprintf("%s appointment, by %s", a.appointmentType, n.author)}
+		if !forOwner {
+			if n.signed() {
+				e.summary += fmt.Sprintf(", version %d", n.version)
+			} else {
+				e.summary += fmt.Sprintf(", version %d (draft, not signed)", n.version)
+			}
+			if n.amendmentReason != "" {
+				e.details = append(e.details, "Amended: "+n.amendmentReason)
+			}
+		}
+		for _, section := range n.noteSections() {
+			if *section.value != "" {
+				e.details = append(e.details, section.name+": "+*section.value)
+			}
+		}
+		events = append(events, e)
+	}
+
+	prescriptions, err := getPrescriptions(db, ids)
+	if err != nil {
+		return nil, err
+	}
+	for _, rx := range prescriptions {
+		i := slices.IndexFunc(appts, func(a appointment) bool { return a.id == rx.appointmentID })
+		e := timelineEvent{at: appts[i].dateTime, kind: "Prescription", summary: rx.drugString()}
+		e.details = append(e.details, rx.doseString()+", by "+rx.prescribedBy)
+		if rx.instructions != "" {
+			e.details = append(e.details, rx.instructions)
+		}
+		events = append(events, e)
+	}
+
+	vaccinations, err := getVaccinations(db, userID, p.name)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range vaccinations {
+		e := timelineEvent{at: v.givenOn, kind: "Vaccination", summary: v.vaccine}
+		if v.batch != "" {
+			e.details = append(e.details, "Batch: "+v.batch)
+		}
+		e.details = append(e.details, "Next due: "+v.nextDue.Format("02 Jan 2006"))
+		events = append(events, e)
+	}
+
+	// Events on the same appointment keep the order they were added in: the appointment, then the weight, the notes and any prescriptions.
+	slices.SortStableFunc(events, func(a, b timelineEvent) int { return a.at.Compare(b.at) })
+	return events, nil
+}
+
+// wrapText breaks text into lines of at most width characters, splitting between words.
+func wrapText(text string, width int) []string {
+	var lines []string
+	var line string
+	for _, word := range strings.Fields(text) {
+		if line != "" && len(line)+1+len(word) > width {
+			lines = append(lines, line)
+			line = ""
+		}
+		if line != "" {
+			line += " "
+		}
+		line += word
+	}
+	if line != "" {
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+// timelineString prints a pet's timeline, with the owner's details at the top so it can be handed to another clinic.
+func timelineString(p pet, owner user, events []timelineEvent) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += fmt.Sprintf("Medical history for %s (%s)\n", p.name, p.species)
+	s += fmt.Sprintf("Owner: %s %s, %s, %s\n", owner.firstName, owner.lastName, owner.phone, owner.email)
+	s += fmt.Sprintf("Produced on %s\n", time.Now().In(clinicLocation).Format("02 Jan 2006"))
+	s += "-------------------------------------\n"
+
+	if len(events) == 0 {
+		s += "Nothing on record yet.\n"
+	}
+	for _, e := range events {
+		s += fmt.Sprintf("%s  %-12s  %s\n", e.at.In(clinicLocation).Format("02 Jan 2006"), e.kind, e.summary)
+		for _, d := range e.details {
+			for _, line := range wrapText(d, timelineWidth-4) {
+				s += "    " + line + "\n"
+			}
+		}
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// exportTimeline renders a pet's timeline as a single document, as plain text or a PDF.
+func exportTimeline(p pet, owner user, events []timelineEvent, format string) ([]byte, error) {
+	switch format {
+	case "text":
+		return []byte(timelineString(p, owner, events)), nil
+
+	case "pdf":
+		text := strings.Trim(timelineString(p, owner, events), "\n")
+		return textPDF("Medical history for "+p.name, strings.Split(text, "\n")), nil
+
+	default:
+		return nil, fmt.Errorf("unknown format %q, expected text or pdf", format)
+	}
+}
+
+// timelineFileName is the file a pet's timeline is exported to when no other name is given, such as "Rex-history.pdf".
+func timelineFileName(p pet, format string) string {
+	return exportFileName(strings.ReplaceAll(p.name, " ", "-")+"-history", format)
+}
+
+// viewPetHistory is called when the user selects "Pet history" in the appointment menu, and when staff select "View a pet's history".
+// The chosen pet's timeline is shown and can be exported as a single document, for example when the owner moves clinic.
+// Owners see notes the clinic has shared with them; staff see every pet's latest notes, including drafts.
+func viewPetHistory(scanner *bufio.Scanner, db *sql.DB, owner user, userID int, forOwner bool) {
+	pets, err := getUserPets(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(pets) == 0 {
+		fmt.Println("There are no pets on record yet.")
+		return
+	}
+
+	for {
+		p, ok, err := getPetChoice(scanner, pets)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if !ok {
+			return
+		}
+
+		events, err := getPetTimeline(db, userID, p, forOwner)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Print(timelineString(p, owner, events))
+
+		var format string
+		for {
+			f, err := getExportFormat(scanner, "Export this history:", "Don't export", "text", "pdf")
+			if err == nil {
+				format = f
+				break
+			}
+			fmt.Println("Error:", err)
+		}
+		if format == "" {
+			continue
+		}
+
+		b, err := exportTimeline(p, owner, events, format)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+
+		if err := writeExport(b, timelineFileName(p, format), "History"); err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+}
+
+// runHistoryCommand handles the "history" command, which staff use to look at or hand over a pet's records.
+//   - history show <login ID> <pet name>: print a pet's timeline
+//   - history export <login ID> <pet name> text|pdf [file]: save a pet's timeline to a file, or print it when the file is "-"
+func runHistoryCommand(db *sql.DB, args []string) error {
+	if len(args) < 3 {
+		return fmt.Errorf("usage: history show <login ID> <pet name> | history export <login ID> <pet name> text|pdf [file]")
+	}
+
+	userID, err := strconv.Atoi(args[1])
+	if err != nil || userID <= 0 {
+		return fmt.Errorf("login ID must be a positive number")
+	}
+
+	owner, p, err := getOwnerPet(db, userID, args[2])
+	if err != nil {
+		return err
+	}
+
+	events, err := getPetTimeline(db, userID, p, false)
+	if err != nil {
+		return err
+	}
+
+	switch args[0] {
+	case "show":
+		fmt.Print(timelineString(p, owner, events))
+		return nil
+
+	case "export":
+		if len(args) < 4 {
+			return fmt.Errorf("usage: history export <login ID> <pet name> text|pdf [file]")
+		}
+		b, err := exportTimeline(p, owner, events, args[3])
+		if err != nil {
+			return err
+		}
+
+		name := timelineFileName(p, args[3])
+		if len(args) > 4 {
+			name = args[4]
+		}
+		return writeExport(b, name, "History")
+
+	default:
+		return fmt.Errorf("unknown history command %q, expected show or export", args[0])
+	}
+}
@@ -0,0 +1,659 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// coreVaccines lists the vaccines every pet of a species needs to be kept up to date with.
+// Species that are not listed have no core vaccines, so their owners are still asked whether the pet is vaccinated.
+var coreVaccines = map[string][]string{
+	"Dog":    {"DHP", "Leptospirosis"},
+	"Cat":    {"Feline enteritis/flu"},
+	"Rabbit": {"Myxomatosis/RHD"},
+}
+
+// vaccineValidity is how long after it is given each core vaccine stays current, used as the default next due date.
+// Vaccines that are not listed default to one year.
+var vaccineValidity = map[string]time.Duration{
+	"DHP":                  3 * 365 * 24 * time.Hour,
+	"Leptospirosis":        365 * 24 * time.Hour,
+	"Feline enteritis/flu": 365 * 24 * time.Hour,
+	"Myxomatosis/RHD":      365 * 24 * time.Hour,
+}
+
+// vaccination is a struct that holds one vaccine given to a pet.
+// Pets do not have their own table, so records are kept against the owner and the pet's name.
+// This information is stored in the vaccinations table in the database.
+type vaccination struct {
+	id         int
+	userID     int
+	petName    string
+	petSpecies string
+	vaccine    string
+	givenOn    time.Time
+	batch      string
+	nextDue    time.Time
+	owner      user
+}
+
+// getVaccinations fetches a pet's vaccination history, most recent first.
+func getVaccinations(db queryer, userID int, petName string) ([]vaccination, error) {
+	rows, err := db.Query(
+		`SELECT id, user_id, pet_name, pet_species, vaccine, given_on, batch_number, next_due
+		 FROM vaccinations
+		 WHERE user_id = $1 AND lower(pet_name) = lower($2)
+		 ORDER BY given_on DESC, id DESC`,
+		userID,
+		petName,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var records []vaccination
+	for rows.Next() {
+		var v vaccination
+		err := rows.Scan(&v.id, &v.userID, &v.petName, &v.petSpecies, &v.vaccine, &v.givenOn, &v.batch, &v.nextDue)
+		if err != nil {
+			return nil, err
+		}
+		v.givenOn, v.nextDue = clinicDate(v.givenOn), clinicDate(v.nextDue)
+		records = append(records, v)
+	}
+	return records, rows.Err()
+}
+
+// missingVaccines lists the core vaccines for a species that are not current on today's date.
+// Only the latest dose of each vaccine counts, so an old overdue dose does not matter once a newer one is given.
+func missingVaccines(species string, records []vaccination, today time.Time) []string {
+	latest := make(map[string]vaccination)
+	for _, v := range records {
+		if l, ok := latest[v.vaccine]; !ok || v.givenOn.After(l.givenOn) {
+			latest[v.vaccine] = v
+		}
+	}
+
+	var missing []string
+	for _, name := range coreVaccines[species] {
+		v, ok := latest[name]
+		if !ok || v.nextDue.Before(today) {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// vaccinesCurrent works out whether a pet's core vaccines are all current from its history.
+// false is returned as the second value when it cannot be worked out, because the species has no core vaccines or the pet has no history.
+func vaccinesCurrent(species string, records []vaccination, today time.Time) (bool, bool) {
+	if len(coreVaccines[species]) == 0 || len(records) == 0 {
+		return false, false
+	}
+	return len(missingVaccines(species, records, today)) == 0, true
+}
+
+// getPetVaccinationStatus works out whether a pet being booked is vaccinated.
+// When the pet has a vaccination history the answer comes from whether its core vaccines are current;
+// otherwise the owner is asked, as there is nothing to go on.
+func getPetVaccinationStatus(scanner *bufio.Scanner, db *sql.DB, userID int, p pet, i int) bool {
+	records, err := getVaccinations(db, userID, p.name)
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+
+	if current, ok := vaccinesCurrent(p.species, records, clinicToday()); ok {
+		if current {
+			fmt.Println(p.name, "is up to date with their core vaccines.")
+		} else {
+			fmt.Println(p.name, "is due for:", strings.Join(missingVaccines(p.species, records, clinicToday()), ", "))
+		}
+		return current
+	}
+
+	for {
+		vaccinated, err := getVaccinationStatus(scanner, i)
+		if err == nil {
+			return vaccinated
+		}
+		fmt.Println("Error:", err)
+	}
+}
+
+// getUserPets fetches the name and species of every pet a user has booked or has vaccination records for.
+func getUserPets(db *sql.DB, userID int) ([]pet, error) {
+	rows, err := db.Query(
+		`SELECT DISTINCT ON (lower(pet_name)) pet_name, pet_species
+		 FROM (
+			SELECT pet_name, pet_species, appointment_time AS seen FROM appointments WHERE user_id = $1
+			UNION ALL
+			SELECT pet_name, pet_species, given_on::timestamptz AS seen FROM vaccinations WHERE user_id = $1
+		 ) p
+		 ORDER BY lower(pet_name), seen DESC`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var pets []pet
+	for rows.Next() {
+		var p pet
+		if err := rows.Scan(&p.name, &p.species); err != nil {
+			return nil, err
+		}
+		pets = append(pets, p)
+	}
+	return pets, rows.Err()
+}
+
+// getOwnerPet looks up an owner by login ID and one of their pets by name, for commands that take both as arguments.
+// The pet's name is matched ignoring case.
+func getOwnerPet(db *sql.DB, userID int, name string) (user, pet, error) {
+	var owner user
+	err := db.QueryRow(
+		`SELECT first_name, last_name, phone, email FROM users WHERE id = $1`,
+		userID,
+	).Scan(&owner.firstName, &owner.lastName, &owner.phone, &owner.email)
+	if err == sql.ErrNoRows {
+		return user{}, pet{}, fmt.Errorf("no user found with that ID")
+	}
+	if err != nil {
+		return user{}, pet{}, err
+	}
+
+	pets, err := getUserPets(db, userID)
+	if err != nil {
+		return user{}, pet{}, err
+	}
+	i := slices.IndexFunc(pets, func(p pet) bool { return strings.EqualFold(p.name, name) })
+	if i < 0 {
+		return user{}, pet{}, fmt.Errorf("no pet called %q on record for this owner", name)
+	}
+	return owner, pets[i], nil
+}
+
+// getPetChoice is a helper function that lists a user's pets and asks them to pick one.
+// false is returned if the user chooses to go back.
+func getPetChoice(scanner *bufio.Scanner, pets []pet) (pet, bool, error) {
+	fmt.Println("Please choose a pet:")
+	for i, p := range pets {
+		fmt.Printf("%d. %s (%s)\n", i+1, p.name, p.species)
+	}
+	fmt.Printf("%d. Back\n", len(pets)+1)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(pets)+1 {
+		return pet{}, false, fmt.Errorf("please select one of the pets displayed")
+	}
+
+	if choice == len(pets)+1 {
+		return pet{}, false, nil
+	}
+	return pets[choice-1], true, nil
+}
+
+// vaccinationHistoryString prints a pet's vaccination history and whether its core vaccines are current.
+func vaccinationHistoryString(p pet, records []vaccination, today time.Time) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += fmt.Sprintf("Vaccinations for %s (%s):\n", p.name, p.species)
+
+	if len(records) == 0 {
+		s += "No vaccinations recorded.\n"
+	}
+	for _, v := range records {
+		due := v.nextDue.Format("2006-01-02")
+		if v.nextDue.Before(today) {
+			due += " (overdue)"
+		}
+		s += fmt.Sprintf("%s  %-22s batch %-12s next due %s\n", v.givenOn.Format("2006-01-02"), v.vaccine, v.batch, due)
+	}
+
+	if len(coreVaccines[p.species]) > 0 {
+		if missing := missingVaccines(p.species, records, today); len(missing) > 0 {
+			s += fmt.Sprintf("Core vaccines due: %s\n", strings.Join(missing, ", "))
+		} else {
+			s += "Core vaccines are up to date.\n"
+		}
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// viewVaccinations is called when the user selects "Vaccination records" in the appointment menu.
+// It shows the vaccination history of one of the user's pets.
+func viewVaccinations(scanner *bufio.Scanner, db *sql.DB, userID int) {
+	pets, err := getUserPets(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(pets) == 0 {
+		fmt.Println("You have no pets on record yet.")
+		return
+	}
+
+	for {
+		p, ok, err := getPetChoice(scanner, pets)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if !ok {
+			return
+		}
+
+		records, err := getVaccinations(db, userID, p.name)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+		fmt.Print(vaccinationHistoryString(p, records, clinicToday()))
+	}
+}
+
+// getRecordDate is a helper function that prompts for a date on a record, which may be in the past.
+// An empty input uses the given default date.
+func getRecordDate(scanner *bufio.Scanner, prompt string, def time.Time) (time.Time, error) {
+	fmt.Printf("%s (YYYY-MM-DD), or leave blank for %s:\n", prompt, def.Format("2006-01-02"))
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if input == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseInLocation("2006-01-02", input, clinicLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date format")
+	}
+	return d, nil
+}
+
+// getVaccine is a helper function that prompts for the vaccine given, listing the core vaccines for the pet's species.
+// Any other vaccine can be typed in by name.
+func getVaccine(scanner *bufio.Scanner, species string) (string, error) {
+	fmt.Println("Which vaccine was given? Choose one or type its name:")
+	for i, v := range coreVaccines[species] {
+		fmt.Printf("%d. %s\n", i+1, v)
+	}
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if choice, err := strconv.Atoi(input); err == nil {
+		if choice < 1 || choice > len(coreVaccines[species]) {
+			return "", fmt.Errorf("please select one of the vaccines displayed")
+		}
+		return coreVaccines[species][choice-1], nil
+	}
+
+	if input == "" {
+		return "", fmt.Errorf("vaccine name cannot be empty")
+	}
+	if len(input) > 50 {
+		return "", fmt.Errorf("character limit is 50 characters")
+	}
+	return input, nil
+}
+
+// getStaffOwner is a helper function that asks staff for an owner's login ID and looks the owner up.
+func getStaffOwner(scanner *bufio.Scanner, db *sql.DB) (user, int, error) {
+	fmt.Println("Please enter the owner's login ID:")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	id, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || id <= 0 {
+		return user{}, 0, fmt.Errorf("login ID must be a positive number")
+	}
+
+	var u user
+	err = db.QueryRow(
+		`SELECT first_name, last_name, phone, email FROM users WHERE id = $1`,
+		id,
+	).Scan(&u.firstName, &u.lastName, &u.phone, &u.email)
+	if err == sql.ErrNoRows {
+		return user{}, 0, fmt.Errorf("no user found with that ID")
+	}
+	if err != nil {
+		return user{}, 0, err
+	}
+	return u, id, nil
+}
+
+// recordVaccination is called when staff select "Record a vaccination" in the vaccinations menu.
+// Staff pick the owner and pet, then enter the vaccine, the date it was given, its batch number and when it is next due.
+func recordVaccination(scanner *bufio.Scanner, db *sql.DB) {
+	var u user
+	var userID int
+	for {
+		o, id, err := getStaffOwner(scanner, db)
+		if err == nil {
+			u, userID = o, id
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	pets, err := getUserPets(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+	if len(pets) == 0 {
+		fmt.Println(u.firstName, u.lastName, "has no pets on record yet.")
+		return
+	}
+
+	var p pet
+	for {
+		c, ok, err := getPetChoice(scanner, pets)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if !ok {
+			return
+		}
+		p = c
+		break
+	}
+
+	v := vaccination{userID: userID, petName: p.name, petSpecies: p.species}
+
+	for {
+		name, err := getVaccine(scanner, p.species)
+		if err == nil {
+			v.vaccine = name
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	for {
+		d, err := getRecordDate(scanner, "Date given", clinicToday())
+		if err == nil && d.After(clinicToday()) {
+			err = fmt.Errorf("date given cannot be in the future")
+		}
+		if err == nil {
+			v.givenOn = d
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	// Listing the batches in stock helps staff record a batch number the inventory knows, so the dose is taken from it when the appointment is completed.
+	batches, err := vaccineBatches(db, v.vaccine, v.givenOn)
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	for _, b := range batches {
+		fmt.Printf("In stock: %s batch %s (expires %s, %d left)\n", b.itemName, b.batchNumber, b.expiresOn.Format("02 Jan 2006"), b.quantityLeft)
+	}
+
+	fmt.Println("Batch number:")
+	fmt.Print("> ")
+	scanner.Scan()
+	v.batch = strings.TrimSpace(scanner.Text())
+
+	validity, ok := vaccineValidity[v.vaccine]
+	if !ok {
+		validity = 365 * 24 * time.Hour
+	}
+	def := v.givenOn.AddDate(0, 0, int(validity.Hours()/24))
+
+	for {
+		d, err := getRecordDate(scanner, "Next due date", def)
+		if err == nil && !d.After(v.givenOn) {
+			err = fmt.Errorf("next due date must be after the date given")
+		}
+		if err == nil {
+			v.nextDue = d
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	_, err = db.Exec(
+		`INSERT INTO vaccinations (user_id, pet_name, pet_species, vaccine, given_on, batch_number, next_due)
+		 VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		v.userID,
+		v.petName,
+		v.petSpecies,
+		v.vaccine,
+		v.givenOn.Format("2006-01-02"),
+		v.batch,
+		v.nextDue.Format("2006-01-02"),
+	)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	fmt.Println("Vaccination recorded.")
+}
+
+// getVaccinationsDue fetches the latest dose of each vaccine per pet that is overdue or falls due on or before until, soonest first.
+// Pets that already have an upcoming vaccination appointment booked are left out.
+func getVaccinationsDue(db queryer, until time.Time) ([]vaccination, error) {
+	rows, err := db.Query(
+		`SELECT d.id, d.user_id, d.pet_name, d.pet_species, d.vaccine, d.given_on, d.batch_number, d.next_due,
+		        u.first_name, u.last_name, u.phone, u.email
+		 FROM (
+			SELECT DISTINCT ON (user_id, lower(pet_name), vaccine) *
+			FROM vaccinations
+			ORDER BY user_id, lower(pet_name), vaccine, given_on DESC, id DESC
+		 ) d
+		 JOIN users u ON u.id = d.user_id
+		 WHERE d.next_due <= $1
+		   AND NOT EXISTS (
+			SELECT 1 FROM appointments a
+			WHERE a.user_id = d.user_id AND lower(a.pet_name) = lower(d.pet_name)
+			  AND a.appointment_type = 'Vaccination' AND a.status = 'Booked' AND a.appointment_time > now()
+		   )
+		 ORDER BY d.next_due, d.user_id, d.pet_name`,
+		until.Format("2006-01-02"),
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var due []vaccination
+	for rows.Next() {
+		var v vaccination
+		err := rows.Scan(
+			&v.id, &v.userID, &v.petName, &v.petSpecies, &v.vaccine, &v.givenOn, &v.batch, &v.nextDue,
+			&v.owner.firstName, &v.owner.lastName, &v.owner.phone, &v.owner.email,
+		)
+		if err != nil {
+			return nil, err
+		}
+		v.givenOn, v.nextDue = clinicDate(v.givenOn), clinicDate(v.nextDue)
+		due = append(due, v)
+	}
+	return due, rows.Err()
+}
+
+// vaccinationsDueString prints the vaccinations due report.
+func vaccinationsDueString(due []vaccination, today time.Time) string {
+	var s string
+	s = "-------------------------------------\n"
+	s += "Vaccinations due:\n"
+
+	if len(due) == 0 {
+		s += "None.\n"
+	}
+	for _, v := range due {
+		status := "due"
+		if v.nextDue.Before(today) {
+			status = "overdue"
+		}
+		s += fmt.Sprintf("%s  %-7s  %s (%s), %s - owner %s %s, %s\n",
+			v.nextDue.Format("2006-01-02"), status, v.petName, v.petSpecies, v.vaccine,
+			v.owner.firstName, v.owner.lastName, v.owner.phone)
+	}
+	s += "-------------------------------------\n"
+
+	return s
+}
+
+// loadVaccinationNotice reads how many days before a vaccination falls due the owner is reminded to book, from the VACCINATION_NOTICE_DAYS environment variable.
+// The default is 30 days.
+func loadVaccinationNotice() (int, error) {
+	value := os.Getenv("VACCINATION_NOTICE_DAYS")
+	if value == "" {
+		return 30, nil
+	}
+
+	days, err := strconv.Atoi(value)
+	if err != nil || days < 0 {
+		return 0, fmt.Errorf("invalid VACCINATION_NOTICE_DAYS %q", value)
+	}
+	return days, nil
+}
+
+// vaccinationDueMessage builds the reminder asking an owner to book a vaccination that is coming due, addressed through the given channel.
+func vaccinationDueMessage(v vaccination, c channel, today time.Time) outboxMessage {
+	body := fmt.Sprintf("Hi %s,\n\n", v.owner.firstName)
+	if v.nextDue.Before(today) {
+		body += fmt.Sprintf("%s's %s vaccination was due on %s.\n\n", v.petName, v.vaccine, v.nextDue.Format("Monday, 02 Jan 2006"))
+	} else {
+		body += fmt.Sprintf("%s's %s vaccination is due on %s.\n\n", v.petName, v.vaccine, v.nextDue.Format("Monday, 02 Jan 2006"))
+	}
+	body += "Please book a vaccination appointment to keep them protected.\n"
+
+	return outboxMessage{
+		userID:    v.userID,
+		kind:      "vaccination-due",
+		channel:   c.name(),
+		recipient: c.recipient(v.owner),
+		subject:   fmt.Sprintf("Vaccination due: %s", v.petName),
+		body:      body,
+		dedupeKey: fmt.Sprintf("vaccination-due:%d:%s:%s", v.id, v.nextDue.Format("2006-01-02"), c.name()),
+	}
+}
+
+// enqueueVaccinationReminders queues a reminder on every channel for each vaccination falling due within the notice period.
+// Each dose is only reminded about once; the number of new messages queued is returned.
+func enqueueVaccinationReminders(db *sql.DB, channels []channel) (int, error) {
+	days, err := loadVaccinationNotice()
+	if err != nil {
+		return 0, err
+	}
+
+	today := clinicToday()
+	due, err := getVaccinationsDue(db, today.AddDate(0, 0, days))
+	if err != nil {
+		return 0, err
+	}
+
+	queued := 0
+	for _, v := range due {
+		for _, c := range channels {
+			added, err := enqueueMessage(db, vaccinationDueMessage(v, c, today))
+			if err != nil {
+				return 0, err
+			}
+			if added {
+				queued++
+			}
+		}
+	}
+	return queued, nil
+}
+
+// vaccinationsMenu is a function that displays the staff vaccinations menu.
+func vaccinationsMenu(scanner *bufio.Scanner) string {
+	fmt.Println("1. Record a vaccination")
+	fmt.Println("2. View a pet's vaccinations")
+	fmt.Println("3. Vaccinations due")
+	fmt.Println("4. Back")
+	fmt.Print("> ")
+
+	scanner.Scan()
+	return strings.TrimSpace(scanner.Text())
+}
+
+// manageVaccinations is called when staff select "Vaccinations" in the staff menu.
+func manageVaccinations(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		switch vaccinationsMenu(scanner) {
+		case "1":
+			recordVaccination(scanner, db)
+
+		case "2":
+			var userID int
+			for {
+				_, id, err := getStaffOwner(scanner, db)
+				if err == nil {
+					userID = id
+					break
+				}
+				fmt.Println("Error:", err)
+			}
+			viewVaccinations(scanner, db, userID)
+
+		case "3":
+			days, err := loadVaccinationNotice()
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			due, err := getVaccinationsDue(db, clinicToday().AddDate(0, 0, days))
+			if err != nil {
+				fmt.Println("Error:", err)
+				continue
+			}
+			fmt.Print(vaccinationsDueString(due, clinicToday()))
+
+		case "4":
+			return
+
+		default:
+			fmt.Println("Invalid option, please try again.")
+		}
+	}
+}
+
+// runVaccinationsCommand handles the "vaccinations" command.
+//   - vaccinations due [days]: print the vaccinations falling due within the given number of days (default VACCINATION_NOTICE_DAYS)
+func runVaccinationsCommand(db *sql.DB, args []string) error {
+	if len(args) == 0 || args[0] != "due" {
+		return fmt.Errorf("usage: vaccinations due [days]")
+	}
+
+	days, err := loadVaccinationNotice()
+	if err != nil {
+		return err
+	}
+	if len(args) > 1 {
+		days, err = strconv.Atoi(args[1])
+		if err != nil || days < 0 {
+			return fmt.Errorf("days must be a number that is 0 or more")
+		}
+	}
+
+	due, err := getVaccinationsDue(db, clinicToday().AddDate(0, 0, days))
+	if err != nil {
+		return err
+	}
+	fmt.Print(vaccinationsDueString(due, clinicToday()))
+	return nil
+}
@@ -0,0 +1,603 @@
+package main
+
+import (
+	"bufio"
+	"database/sql"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// waitlistEntry is a struct that holds an owner's request to be offered a slot with a vet if one comes free.
+// The pet's details are kept so the appointment can be booked as soon as the owner accepts an offer.
+type waitlistEntry struct {
+	id              int
+	userID          int
+	pet             pet
+	appointmentType string
+	vet             string
+	windowStart     time.Time
+	windowEnd       time.Time
+	priority        int
+	owner           user
+}
+
+// waitlistOffer is a struct that holds a freed slot offered to someone on the waitlist.
+// The slot is held for them until the offer expires.
+type waitlistOffer struct {
+	id          int
+	entry       waitlistEntry
+	offeredTime time.Time
+	expiresAt   time.Time
+}
+
+// loadOfferHold reads how long a freed slot is held for a waitlisted owner from the WAITLIST_OFFER_HOLD environment variable.
+// The default is 2 hours.
+func loadOfferHold() (time.Duration, error) {
+	value := os.Getenv("WAITLIST_OFFER_HOLD")
+	if value == "" {
+		return 2 * time.Hour, nil
+	}
+
+	d, err := time.ParseDuration(value)
+	if err != nil || d <= 0 {
+		return 0, fmt.Errorf("invalid WAITLIST_OFFER_HOLD %q", value)
+	}
+	return d, nil
+}
+
+// getWaitlistDate is a helper function that prompts for one end of the dates a waitlisted owner could attend.
+// An empty input uses the given default date.
+func getWaitlistDate(scanner *bufio.Scanner, prompt string, def time.Time) (time.Time, error) {
+	fmt.Printf("%s (YYYY-MM-DD), or leave blank for %s:\n", prompt, def.Format("2006-01-02"))
+	fmt.Print("> ")
+
+	scanner.Scan()
+	input := strings.TrimSpace(scanner.Text())
+
+	if input == "" {
+		return def, nil
+	}
+
+	d, err := time.ParseInLocation("2006-01-02", input, clinicLocation)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid date format")
+	}
+	if d.Before(clinicToday()) {
+		return time.Time{}, fmt.Errorf("date cannot be in the past")
+	}
+	return d, nil
+}
+
+// joinWaitlist adds the user to the waitlist for the vet and appointment type in a, for a range of dates they choose.
+// The dates default to the day of the time they originally asked for.
+func joinWaitlist(scanner *bufio.Scanner, db *sql.DB, userID int, a appointment) {
+	t := a.dateTime.In(clinicLocation)
+	requestedDay := time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, clinicLocation)
+
+	var start time.Time
+	for {
+		d, err := getWaitlistDate(scanner, "Please enter the first date you could attend", requestedDay)
+		if err == nil {
+			start = d
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	var end time.Time
+	for {
+		d, err := getWaitlistDate(scanner, "Please enter the last date you could attend", start)
+		if err != nil {
+			fmt.Println("Error:", err)
+			continue
+		}
+		if d.Before(start) {
+			fmt.Println("Error: last date cannot be before the first date")
+			continue
+		}
+		end = d
+		break
+	}
+
+	_, err := db.Exec(
+		`INSERT INTO waitlist (
+			user_id,
+			pet_name,
+			pet_species,
+			pet_age,
+			pet_weight,
+			vaccinated,
+			appointment_type,
+			vet_name,
+			window_start,
+			window_end
+		) VALUES ($1,$2,$3,$4,$5,$6,$7,$8,$9,$10)`,
+		userID,
+		a.pet.name,
+		a.pet.species,
+		a.pet.age,
+		a.pet.weightKg,
+		a.pet.vaccinated,
+		a.appointmentType,
+		a.vet,
+		start,
+		end.AddDate(0, 0, 1),
+	)
+	if err != nil {
+		fmt.Println("Error: could not join the waitlist:", err)
+		return
+	}
+
+	fmt.Printf("%s has been added to the waitlist for %s between %s and %s.\n",
+		a.pet.name, a.vet, start.Format("02 Jan 2006"), end.Format("02 Jan 2006"))
+	fmt.Println("If a slot comes free we will send you an offer, which you can accept from the appointment menu.")
+}
+
+// offerFreedSlot offers a slot that has just come free to the first matching owner on the waitlist.
+// Entries are matched on vet and date range and taken in priority order, then oldest first.
+// Owners who have already been offered this slot, or who are holding another offer, are skipped.
+// The slot is held for the owner until the offer expires, and they are notified on every channel.
+func offerFreedSlot(db *sql.DB, channels []channel, vet string, t time.Time) error {
+	if t.Before(time.Now()) {
+		return nil
+	}
+
+	// Slots reserved for emergencies go back to being reserved rather than to the waitlist.
+	reserved, err := loadEmergencySlots()
+	if err != nil {
+		return err
+	}
+	if isEmergencySlot(t, reserved) {
+		return nil
+	}
+
+	hold, err := loadOfferHold()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	taken, err := slotTaken(tx, vet, t, 0, nil)
+	if err != nil || taken {
+		return err
+	}
+
+	var e waitlistEntry
+	err = tx.QueryRow(
+		`SELECT w.id, w.user_id, w.pet_name, w.appointment_type, u.first_name, u.last_name, u.phone, u.email
+		 FROM waitlist w
+		 JOIN users u ON u.id = w.user_id
+		 WHERE w.status = 'Waiting' AND w.vet_name = $1
+		   AND w.window_start <= $2 AND w.window_end > $2
+		   AND NOT EXISTS (
+			SELECT 1 FROM waitlist_offers o
+			WHERE o.waitlist_id = w.id
+			  AND ((o.vet_name = $1 AND o.offered_time = $2) OR (o.status = 'Offered' AND o.expires_at > now()))
+		   )
+		 ORDER BY w.priority DESC, w.created_at, w.id
+		 LIMIT 1
+		 FOR UPDATE OF w SKIP LOCKED`,
+		vet,
+		t,
+	).Scan(&e.id, &e.userID, &e.pet.name, &e.appointmentType, &e.owner.firstName, &e.owner.lastName, &e.owner.phone, &e.owner.email)
+	if err == sql.ErrNoRows {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	// An offer never outlasts the slot itself.
+	expires := time.Now().Add(hold)
+	if t.Before(expires) {
+		expires = t
+	}
+
+	var offerID int
+	err = tx.QueryRow(
+		`INSERT INTO waitlist_offers (waitlist_id, vet_name, offered_time, expires_at)
+		 VALUES ($1, $2, $3, $4)
+		 RETURNING id`,
+		e.id,
+		vet,
+		t,
+		expires,
+	).Scan(&offerID)
+	if err != nil {
+		return err
+	}
+
+	when := t.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04 MST")
+
+	body := fmt.Sprintf("Hi %s,\n\n", e.owner.firstName)
+	body += fmt.Sprintf("A %s slot with %s has come free for %s on %s.\n\n", e.appointmentType, vet, e.pet.name, when)
+	body += fmt.Sprintf("We are holding it for you until %s. Log in and choose \"Waitlist offers\" to accept or decline it.\n",
+		expires.In(clinicLocation).Format("15:04 on Monday, 02 Jan"))
+
+	for _, c := range channels {
+		_, err := enqueueMessage(tx, outboxMessage{
+			userID:    e.userID,
+			kind:      "waitlist_offer",
+			channel:   c.name(),
+			recipient: c.recipient(e.owner),
+			subject:   fmt.Sprintf("Appointment available: %s on %s", vet, t.In(clinicLocation).Format("Mon 02 Jan at 15:04")),
+			body:      body,
+			dedupeKey: fmt.Sprintf("waitlist_offer:%d:%s", offerID, c.name()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// releaseSlot is called whenever an appointment slot is given up, such as when an appointment is cancelled or moved.
+// The slot is offered to the waitlist and any notification is sent straight away where possible.
+func releaseSlot(db *sql.DB, channels []channel, vet string, t time.Time) {
+	if err := offerFreedSlot(db, channels, vet, t); err != nil {
+		fmt.Println("Error: could not offer the freed slot to the waitlist:", err)
+		return
+	}
+
+	if _, _, err := deliverPending(db, channels); err != nil {
+		fmt.Println("Error: could not send notifications:", err)
+	}
+}
+
+// expireWaitlistOffers closes offers that were not answered in time and offers each of those slots to the next owner in line.
+// The number of offers expired is returned.
+func expireWaitlistOffers(db *sql.DB, channels []channel) (int, error) {
+	rows, err := db.Query(
+		`UPDATE waitlist_offers
+		 SET status = 'Expired'
+		 WHERE status = 'Offered' AND expires_at <= now()
+		 RETURNING vet_name, offered_time`,
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	type slot struct {
+		vet string
+		t   time.Time
+	}
+
+	var slots []slot
+	for rows.Next() {
+		var s slot
+		if err := rows.Scan(&s.vet, &s.t); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		slots = append(slots, s)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	for _, s := range slots {
+		if err := offerFreedSlot(db, channels, s.vet, s.t); err != nil {
+			return len(slots), err
+		}
+	}
+
+	return len(slots), nil
+}
+
+// getActiveOffers fetches the waitlist offers a user can still accept, soonest first.
+func getActiveOffers(db *sql.DB, userID int) ([]waitlistOffer, error) {
+	rows, err := db.Query(
+		`SELECT o.id, o.offered_time, o.expires_at,
+			w.id, w.user_id, w.pet_name, w.pet_species, w.pet_age, w.pet_weight, w.vaccinated, w.appointment_type, w.vet_name
+		 FROM waitlist_offers o
+		 JOIN waitlist w ON w.id = o.waitlist_id
+		 WHERE w.user_id = $1 AND o.status = 'Offered' AND o.expires_at > now()
+		 ORDER BY o.offered_time`,
+		userID,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var offers []waitlistOffer
+	for rows.Next() {
+		var o waitlistOffer
+		err := rows.Scan(
+			&o.id,
+			&o.offeredTime,
+			&o.expiresAt,
+			&o.entry.id,
+			&o.entry.userID,
+			&o.entry.pet.name,
+			&o.entry.pet.species,
+			&o.entry.pet.age,
+			&o.entry.pet.weightKg,
+			&o.entry.pet.vaccinated,
+			&o.entry.appointmentType,
+			&o.entry.vet,
+		)
+		if err != nil {
+			return nil, err
+		}
+		offers = append(offers, o)
+	}
+	return offers, rows.Err()
+}
+
+// summaryString prints a waitlist offer on one line.
+func (o *waitlistOffer) summaryString(i int) string {
+	return fmt.Sprintf("%d. %s for %s with %s on %s (held until %s)",
+		i,
+		o.entry.appointmentType,
+		o.entry.pet.name,
+		o.entry.vet,
+		o.offeredTime.In(clinicLocation).Format("Monday, 02 Jan 2006 at 15:04 MST"),
+		o.expiresAt.In(clinicLocation).Format("15:04 on 02 Jan"),
+	)
+}
+
+// acceptOffer books the slot from a waitlist offer and takes the owner off the waitlist.
+// The offer is checked again inside the transaction, so an offer that expired while the owner was deciding cannot be accepted.
+// The deposit is charged when the appointment type needs one, and the owner's questionnaire answers are saved with it, as for any other booking.
+func acceptOffer(db *sql.DB, channels []channel, u user, userID int, o waitlistOffer, answers []questionAnswer) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.Exec(
+		`UPDATE waitlist_offers
+		 SET status = 'Accepted'
+		 WHERE id = $1 AND status = 'Offered' AND expires_at > now()`,
+		o.id,
+	)
+	if err != nil {
+		return err
+	}
+	if n, err := res.RowsAffected(); err != nil {
+		return err
+	} else if n == 0 {
+		return fmt.Errorf("this offer has expired")
+	}
+
+	_, err = tx.Exec(`UPDATE waitlist SET status = 'Booked' WHERE id = $1`, o.entry.id)
+	if err != nil {
+		return err
+	}
+
+	a := appointment{
+		appointmentType: o.entry.appointmentType,
+		pet:             o.entry.pet,
+		vet:             o.entry.vet,
+		dateTime:        o.offeredTime,
+		status:          "Booked",
+		answers:         answers,
+	}
+
+	if err := insertAppointment(tx, userID, u, &a); err != nil {
+		return err
+	}
+
+	if _, err := recordDeposit(tx, a); err != nil {
+		return err
+	}
+
+	text, err := queueConfirmation(tx, channels, u, a)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+
+	showConfirmations(db, channels, []string{text})
+	return nil
+}
+
+// declineOffer turns down a waitlist offer and passes the slot on to the next owner in line.
+// The owner stays on the waitlist for other slots.
+func declineOffer(db *sql.DB, channels []channel, o waitlistOffer) error {
+	_, err := db.Exec(`UPDATE waitlist_offers SET status = 'Declined' WHERE id = $1 AND status = 'Offered'`, o.id)
+	if err != nil {
+		return err
+	}
+
+	releaseSlot(db, channels, o.entry.vet, o.offeredTime)
+	return nil
+}
+
+// respondToOffers is called when the user selects "Waitlist offers" in the appointment menu.
+// It lists the user's open offers and lets them accept or decline one.
+func respondToOffers(scanner *bufio.Scanner, db *sql.DB, channels []channel, u user, userID int) {
+	offers, err := getActiveOffers(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if len(offers) == 0 {
+		fmt.Println("You have no waitlist offers at the moment.")
+		return
+	}
+
+	fmt.Println("Please choose an offer:")
+	for i, o := range offers {
+		fmt.Println(o.summaryString(i + 1))
+	}
+	fmt.Printf("%d. Back\n", len(offers)+1)
+	fmt.Print("> ")
+
+	scanner.Scan()
+	choice, err := strconv.Atoi(strings.TrimSpace(scanner.Text()))
+	if err != nil || choice < 1 || choice > len(offers)+1 {
+		fmt.Println("Error: please select one of the options displayed")
+		return
+	}
+	if choice == len(offers)+1 {
+		return
+	}
+
+	o := offers[choice-1]
+
+	question := "Would you like to book this appointment?"
+	offered := appointment{appointmentType: o.entry.appointmentType, pet: o.entry.pet}
+	restricted, err := isRestricted(db, userID)
+	if err != nil {
+		fmt.Println("Error:", err)
+	}
+	if deposit, err := clinicPrices.depositFor(offered, restricted); err == nil && deposit > 0 {
+		fmt.Println(refundPolicyString())
+		question = "Would you like to book this appointment with a deposit of " + formatMoney(deposit, clinicPrices.Currency) + "? It is added to your account and is paid at the clinic before the appointment."
+	}
+
+	var accept bool
+	for {
+		a, err := getYesNo(scanner, question)
+		if err == nil {
+			accept = a
+			break
+		}
+		fmt.Println("Error:", err)
+	}
+
+	if accept {
+		answers := askQuestionnaire(scanner, offered)
+		err = acceptOffer(db, channels, u, userID, o, answers)
+	} else {
+		err = declineOffer(db, channels, o)
+	}
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	if !accept {
+		fmt.Println("Offer declined. You are still on the waitlist.")
+	}
+}
+
+// getWaitlist fetches every entry still waiting for a slot, in the order offers are made.
+func getWaitlist(db *sql.DB) ([]waitlistEntry, error) {
+	rows, err := db.Query(
+		`SELECT w.id, w.user_id, w.pet_name, w.pet_species, w.appointment_type, w.vet_name,
+			w.window_start, w.window_end, w.priority, u.first_name, u.last_name, u.phone, u.email
+		 FROM waitlist w
+		 JOIN users u ON u.id = w.user_id
+		 WHERE w.status = 'Waiting' AND w.window_end > now()
+		 ORDER BY w.priority DESC, w.created_at, w.id`,
+	)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var entries []waitlistEntry
+	for rows.Next() {
+		var e waitlistEntry
+		err := rows.Scan(
+			&e.id,
+			&e.userID,
+			&e.pet.name,
+			&e.pet.species,
+			&e.appointmentType,
+			&e.vet,
+			&e.windowStart,
+			&e.windowEnd,
+			&e.priority,
+			&e.owner.firstName,
+			&e.owner.lastName,
+			&e.owner.phone,
+			&e.owner.email,
+		)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
+
+// summaryString prints a waitlist entry on one line.
+func (e *waitlistEntry) summaryString(i int) string {
+	return fmt.Sprintf("%d. [priority %d] %s (%s) - %s with %s, %s to %s - Owner: %s %s, %s",
+		i,
+		e.priority,
+		e.pet.name,
+		e.pet.species,
+		e.appointmentType,
+		e.vet,
+		e.windowStart.In(clinicLocation).Format("02 Jan"),
+		e.windowEnd.In(clinicLocation).AddDate(0, 0, -1).Format("02 Jan 2006"),
+		e.owner.firstName,
+		e.owner.lastName,
+		e.owner.phone,
+	)
+}
+
+// manageWaitlist is called when staff select "Waitlist" in the staff menu.
+// It lists everyone waiting in the order they will be offered slots, and lets staff change an entry's priority or remove it.
+func manageWaitlist(scanner *bufio.Scanner, db *sql.DB) {
+	for {
+		entries, err := getWaitlist(db)
+		if err != nil {
+			fmt.Println("Error:", err)
+			return
+		}
+
+		if len(entries) == 0 {
+			fmt.Println("Nobody is on the waitlist.")
+			return
+		}
+
+		for i, e := range entries {
+			fmt.Println(e.summaryString(i + 1))
+		}
+		fmt.Println("Enter an entry number to change it, or leave blank to go back:")
+		fmt.Print("> ")
+
+		scanner.Scan()
+		input := strings.TrimSpace(scanner.Text())
+		if input == "" {
+			return
+		}
+
+		choice, err := strconv.Atoi(input)
+		if err != nil || choice < 1 || choice > len(entries) {
+			fmt.Println("Error: please select one of the entries displayed")
+			continue
+		}
+		e := entries[choice-1]
+
+		fmt.Println("Enter a new priority (higher is offered first), or \"remove\" to take the entry off the waitlist:")
+		fmt.Print("> ")
+		scanner.Scan()
+		input = strings.TrimSpace(scanner.Text())
+
+		if strings.EqualFold(input, "remove") {
+			_, err = db.Exec(`UPDATE waitlist SET status = 'Removed' WHERE id = $1`, e.id)
+		} else {
+			priority, convErr := strconv.Atoi(input)
+			if convErr != nil {
+				fmt.Println("Error: priority must be a whole number")
+				continue
+			}
+			_, err = db.Exec(`UPDATE waitlist SET priority = $1 WHERE id = $2`, priority, e.id)
+		}
+		if err != nil {
+			fmt.Println("Error:", err)
+		}
+	}
+}